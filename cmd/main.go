@@ -97,6 +97,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
@@ -104,7 +105,17 @@ import (
 	"mcp-gmail-server/internal/auth"
 	"mcp-gmail-server/internal/config"
 	"mcp-gmail-server/internal/db"
+	"mcp-gmail-server/internal/gmail"
+	"mcp-gmail-server/internal/llm"
+	"mcp-gmail-server/internal/mailsync"
+	"mcp-gmail-server/internal/search"
+	"mcp-gmail-server/internal/secrets"
+	"mcp-gmail-server/internal/sendqueue"
 	"mcp-gmail-server/internal/server"
+	"mcp-gmail-server/internal/throttle"
+	"mcp-gmail-server/internal/vector"
+
+	gmailapi "google.golang.org/api/gmail/v1"
 )
 
 func corsMiddleware(allowedOrigin string, next http.Handler) http.Handler {
@@ -112,7 +123,7 @@ func corsMiddleware(allowedOrigin string, next http.Handler) http.Handler {
 
 		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		if r.Method == http.MethodOptions {
@@ -127,12 +138,94 @@ func corsMiddleware(allowedOrigin string, next http.Handler) http.Handler {
 func main() {
 	cfg := config.LoadConfig()
 
-	// Initialize JWT
-	auth.InitJWT(cfg.JWTSecret)
+	// JWT signing keys (JWT_SIGNING_KEYS, or JWT_SECRET)
+	if err := auth.InitJWT(cfg); err != nil {
+		log.Fatalf("Invalid JWT signing keys: %v", err)
+	}
+
+	// Rules for new passwords (PASSWORD_MIN_LENGTH, PASSWORD_BREACH_DIR, ...)
+	if err := auth.InitPasswordPolicy(cfg); err != nil {
+		log.Fatalf("Invalid password policy: %v", err)
+	}
+
+	// Optional Gmail scopes must be in place before any OAuth config is built
+	if cfg.ModifyEnabled {
+		auth.AddGmailScope(gmail.ModifyScope)
+	}
+	if cfg.ComposeEnabled {
+		auth.AddGmailScope(gmail.ComposeScope)
+	}
+
+	// Credential encryption keys (TOKEN_ENCRYPTION_KEYS)
+	if err := secrets.LoadFromEnv(); err != nil {
+		log.Fatalf("Invalid token encryption keys: %v", err)
+	}
 
 	server.RegisterRoutes(cfg)
 	db.Init()
 
+	// Client addresses for throttling and sessions (TRUSTED_PROXY_HOPS)
+	auth.SetTrustedProxyHops(cfg.TrustedProxyHops)
+
+	// Login throttling; the db store shares counters between instances
+	switch cfg.ThrottleStore {
+	case "db":
+		auth.InitThrottle(throttle.NewDBStore(), cfg.LoginLockoutThreshold, cfg.LoginLockoutDuration)
+	case "memory":
+		auth.InitThrottle(throttle.NewMemoryStore(), cfg.LoginLockoutThreshold, cfg.LoginLockoutDuration)
+	default:
+		log.Fatalf("Invalid THROTTLE_STORE %q (memory or db)", cfg.ThrottleStore)
+	}
+
+	if secrets.Enabled() {
+		// Seal anything still stored in plaintext
+		if n, err := auth.EncryptExistingSecrets(); err != nil {
+			log.Printf("Encrypting stored credentials failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Encrypted credentials in %d rows", n)
+		}
+	} else {
+		log.Println("Warning: TOKEN_ENCRYPTION_KEYS not set, OAuth tokens are stored unencrypted")
+	}
+
+	// Workspace mailboxes reachable through domain-wide delegation
+	if cfg.ServiceAccountKey != "" {
+		if err := auth.LoadServiceAccount(cfg.ServiceAccountKey); err != nil {
+			log.Printf("Domain-wide delegation disabled: %v", err)
+		}
+	}
+
+	// Scheduled sends, retries and system mail
+	go sendqueue.Start(context.Background(), sendqueue.Options{
+		Interval: cfg.SendQueueInterval,
+		Service: func(accountID int) (*gmailapi.Service, error) {
+			account, err := auth.GetAccountByID(accountID)
+			if err != nil {
+				return nil, err
+			}
+			return auth.NewGmailServiceForAccount(account)
+		},
+	})
+
+	// Keep the local mailbox mirror current
+	if cfg.SyncEnabled {
+		search.Attach()
+
+		// Semantic search needs an embedding provider (EMBEDDING_PROVIDER)
+		if embedder, err := llm.NewEmbedder(); err == nil {
+			vector.Attach(embedder)
+			go vector.Start(context.Background())
+		} else {
+			log.Printf("Semantic search disabled: %v", err)
+		}
+
+		go mailsync.Start(context.Background(), mailsync.Options{
+			Interval:      cfg.SyncInterval,
+			BackfillLimit: cfg.SyncBackfillLimit,
+			PubSubTopic:   cfg.PubSubTopic,
+		})
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
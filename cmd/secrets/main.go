@@ -0,0 +1,92 @@
+// Command secrets manages credential encryption at rest.
+//
+//	secrets genkey   print a new random key-encryption key
+//	secrets migrate  encrypt credentials still stored in plaintext
+//	secrets rotate   re-wrap every data key with the first configured key
+//	secrets jwtkey [HS256|EdDSA|RS256]
+//	                 print a new JWT_SIGNING_KEYS entry (EdDSA by default)
+//
+// migrate and rotate read MYSQL_DSN and TOKEN_ENCRYPTION_KEYS (or
+// TOKEN_ENCRYPTION_KEYS_FILE) like the server does, and can run while the
+// server is up.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"mcp-gmail-server/internal/auth"
+	"mcp-gmail-server/internal/config"
+	"mcp-gmail-server/internal/db"
+	"mcp-gmail-server/internal/secrets"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: secrets genkey|migrate|rotate|jwtkey [alg]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 || (len(os.Args) > 2 && os.Args[1] != "jwtkey") || len(os.Args) > 3 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "genkey":
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatal(err)
+		}
+		// Date-based IDs sort naturally and say when a key was introduced
+		fmt.Printf("k%s:%s\n", time.Now().UTC().Format("20060102"), base64.StdEncoding.EncodeToString(key))
+		return
+
+	case "jwtkey":
+		alg := "EdDSA"
+		if len(os.Args) == 3 {
+			alg = os.Args[2]
+		}
+		key, err := auth.GenerateSigningKey(alg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Append "@<RFC 3339 time>" to schedule the switch to this key
+		fmt.Printf("j%s:%s:%s\n", time.Now().UTC().Format("20060102"), alg, key)
+		return
+
+	case "migrate", "rotate":
+	default:
+		usage()
+	}
+
+	// Loads .env outside Railway, like the server
+	config.LoadConfig()
+
+	if err := secrets.LoadFromEnv(); err != nil {
+		log.Fatalf("Invalid token encryption keys: %v", err)
+	}
+	if !secrets.Enabled() {
+		log.Fatal("TOKEN_ENCRYPTION_KEYS is not set")
+	}
+
+	db.Init()
+
+	if os.Args[1] == "migrate" {
+		n, err := auth.EncryptExistingSecrets()
+		if err != nil {
+			log.Fatalf("Migration failed after %d rows: %v", n, err)
+		}
+		log.Printf("Encrypted credentials in %d rows", n)
+		return
+	}
+
+	n, err := auth.RotateDataKeys()
+	if err != nil {
+		log.Fatalf("Rotation failed after %d keys: %v", n, err)
+	}
+	log.Printf("Re-wrapped %d data keys with key %q", n, secrets.CurrentKeyID())
+}
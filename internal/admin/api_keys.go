@@ -4,12 +4,18 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"mcp-gmail-server/internal/auth"
-	"mcp-gmail-server/internal/db"
 )
 
+// CreateAPIKey issues a key for another user (POST ?user_id=&name=&scopes=).
 func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
 	// 1. Get current user (from auth middleware)
 	adminUser, err := auth.GetUser(r)
 	if err != nil {
@@ -36,26 +42,37 @@ func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 4. Generate API key
-	rawKey, hash, err := auth.GenerateAPIKey()
+	target, err := auth.GetUserByID(userID)
 	if err != nil {
-		http.Error(w, "failed to generate key", 500)
+		http.Error(w, "user not found", http.StatusNotFound)
 		return
 	}
 
-	// 5. Store ONLY hash in DB
-	_, err = db.DB.Exec(`
-		INSERT INTO api_keys (user_id, key_hash)
-		VALUES (?, ?)
-	`, userID, hash)
+	// 4. Scopes default to everything the target's role allows except admin
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "Issued by " + adminUser.Email
+	}
+	requested := strings.Split(r.URL.Query().Get("scopes"), ",")
+	if r.URL.Query().Get("scopes") == "" {
+		requested = []string{auth.ScopeSearch, auth.ScopeRead, auth.ScopeSend, auth.ScopeModify}
+	}
+	scopes, err := auth.ValidateScopes(target, requested)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
+	// 5. Generate and store (only the hash is kept)
+	rawKey, key, err := auth.CreateAPIKey(userID, name, scopes, nil)
 	if err != nil {
 		http.Error(w, "failed to save key", 500)
 		return
 	}
 
 	// 6. Return RAW key ONCE
-	json.NewEncoder(w).Encode(map[string]string{
+	json.NewEncoder(w).Encode(map[string]interface{}{
 		"api_key": rawKey,
+		"key":     key,
 	})
 }
@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"mcp-gmail-server/internal/auth"
+)
+
+// MFAPolicy shows (GET) or sets (PUT {"role", "required"}) which roles
+// must use two-factor authentication. Members of a required role can only
+// enrol until they have.
+func MFAPolicy(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		policies, err := auth.MFAPolicies()
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		required := map[string]bool{}
+		for _, role := range roles {
+			required[role] = policies[role]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(required)
+
+	case http.MethodPut:
+		adminUser, err := auth.GetUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var body struct {
+			Role     string `json:"role"`
+			Required *bool  `json:"required"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Required == nil {
+			http.Error(w, "role and required are needed", http.StatusBadRequest)
+			return
+		}
+		if !validRole(body.Role) {
+			http.Error(w, "role must be user or admin", http.StatusBadRequest)
+			return
+		}
+
+		if err := auth.SetMFAPolicy(body.Role, *body.Required); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		log.Printf("Admin %d set two-factor requirement for role %q to %v", adminUser.ID, body.Role, *body.Required)
+		json.NewEncoder(w).Encode(map[string]interface{}{"role": body.Role, "required": *body.Required})
+
+	default:
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+	}
+}
+
+// ResetMFA removes a user's authenticator and recovery codes (DELETE), for
+// users who lost both. Their sessions are ended; if their role requires
+// two-factor authentication they must enrol again after signing in.
+func ResetMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	adminUser, err := auth.GetUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := auth.GetUserByID(id); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if err := auth.DisableMFA(id); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if _, err := auth.RevokeSessions(id, ""); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	log.Printf("Admin %d reset two-factor authentication for user %d", adminUser.ID, id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,14 +1,88 @@
+// Package admin implements the /admin API. Handlers are mounted behind
+// auth.AdminOnly, see server.registerAdminRoutes.
 package admin
 
 import (
+	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"mcp-gmail-server/internal/auth"
 	"mcp-gmail-server/internal/db"
+	"mcp-gmail-server/internal/search"
+	"mcp-gmail-server/internal/sendqueue"
+	"mcp-gmail-server/internal/vector"
 )
 
+// Roles a user can be given.
+var roles = []string{"user", "admin"}
+
+type userRow struct {
+	ID          int       `json:"id"`
+	Email       string    `json:"email"`
+	Role        string    `json:"role"`
+	Active      bool      `json:"active"`
+	MFAEnabled  bool      `json:"mfa_enabled"`
+	Verified    bool      `json:"email_verified"`
+	HasPassword bool      `json:"has_password"`
+	Mailboxes   int       `json:"mailboxes"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const userRowColumns = `
+	u.id, u.email, u.role, u.active, u.mfa_enabled, u.email_verified, u.password_hash IS NOT NULL,
+	(SELECT COUNT(*) FROM mailbox_accounts a WHERE a.user_id = u.id),
+	u.created_at
+`
+
+func scanUserRow(row interface{ Scan(...interface{}) error }) (*userRow, error) {
+	var u userRow
+	var role sql.NullString
+	var active sql.NullBool
+
+	err := row.Scan(&u.ID, &u.Email, &role, &active, &u.MFAEnabled, &u.Verified, &u.HasPassword, &u.Mailboxes, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Role = role.String
+	u.Active = !active.Valid || active.Bool
+	return &u, nil
+}
+
+// pathUserID parses the {id} path value, writing a 400 on failure.
+func pathUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func writeUserError(w http.ResponseWriter, err error) {
+	if err == sql.ErrNoRows {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Admin user operation failed: %v", err)
+	http.Error(w, "user operation failed", http.StatusInternalServerError)
+}
+
+// ListUsers pages through users, newest first.
+//
+// Query parameters: page (from 1), per_page (max 200), q (email contains),
+// role, active (true/false).
 func ListUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
 	user, err := auth.GetUser(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -20,30 +94,396 @@ func ListUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, _ := db.DB.Query(`
-		SELECT id, email, role, active, created_at FROM users
-	`)
+	query := r.URL.Query()
+
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(query.Get("per_page"))
+	if err != nil || perPage < 1 || perPage > 200 {
+		perPage = 50
+	}
+
+	var where []string
+	var args []interface{}
+	if q := query.Get("q"); q != "" {
+		where = append(where, "u.email LIKE ?")
+		args = append(args, "%"+strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q)+"%")
+	}
+	if role := query.Get("role"); role != "" {
+		where = append(where, "u.role = ?")
+		args = append(args, role)
+	}
+	if active := query.Get("active"); active != "" {
+		b, err := strconv.ParseBool(active)
+		if err != nil {
+			http.Error(w, "active must be true or false", http.StatusBadRequest)
+			return
+		}
+		where = append(where, "u.active = ?")
+		args = append(args, b)
+	}
+
+	filter := ""
+	if len(where) > 0 {
+		filter = "WHERE " + strings.Join(where, " AND ")
+	}
 
+	var total int
+	err = db.DB.QueryRow(`SELECT COUNT(*) FROM users u `+filter, args...).Scan(&total)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT `+userRowColumns+`
+		FROM users u
+		`+filter+`
+		ORDER BY u.created_at DESC, u.id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, perPage, (page-1)*perPage)...)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
 	defer rows.Close()
 
-	var result []map[string]interface{}
+	result := []*userRow{}
+	for rows.Next() {
+		u, err := scanUserRow(rows)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		result = append(result, u)
+	}
+	if err := rows.Err(); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users":    result,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+// ManageUser shows (GET) or updates (PATCH {active, role, email_verified})
+// one user.
+// Admins cannot deactivate or demote themselves.
+func ManageUser(w http.ResponseWriter, r *http.Request) {
+	adminUser, err := auth.GetUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		// Falls through to the lookup below
+
+	case http.MethodPatch:
+		var body struct {
+			Active   *bool   `json:"active"`
+			Role     *string `json:"role"`
+			Verified *bool   `json:"email_verified"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if body.Role != nil && !validRole(*body.Role) {
+			http.Error(w, "role must be one of: "+strings.Join(roles, ", "), http.StatusBadRequest)
+			return
+		}
+		if id == adminUser.ID && ((body.Active != nil && !*body.Active) || (body.Role != nil && *body.Role != "admin")) {
+			http.Error(w, "admins cannot deactivate or demote themselves", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := auth.GetUserByID(id); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		if body.Active != nil {
+			if _, err := db.DB.Exec(`UPDATE users SET active = ? WHERE id = ?`, *body.Active, id); err != nil {
+				writeUserError(w, err)
+				return
+			}
+			log.Printf("Admin %d set user %d active=%v", adminUser.ID, id, *body.Active)
+			if !*body.Active {
+				if _, err := auth.RevokeSessions(id, ""); err != nil {
+					writeUserError(w, err)
+					return
+				}
+			}
+		}
+		if body.Role != nil {
+			if _, err := db.DB.Exec(`UPDATE users SET role = ? WHERE id = ?`, *body.Role, id); err != nil {
+				writeUserError(w, err)
+				return
+			}
+			log.Printf("Admin %d set user %d role=%s", adminUser.ID, id, *body.Role)
+		}
+		if body.Verified != nil {
+			if _, err := db.DB.Exec(`UPDATE users SET email_verified = ? WHERE id = ?`, *body.Verified, id); err != nil {
+				writeUserError(w, err)
+				return
+			}
+			log.Printf("Admin %d set user %d email_verified=%v", adminUser.ID, id, *body.Verified)
+		}
+
+	default:
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	u, err := scanUserRow(db.DB.QueryRow(`SELECT `+userRowColumns+` FROM users u WHERE u.id = ?`, id))
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+func validRole(role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// LogoutUser ends every session of a user (POST). With
+// {"revoke_api_keys": true} their API keys are revoked as well.
+func LogoutUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	adminUser, err := auth.GetUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		RevokeAPIKeys bool `json:"revoke_api_keys"`
+	}
+	// The body is optional
+	json.NewDecoder(r.Body).Decode(&body)
+
+	if _, err := auth.GetUserByID(id); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	sessions, err := auth.RevokeSessions(id, "")
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	var keys int64
+	if body.RevokeAPIKeys {
+		res, err := db.DB.Exec(`UPDATE api_keys SET active = FALSE WHERE user_id = ? AND active = TRUE`, id)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		keys, _ = res.RowsAffected()
+	}
+
+	log.Printf("Admin %d logged out user %d (%d sessions, %d API keys revoked)", adminUser.ID, id, sessions, keys)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":          "User logged out",
+		"revoked_sessions": sessions,
+		"revoked_api_keys": keys,
+	})
+}
+
+// DisconnectGmail removes a user's connected mailboxes (DELETE), or just
+// the one named by {account_id}, along with their local mirror.
+func DisconnectGmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	adminUser, err := auth.GetUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	accounts, err := auth.ListAccounts(id)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	if param := r.PathValue("account_id"); param != "" {
+		accountID, err := strconv.Atoi(param)
+		if err != nil {
+			http.Error(w, "invalid account id", http.StatusBadRequest)
+			return
+		}
+		var selected []*auth.Account
+		for _, a := range accounts {
+			if a.ID == accountID {
+				selected = append(selected, a)
+			}
+		}
+		if len(selected) == 0 {
+			http.Error(w, "account not found", http.StatusNotFound)
+			return
+		}
+		accounts = selected
+	}
+
+	removed := []string{}
+	for _, a := range accounts {
+		if err := auth.RemoveAccount(id, a.ID); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		search.Forget(a.ID)
+		vector.Forget(a.ID)
+		removed = append(removed, a.EmailAddress)
+		log.Printf("Admin %d disconnected mailbox account %d of user %d", adminUser.ID, a.ID, id)
+	}
 
+	json.NewEncoder(w).Encode(map[string]interface{}{"disconnected": removed})
+}
+
+// UserStats reports a user's usage: mailboxes, API keys, drafts, queued
+// sends by status and the size of their local mirror.
+func UserStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := auth.GetUserByID(id); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	var stats struct {
+		Mailboxes        int            `json:"mailboxes"`
+		NeedsReconnect   int            `json:"needs_reconnect"`
+		ActiveAPIKeys    int            `json:"active_api_keys"`
+		APIKeyLastUsedAt *time.Time     `json:"api_key_last_used_at,omitempty"`
+		Drafts           int            `json:"drafts"`
+		QueuedSends      map[string]int `json:"queued_sends"`
+		MirroredMessages int            `json:"mirrored_messages"`
+	}
+
+	var lastUsed sql.NullTime
+	err := db.DB.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM mailbox_accounts WHERE user_id = ?),
+			(SELECT COUNT(*) FROM mailbox_accounts WHERE user_id = ? AND needs_reconnect = TRUE),
+			(SELECT COUNT(*) FROM api_keys WHERE user_id = ? AND active = TRUE),
+			(SELECT MAX(last_used_at) FROM api_keys WHERE user_id = ?),
+			(SELECT COUNT(*) FROM drafts WHERE user_id = ?),
+			(SELECT COUNT(*) FROM mail_messages WHERE user_id = ?)
+	`, id, id, id, id, id, id).Scan(
+		&stats.Mailboxes,
+		&stats.NeedsReconnect,
+		&stats.ActiveAPIKeys,
+		&lastUsed,
+		&stats.Drafts,
+		&stats.MirroredMessages,
+	)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if lastUsed.Valid {
+		stats.APIKeyLastUsedAt = &lastUsed.Time
+	}
+
+	// Only mail the user asked for, not system mail sent on their behalf
+	rows, err := db.DB.Query(`
+		SELECT status, COUNT(*) FROM send_queue
+		WHERE user_id = ? AND kind = ?
+		GROUP BY status
+	`, id, sendqueue.KindUser)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	stats.QueuedSends = map[string]int{}
 	for rows.Next() {
-		var id int
-		var email, role string
-		var active bool
-		var created string
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		stats.QueuedSends[status] = n
+	}
+	if err := rows.Err(); err != nil {
+		writeUserError(w, err)
+		return
+	}
 
-		rows.Scan(&id, &email, &role, &active, &created)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
 
-		result = append(result, map[string]interface{}{
-			"id":         id,
-			"email":      email,
-			"role":       role,
-			"active":     active,
-			"created_at": created,
-		})
+// UnlockUser lifts a login lockout and clears the user's failed attempts
+// (POST).
+func UnlockUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	adminUser, err := auth.GetUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := auth.GetUserByID(id)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if err := auth.UnlockLogin(user.Email); err != nil {
+		writeUserError(w, err)
+		return
 	}
 
-	json.NewEncoder(w).Encode(result)
+	log.Printf("Admin %d unlocked login for user %d", adminUser.ID, id)
+	json.NewEncoder(w).Encode(map[string]string{"message": "User unlocked"})
 }
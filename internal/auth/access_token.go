@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrUnknownGoogleAccount is returned for access tokens whose Google
+// account has no mailbox connected here.
+var ErrUnknownGoogleAccount = errors.New("google account is not connected")
+
+// accessTokenCacheTTL bounds how long a token stays trusted without asking
+// Google again, so revocations are noticed reasonably quickly.
+const accessTokenCacheTTL = 5 * time.Minute
+
+// AccessTokenVerifier authenticates Google OAuth access tokens issued to our
+// OAuth client, e.g. to an agent that went through the same consent flow.
+// Tokens are checked against Google's tokeninfo endpoint and the answer is
+// cached briefly.
+type AccessTokenVerifier struct {
+	// URL of the tokeninfo endpoint; point it at a stub server in tests.
+	URL string
+	// Audience is the server's OAuth client ID. Tokens issued to a user's own
+	// client ID are accepted for that user's mailboxes as well.
+	Audience string
+	Client   *http.Client
+
+	mu    sync.Mutex
+	cache map[[32]byte]cachedAccessToken
+}
+
+type cachedAccessToken struct {
+	userID  int
+	expires time.Time
+}
+
+func NewAccessTokenVerifier(url, audience string) *AccessTokenVerifier {
+	return &AccessTokenVerifier{
+		URL:      url,
+		Audience: audience,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// tokenInfo is the tokeninfo response; Google encodes numbers and booleans
+// as strings here.
+type tokenInfo struct {
+	Audience      string `json:"aud"`
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified string `json:"email_verified"`
+	Expiry        string `json:"exp"`
+	Error         string `json:"error_description"`
+}
+
+// Verify resolves an access token to the user owning its Google account.
+func (v *AccessTokenVerifier) Verify(raw string) (*User, error) {
+	key := sha256.Sum256([]byte(raw))
+
+	v.mu.Lock()
+	cached, ok := v.cache[key]
+	v.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return GetUserByID(cached.userID)
+	}
+
+	info, err := v.lookup(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	exp, err := strconv.ParseInt(info.Expiry, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("tokeninfo: invalid exp %q", info.Expiry)
+	}
+	expires := time.Unix(exp, 0)
+	if !time.Now().Before(expires) {
+		return nil, fmt.Errorf("access token expired")
+	}
+	if info.Subject == "" || info.Email == "" || info.EmailVerified != "true" {
+		return nil, ErrEmailNotVerified
+	}
+
+	user, err := findGoogleUser(&GoogleIdentity{Subject: info.Subject, Email: info.Email})
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownGoogleAccount
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Audience == "" || (info.Audience != v.Audience && info.Audience != user.GoogleClientID) {
+		return nil, fmt.Errorf("access token issued to another client (%s)", info.Audience)
+	}
+
+	if limit := time.Now().Add(accessTokenCacheTTL); expires.After(limit) {
+		expires = limit
+	}
+	v.remember(key, user.ID, expires)
+
+	return user, nil
+}
+
+func (v *AccessTokenVerifier) lookup(raw string) (*tokenInfo, error) {
+	resp, err := v.Client.Get(v.URL + "?access_token=" + url.QueryEscape(raw))
+	if err != nil {
+		return nil, fmt.Errorf("tokeninfo: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var info tokenInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("tokeninfo: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tokeninfo: status %d: %s", resp.StatusCode, info.Error)
+	}
+	return &info, nil
+}
+
+func (v *AccessTokenVerifier) remember(key [32]byte, userID int, expires time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.cache == nil {
+		v.cache = make(map[[32]byte]cachedAccessToken)
+	}
+	// Drop expired entries once the cache grows, so it can't grow unbounded
+	if len(v.cache) >= 1000 {
+		now := time.Now()
+		for k, c := range v.cache {
+			if now.After(c.expires) {
+				delete(v.cache, k)
+			}
+		}
+	}
+	v.cache[key] = cachedAccessToken{userID: userID, expires: expires}
+}
@@ -0,0 +1,476 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"mcp-gmail-server/internal/db"
+	"mcp-gmail-server/internal/gmail"
+	"mcp-gmail-server/internal/secrets"
+
+	"golang.org/x/oauth2"
+	gmailapi "google.golang.org/api/gmail/v1"
+)
+
+// ErrNoAccount is returned when a user has no Gmail mailbox connected.
+var ErrNoAccount = errors.New("no Gmail account connected")
+
+// Account is a Gmail mailbox connected to a user.
+type Account struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"-"`
+	EmailAddress string    `json:"email_address"`
+	Label        string    `json:"label"`
+	AccessToken  string    `json:"-"`
+	RefreshToken string    `json:"-"`
+	Expiry       time.Time `json:"-"`
+	IsPrimary    bool      `json:"is_primary"`
+	// NeedsReconnect is set when Google revoked the refresh token.
+	NeedsReconnect bool      `json:"needs_reconnect"`
+	CreatedAt      time.Time `json:"created_at"`
+	// GoogleSub is the ID token subject of the Google account behind the
+	// mailbox. Empty for mailboxes connected before it was recorded.
+	GoogleSub string `json:"-"`
+}
+
+// DisplayName is the label if one was set, otherwise the address.
+func (a *Account) DisplayName() string {
+	if a.Label != "" {
+		return a.Label
+	}
+	return a.EmailAddress
+}
+
+const accountColumns = `
+	id, user_id, email_address, label,
+	access_token, refresh_token, expiry,
+	is_primary, needs_reconnect, created_at,
+	data_key, google_sub
+`
+
+func scanAccount(row rowScanner) (*Account, error) {
+	var a Account
+	var label, accessToken, refreshToken, dataKey, googleSub sql.NullString
+	var expiry sql.NullTime
+
+	err := row.Scan(
+		&a.ID,
+		&a.UserID,
+		&a.EmailAddress,
+		&label,
+		&accessToken,
+		&refreshToken,
+		&expiry,
+		&a.IsPrimary,
+		&a.NeedsReconnect,
+		&a.CreatedAt,
+		&dataKey,
+		&googleSub,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	box, err := secrets.OpenBox(dataKey.String)
+	if err != nil {
+		return nil, fmt.Errorf("account %d: %w", a.ID, err)
+	}
+	if a.AccessToken, err = box.Open("access_token", accessToken.String); err != nil {
+		return nil, fmt.Errorf("account %d: %w", a.ID, err)
+	}
+	if a.RefreshToken, err = box.Open("refresh_token", refreshToken.String); err != nil {
+		return nil, fmt.Errorf("account %d: %w", a.ID, err)
+	}
+
+	a.Label = label.String
+	a.GoogleSub = googleSub.String
+	if expiry.Valid {
+		a.Expiry = expiry.Time
+	}
+
+	return &a, nil
+}
+
+func queryAccounts(query string, args ...interface{}) ([]*Account, error) {
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []*Account
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// ListAccounts returns a user's mailboxes, primary first.
+func ListAccounts(userID int) ([]*Account, error) {
+	return queryAccounts(`
+		SELECT `+accountColumns+`
+		FROM mailbox_accounts
+		WHERE user_id = ?
+		ORDER BY is_primary DESC, created_at, id
+	`, userID)
+}
+
+// GetAccount loads one of the user's mailboxes. Accounts owned by someone
+// else are reported as sql.ErrNoRows.
+func GetAccount(userID, accountID int) (*Account, error) {
+	return scanAccount(db.DB.QueryRow(`
+		SELECT `+accountColumns+`
+		FROM mailbox_accounts
+		WHERE id = ? AND user_id = ?
+	`, accountID, userID))
+}
+
+func GetAccountByID(accountID int) (*Account, error) {
+	return scanAccount(db.DB.QueryRow(`
+		SELECT `+accountColumns+`
+		FROM mailbox_accounts
+		WHERE id = ?
+	`, accountID))
+}
+
+// PrimaryAccount returns the mailbox used when a request doesn't name one.
+func PrimaryAccount(userID int) (*Account, error) {
+	a, err := scanAccount(db.DB.QueryRow(`
+		SELECT `+accountColumns+`
+		FROM mailbox_accounts
+		WHERE user_id = ?
+		ORDER BY is_primary DESC, created_at, id
+		LIMIT 1
+	`, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoAccount
+	}
+	return a, err
+}
+
+// FindAccountsByIdentity returns every connected mailbox belonging to a
+// Google account, including legacy rows without a subject whose address
+// matches.
+func FindAccountsByIdentity(identity *GoogleIdentity) ([]*Account, error) {
+	return queryAccounts(`
+		SELECT `+accountColumns+`
+		FROM mailbox_accounts
+		WHERE google_sub = ? OR (google_sub IS NULL AND email_address = ?)
+	`, identity.Subject, identity.Email)
+}
+
+// ListConnectedAccounts returns every mailbox with a refresh token whose
+// owner is active.
+func ListConnectedAccounts() ([]*Account, error) {
+	return queryAccounts(`
+		SELECT a.id, a.user_id, a.email_address, a.label,
+		       a.access_token, a.refresh_token, a.expiry,
+		       a.is_primary, a.needs_reconnect, a.created_at,
+		       a.data_key, a.google_sub
+		FROM mailbox_accounts a
+		JOIN users u ON u.id = a.user_id
+		WHERE u.active = TRUE
+		  AND a.refresh_token IS NOT NULL AND a.refresh_token != ''
+		  AND a.needs_reconnect = FALSE
+	`)
+}
+
+// GetConnectedAccount loads a mailbox only if ListConnectedAccounts would
+// include it, so on-demand syncs skip the same accounts the periodic pass
+// does. Other mailboxes are reported as sql.ErrNoRows.
+func GetConnectedAccount(accountID int) (*Account, error) {
+	return scanAccount(db.DB.QueryRow(`
+		SELECT a.id, a.user_id, a.email_address, a.label,
+		       a.access_token, a.refresh_token, a.expiry,
+		       a.is_primary, a.needs_reconnect, a.created_at,
+		       a.data_key, a.google_sub
+		FROM mailbox_accounts a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.id = ?
+		  AND u.active = TRUE
+		  AND a.refresh_token IS NOT NULL AND a.refresh_token != ''
+		  AND a.needs_reconnect = FALSE
+	`, accountID))
+}
+
+// UpsertAccount stores the tokens for the mailbox behind a verified Google
+// identity, connecting it to the user if it is new. Mailboxes are matched by
+// the Google subject, so an address change updates the existing row. A
+// user's first mailbox becomes their primary one.
+func UpsertAccount(userID int, identity *GoogleIdentity, token *oauth2.Token) (*Account, error) {
+	// Rows connected before subjects were recorded are claimed by address
+	var accountID int
+	err := db.DB.QueryRow(`
+		SELECT id FROM mailbox_accounts
+		WHERE user_id = ?
+		  AND (google_sub = ? OR (google_sub IS NULL AND email_address = ?))
+		ORDER BY google_sub IS NULL
+		LIMIT 1
+	`, userID, identity.Subject, identity.Email).Scan(&accountID)
+
+	if err == sql.ErrNoRows {
+		var existing int
+		err = db.DB.QueryRow(`SELECT COUNT(*) FROM mailbox_accounts WHERE user_id = ?`, userID).Scan(&existing)
+		if err != nil {
+			return nil, err
+		}
+
+		// Create the row first so the tokens can be sealed with its data key.
+		// If the address is still held by a different Google account (deleted
+		// and re-created), that row is taken over by the new subject.
+		var res sql.Result
+		res, err = db.DB.Exec(`
+			INSERT INTO mailbox_accounts (user_id, email_address, google_sub, is_primary)
+			VALUES (?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), google_sub = VALUES(google_sub)
+		`, userID, identity.Email, identity.Subject, existing == 0)
+		if err != nil {
+			return nil, err
+		}
+		var id int64
+		id, err = res.LastInsertId()
+		accountID = int(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	box, err := rowBox("mailbox_accounts", accountID)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := box.Seal("access_token", token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := box.Seal("refresh_token", token.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.DB.Exec(`
+		UPDATE mailbox_accounts
+		SET email_address = ?,
+			google_sub = ?,
+			access_token = ?,
+			refresh_token = IF(? = '', refresh_token, ?),
+			expiry = ?,
+			needs_reconnect = FALSE,
+			token_error = NULL
+		WHERE id = ?
+	`, identity.Email, identity.Subject, accessToken, refreshToken, refreshToken, token.Expiry, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetAccountByID(accountID)
+}
+
+// SetAccountLabel renames a mailbox for display.
+func SetAccountLabel(userID, accountID int, label string) error {
+	if _, err := GetAccount(userID, accountID); err != nil {
+		return err
+	}
+
+	_, err := db.DB.Exec(`
+		UPDATE mailbox_accounts SET label = ? WHERE id = ? AND user_id = ?
+	`, label, accountID, userID)
+	return err
+}
+
+// SetPrimaryAccount makes accountID the user's default mailbox.
+func SetPrimaryAccount(userID, accountID int) error {
+	if _, err := GetAccount(userID, accountID); err != nil {
+		return err
+	}
+
+	_, err := db.DB.Exec(`
+		UPDATE mailbox_accounts SET is_primary = (id = ?) WHERE user_id = ?
+	`, accountID, userID)
+	return err
+}
+
+// googleRevokeURL revokes the OAuth grant behind a token.
+const googleRevokeURL = "https://oauth2.googleapis.com/revoke"
+
+// RemoveAccount disconnects a mailbox and drops its local mirror. Its
+// Google grant is revoked first, so tokens that survive elsewhere (a
+// backup, a log) stop working. If it was the primary mailbox, the oldest
+// remaining one is promoted. Callers drop in-memory caches of the mirror.
+func RemoveAccount(userID, accountID int) error {
+	account, err := GetAccount(userID, accountID)
+	if err != nil {
+		return err
+	}
+	if err := revokeGoogleGrant(account); err != nil {
+		// The user asked to disconnect; don't keep the mailbox over it
+		log.Printf("Failed to revoke Google grant for mailbox account %d: %v", accountID, err)
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	var wasPrimary bool
+	err = tx.QueryRow(`
+		SELECT is_primary FROM mailbox_accounts WHERE id = ? AND user_id = ?
+	`, accountID, userID).Scan(&wasPrimary)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, q := range []string{
+		`DELETE FROM message_chunks WHERE account_id = ?`,
+		`DELETE FROM embedding_failures WHERE account_id = ?`,
+		`DELETE FROM sync_failures WHERE account_id = ?`,
+		`DELETE FROM mail_messages WHERE account_id = ?`,
+		`DELETE FROM sync_state WHERE account_id = ?`,
+		`DELETE FROM mailbox_accounts WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(q, accountID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if wasPrimary {
+		_, err = tx.Exec(`
+			UPDATE mailbox_accounts SET is_primary = TRUE
+			WHERE user_id = ?
+			ORDER BY created_at, id
+			LIMIT 1
+		`, userID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// revokeGoogleGrant revokes the grant behind a mailbox's tokens. Google
+// keeps one grant per Gmail address and OAuth client, so it is left alone
+// while another connection of the same address may still rely on it.
+func revokeGoogleGrant(account *Account) error {
+	token := account.RefreshToken
+	if token == "" {
+		token = account.AccessToken
+	}
+	if token == "" {
+		return nil
+	}
+
+	var others int
+	err := db.DB.QueryRow(`
+		SELECT COUNT(*) FROM mailbox_accounts WHERE email_address = ? AND id <> ?
+	`, account.EmailAddress, account.ID).Scan(&others)
+	if err != nil {
+		return err
+	}
+	if others > 0 {
+		return nil
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.PostForm(googleRevokeURL, url.Values{"token": {token}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 400 is what Google answers for a token that is already revoked
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("revoke: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// NewGmailServiceForAccount builds a Gmail client for a connected mailbox,
+// refreshing through the owner's OAuth client.
+func NewGmailServiceForAccount(account *Account) (*gmailapi.Service, error) {
+	owner, err := GetUserByID(account.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  account.AccessToken,
+		RefreshToken: account.RefreshToken,
+		Expiry:       account.Expiry,
+		TokenType:    "Bearer",
+	}
+
+	ts := newPersistingTokenSource(account.ID, BuildOAuthConfig(owner), token)
+	return gmail.NewGmailServiceFromTokenSource(ts)
+}
+
+// findGoogleUser returns the user owning the mailbox of a Google account.
+// The same mailbox may be connected to several users; prefer the one whose
+// login it is, otherwise the first to connect it. Returns sql.ErrNoRows if
+// the account isn't connected anywhere.
+func findGoogleUser(identity *GoogleIdentity) (*User, error) {
+	accounts, err := FindAccountsByIdentity(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	var owner *Account
+	for _, a := range accounts {
+		if owner == nil || a.CreatedAt.Before(owner.CreatedAt) {
+			owner = a
+		}
+	}
+	if owner == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	if user, err := GetUserFromDB(identity.Email); err == nil {
+		for _, a := range accounts {
+			if a.UserID == user.ID {
+				return user, nil
+			}
+		}
+	}
+	return GetUserByID(owner.UserID)
+}
+
+// ResolveGoogleLogin finds the user a Google sign-in belongs to when there
+// is no session: the owner of that mailbox, else the user registered with
+// that email, else a new passwordless user.
+func ResolveGoogleLogin(identity *GoogleIdentity) (*User, error) {
+	user, err := findGoogleUser(identity)
+	if err != sql.ErrNoRows {
+		return user, err
+	}
+
+	// The ID token's email is verified, so it may claim a password account
+	user, err = GetUserFromDB(identity.Email)
+	if err == nil {
+		if !user.EmailVerified {
+			if err := ClaimUnverifiedUser(user); err != nil {
+				return nil, err
+			}
+		}
+		return user, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if _, err := db.DB.Exec(`INSERT INTO users (email) VALUES (?)`, identity.Email); err != nil {
+		return nil, err
+	}
+	return GetUserFromDB(identity.Email)
+}
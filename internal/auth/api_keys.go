@@ -3,9 +3,43 @@ package auth
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
+	"errors"
+	"time"
+
+	"mcp-gmail-server/internal/db"
+)
+
+var (
+	ErrAPIKeyRevoked = errors.New("api key revoked")
+	ErrAPIKeyExpired = errors.New("api key expired")
 )
 
+// apiKeyTouchInterval limits how often last-used details are written, so a
+// busy key doesn't cost an UPDATE per request.
+const apiKeyTouchInterval = time.Minute
+
+// APIKey describes a key without its secret, which is only shown once.
+type APIKey struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"-"`
+	Name   string `json:"name"`
+	// Prefix is the start of the raw key, enough to tell keys apart.
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	Active     bool       `json:"active"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Expired reports whether the key is past its expiry.
+func (k *APIKey) Expired() bool {
+	return k.ExpiresAt != nil && !time.Now().Before(*k.ExpiresAt)
+}
+
 func GenerateAPIKey() (raw string, hash string, err error) {
 	b := make([]byte, 32)
 	_, err = rand.Read(b)
@@ -13,10 +47,206 @@ func GenerateAPIKey() (raw string, hash string, err error) {
 		return "", "", err
 	}
 
-	raw = "mcp_live_" + hex.EncodeToString(b)
+	raw = APIKeyPrefix + hex.EncodeToString(b)
+
+	return raw, hashAPIKey(raw), nil
+}
 
+func hashAPIKey(raw string) string {
 	h := sha256.Sum256([]byte(raw))
-	hash = hex.EncodeToString(h[:])
+	return hex.EncodeToString(h[:])
+}
+
+func keyPrefix(raw string) string {
+	return raw[:len(APIKeyPrefix)+8]
+}
+
+const apiKeyColumns = `
+	id, user_id, name, key_prefix, scopes,
+	expires_at, last_used_at, last_used_ip, active, created_at
+`
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var k APIKey
+	var name, prefix, scopes, lastUsedIP sql.NullString
+	var expiresAt, lastUsedAt sql.NullTime
+	var active sql.NullBool
+
+	err := row.Scan(
+		&k.ID,
+		&k.UserID,
+		&name,
+		&prefix,
+		&scopes,
+		&expiresAt,
+		&lastUsedAt,
+		&lastUsedIP,
+		&active,
+		&k.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	k.Name = name.String
+	k.Prefix = prefix.String
+	k.LastUsedIP = lastUsedIP.String
+	k.Active = !active.Valid || active.Bool
+	if scopes.Valid {
+		k.Scopes = splitScopes(scopes.String)
+	} else {
+		k.Scopes = userScopes
+	}
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return &k, nil
+}
+
+// CreateAPIKey issues a key for userID. Scopes must already be validated
+// (see ValidateScopes); expiresAt may be nil for a key that never expires.
+// The raw key is returned once and only its hash is stored.
+func CreateAPIKey(userID int, name string, scopes []string, expiresAt *time.Time) (string, *APIKey, error) {
+	raw, hash, err := GenerateAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	res, err := db.DB.Exec(`
+		INSERT INTO api_keys (user_id, key_hash, key_prefix, name, scopes, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, userID, hash, keyPrefix(raw), name, joinScopes(scopes), expiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", nil, err
+	}
+
+	key, err := GetAPIKey(userID, int(id))
+	if err != nil {
+		return "", nil, err
+	}
+	return raw, key, nil
+}
+
+// ListAPIKeys returns a user's keys, newest first, including revoked ones.
+func ListAPIKeys(userID int) ([]*APIKey, error) {
+	rows, err := db.DB.Query(`
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// GetAPIKey loads one of the user's keys. Keys owned by someone else are
+// reported as sql.ErrNoRows.
+func GetAPIKey(userID, keyID int) (*APIKey, error) {
+	return scanAPIKey(db.DB.QueryRow(`
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE id = ? AND user_id = ?
+	`, keyID, userID))
+}
+
+// RotateAPIKey replaces the secret of an active key, keeping its ID, name,
+// scopes and expiry. The old secret stops working immediately.
+func RotateAPIKey(userID, keyID int) (string, *APIKey, error) {
+	raw, hash, err := GenerateAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	res, err := db.DB.Exec(`
+		UPDATE api_keys
+		SET key_hash = ?, key_prefix = ?, last_used_at = NULL, last_used_ip = NULL
+		WHERE id = ? AND user_id = ? AND active = TRUE
+	`, hash, keyPrefix(raw), keyID, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", nil, sql.ErrNoRows
+	}
+
+	key, err := GetAPIKey(userID, keyID)
+	if err != nil {
+		return "", nil, err
+	}
+	return raw, key, nil
+}
+
+// RevokeAPIKey deactivates one of the user's keys. The row is kept so the
+// key still shows up (and drafts stay attributed to it).
+func RevokeAPIKey(userID, keyID int) error {
+	if _, err := GetAPIKey(userID, keyID); err != nil {
+		return err
+	}
+
+	_, err := db.DB.Exec(`
+		UPDATE api_keys SET active = FALSE WHERE id = ? AND user_id = ?
+	`, keyID, userID)
+	return err
+}
+
+// LookupAPIKey resolves a raw key to its (active) owner and the key.
+func LookupAPIKey(raw string) (*User, *APIKey, error) {
+	key, err := scanAPIKey(db.DB.QueryRow(`
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE key_hash = ?
+	`, hashAPIKey(raw)))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !key.Active {
+		return nil, nil, ErrAPIKeyRevoked
+	}
+	if key.Expired() {
+		return nil, nil, ErrAPIKeyExpired
+	}
+
+	user, err := GetUserByID(key.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.Active {
+		return nil, nil, ErrUserInactive
+	}
+
+	return user, key, nil
+}
+
+// touchAPIKey records when and from where a key was last used.
+func touchAPIKey(key *APIKey, ip string) error {
+	now := time.Now()
+	if key.LastUsedAt != nil && now.Sub(*key.LastUsedAt) < apiKeyTouchInterval && key.LastUsedIP == ip {
+		return nil
+	}
 
-	return raw, hash, nil
+	_, err := db.DB.Exec(`
+		UPDATE api_keys SET last_used_at = ?, last_used_ip = ? WHERE id = ?
+	`, now, ip, key.ID)
+	return err
 }
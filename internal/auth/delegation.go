@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"mcp-gmail-server/internal/db"
+	"mcp-gmail-server/internal/gmail"
+
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+	gmailapi "google.golang.org/api/gmail/v1"
+)
+
+// ErrDelegationDisabled is returned when no service account key is loaded.
+var ErrDelegationDisabled = errors.New("domain-wide delegation is not configured")
+
+// ErrMailboxNotAllowed is returned for mailboxes missing from the allowlist.
+var ErrMailboxNotAllowed = errors.New("mailbox is not on the delegation allowlist")
+
+// serviceAccount is the JWT-bearer config parsed from the service account
+// key. Each delegated client copies it and sets Subject.
+var serviceAccount *jwt.Config
+
+// DelegationScope is the only scope delegated clients ask for: admins only
+// search delegated mailboxes. In the Workspace admin console (Security >
+// API controls > Domain-wide delegation) grant the service account's client
+// ID exactly https://www.googleapis.com/auth/gmail.readonly.
+const DelegationScope = gmailapi.GmailReadonlyScope
+
+// LoadServiceAccount reads a service account JSON key, either inline
+// (starting with "{") or from a file path, and enables delegation. The
+// account must be granted DelegationScope in the Workspace admin console.
+func LoadServiceAccount(keyOrPath string) error {
+	data := []byte(keyOrPath)
+	if !strings.HasPrefix(strings.TrimSpace(keyOrPath), "{") {
+		var err error
+		data, err = os.ReadFile(keyOrPath)
+		if err != nil {
+			return fmt.Errorf("read service account key: %w", err)
+		}
+	}
+
+	conf, err := google.JWTConfigFromJSON(data, DelegationScope)
+	if err != nil {
+		return fmt.Errorf("parse service account key: %w", err)
+	}
+
+	serviceAccount = conf
+	return nil
+}
+
+func DelegationEnabled() bool {
+	return serviceAccount != nil
+}
+
+// NewDelegatedGmailService impersonates a Workspace user through the
+// service account. Callers must check the allowlist and audit first; see
+// OpenDelegatedMailbox.
+func NewDelegatedGmailService(mailbox string) (*gmailapi.Service, error) {
+	if serviceAccount == nil {
+		return nil, ErrDelegationDisabled
+	}
+
+	conf := *serviceAccount
+	conf.Subject = mailbox
+
+	return gmail.NewGmailServiceFromTokenSource(conf.TokenSource(context.Background()))
+}
+
+// OpenDelegatedMailbox checks the allowlist, records the attempt in the
+// audit log and returns a client for the mailbox. Denied attempts are
+// audited too.
+func OpenDelegatedMailbox(actor *User, mailbox, action, detail, ip string) (*gmailapi.Service, error) {
+	mailbox = strings.ToLower(strings.TrimSpace(mailbox))
+
+	if serviceAccount == nil {
+		return nil, ErrDelegationDisabled
+	}
+
+	allowed, err := IsMailboxDelegated(mailbox)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := AuditDelegatedAccess(actor.ID, mailbox, action, allowed, detail, ip); err != nil {
+		// No audit record, no access
+		return nil, fmt.Errorf("audit log write failed: %w", err)
+	}
+
+	if !allowed {
+		return nil, ErrMailboxNotAllowed
+	}
+
+	return NewDelegatedGmailService(mailbox)
+}
+
+// DelegatedMailbox is an allowlist entry.
+type DelegatedMailbox struct {
+	ID           int       `json:"id"`
+	EmailAddress string    `json:"email_address"`
+	Note         string    `json:"note"`
+	AddedBy      int       `json:"added_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func IsMailboxDelegated(mailbox string) (bool, error) {
+	var n int
+	err := db.DB.QueryRow(`
+		SELECT COUNT(*) FROM delegated_mailboxes WHERE email_address = ?
+	`, strings.ToLower(mailbox)).Scan(&n)
+	return n > 0, err
+}
+
+func ListDelegatedMailboxes() ([]DelegatedMailbox, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, email_address, note, added_by, created_at
+		FROM delegated_mailboxes
+		ORDER BY email_address
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []DelegatedMailbox{}
+	for rows.Next() {
+		var m DelegatedMailbox
+		var note sql.NullString
+		var addedBy sql.NullInt64
+		if err := rows.Scan(&m.ID, &m.EmailAddress, &note, &addedBy, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.Note = note.String
+		m.AddedBy = int(addedBy.Int64)
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// AddDelegatedMailbox allowlists a mailbox. The change and its audit entry
+// are written together, so neither lands without the other.
+func AddDelegatedMailbox(adminID int, mailbox, note, ip string) error {
+	mailbox = strings.ToLower(strings.TrimSpace(mailbox))
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO delegated_mailboxes (email_address, note, added_by)
+		VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE note = VALUES(note)
+	`, mailbox, note, adminID)
+	if err != nil {
+		return err
+	}
+	if err := auditDelegation(tx, adminID, mailbox, "allowlist_add", true, note, ip); err != nil {
+		return fmt.Errorf("audit log write failed: %w", err)
+	}
+	return tx.Commit()
+}
+
+// RemoveDelegatedMailbox deletes an allowlist entry, with its audit entry,
+// and returns its address.
+func RemoveDelegatedMailbox(adminID, id int, ip string) (string, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var mailbox string
+	err = tx.QueryRow(`SELECT email_address FROM delegated_mailboxes WHERE id = ?`, id).Scan(&mailbox)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM delegated_mailboxes WHERE id = ?`, id); err != nil {
+		return "", err
+	}
+	if err := auditDelegation(tx, adminID, mailbox, "allowlist_remove", true, "", ip); err != nil {
+		return "", fmt.Errorf("audit log write failed: %w", err)
+	}
+	return mailbox, tx.Commit()
+}
+
+// AuditDelegatedAccess appends an entry to the delegation audit log.
+func AuditDelegatedAccess(userID int, mailbox, action string, allowed bool, detail, ip string) error {
+	return auditDelegation(db.DB, userID, mailbox, action, allowed, detail, ip)
+}
+
+// auditDelegation writes an audit entry through the database or a
+// transaction.
+func auditDelegation(ex interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, userID int, mailbox, action string, allowed bool, detail, ip string) error {
+	_, err := ex.Exec(`
+		INSERT INTO delegation_audit (user_id, mailbox, action, allowed, detail, ip)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, userID, mailbox, action, allowed, detail, ip)
+	return err
+}
+
+// AuditEntry is a row of the delegation audit log.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	UserID    int       `json:"user_id,omitempty"`
+	Mailbox   string    `json:"mailbox"`
+	Action    string    `json:"action"`
+	Allowed   bool      `json:"allowed"`
+	Detail    string    `json:"detail"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListDelegationAudit returns the most recent audit entries, optionally
+// for a single mailbox.
+func ListDelegationAudit(mailbox string, limit int) ([]AuditEntry, error) {
+	query := `
+		SELECT id, user_id, mailbox, action, allowed, detail, ip, created_at
+		FROM delegation_audit`
+	args := []interface{}{}
+	if mailbox != "" {
+		query += ` WHERE mailbox = ?`
+		args = append(args, strings.ToLower(mailbox))
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var userID sql.NullInt64
+		var detail, ip sql.NullString
+		if err := rows.Scan(&e.ID, &userID, &e.Mailbox, &e.Action, &e.Allowed, &detail, &ip, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.UserID = int(userID.Int64)
+		e.Detail = detail.String
+		e.IP = ip.String
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
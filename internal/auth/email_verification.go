@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"mcp-gmail-server/internal/config"
+	"mcp-gmail-server/internal/db"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Password signups must prove they own their address before they can
+// connect Gmail or search. The link carries a signed token naming the user
+// and address, so changing the address voids links sent for the old one.
+// Google sign-in needs no link: Google has already verified the address.
+
+const (
+	verificationTTL = 24 * time.Hour
+	// verificationResendInterval limits verification emails per user.
+	verificationResendInterval = 2 * time.Minute
+)
+
+var (
+	ErrVerificationInvalid     = errors.New("verification link is invalid or expired")
+	ErrVerificationRateLimited = errors.New("a verification email was sent recently, try again in a few minutes")
+	ErrAlreadyVerified         = errors.New("email address is already verified")
+	ErrEmailUnverified         = errors.New("verify your email address first")
+)
+
+type verificationClaims struct {
+	UserID int    `json:"uid"`
+	Email  string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// SendVerificationEmail mails user a verification link, at most once per
+// verificationResendInterval.
+func SendVerificationEmail(user *User) error {
+	if user.EmailVerified {
+		return ErrAlreadyVerified
+	}
+
+	now := time.Now()
+	res, err := db.DB.Exec(`
+		UPDATE users SET verification_sent_at = ?
+		WHERE id = ? AND (verification_sent_at IS NULL OR verification_sent_at < ?)
+	`, now, user.ID, now.Add(-verificationResendInterval))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrVerificationRateLimited
+	}
+
+	token, err := signJWT(&verificationClaims{
+		UserID: user.ID,
+		Email:  user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(verificationTTL)),
+			Issuer:    "mcp-gmail-server",
+			Subject:   "email-verification",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	cfg := config.LoadConfig()
+	link := fmt.Sprintf("%s/verify-email?token=%s", cfg.AllowedOrigin, url.QueryEscape(token))
+	body := fmt.Sprintf("Hello,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThis link expires in 24 hours. If you didn't sign up, you can ignore this email.", link)
+
+	return queueSystemEmail(user.Email, "Confirm your email address", body, verificationTTL)
+}
+
+// VerifyEmail marks the address in a verification link as verified.
+func VerifyEmail(token string) (*User, error) {
+	claims := &verificationClaims{}
+	if _, err := parseJWT(token, claims, jwt.WithExpirationRequired()); err != nil {
+		return nil, ErrVerificationInvalid
+	}
+	if claims.Subject != "email-verification" {
+		return nil, ErrVerificationInvalid
+	}
+
+	user, err := GetUserByID(claims.UserID)
+	if err != nil || user.Email != claims.Email {
+		return nil, ErrVerificationInvalid
+	}
+	if user.EmailVerified {
+		return user, nil
+	}
+
+	if err := MarkEmailVerified(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// MarkEmailVerified records that user proved ownership of their address.
+func MarkEmailVerified(user *User) error {
+	if _, err := db.DB.Exec(`UPDATE users SET email_verified = TRUE WHERE id = ?`, user.ID); err != nil {
+		return err
+	}
+	user.EmailVerified = true
+	return nil
+}
+
+// ClaimUnverifiedUser handles a verified Google sign-in for the address of
+// an unverified password user. Whoever chose that password never proved
+// they own the address, so the password, two-factor enrolment, OAuth
+// client, API keys and sessions set up with it are dropped and the account
+// goes to the Google user.
+func ClaimUnverifiedUser(user *User) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users SET email_verified = TRUE, password_hash = NULL
+		WHERE id = ? AND email_verified = FALSE
+	`, user.ID)
+	if err != nil {
+		return err
+	}
+	// The OAuth client would otherwise carry the owner's next mailbox
+	// connection. Both sealed columns go, so the data key can too.
+	_, err = tx.Exec(`
+		UPDATE users SET mfa_enabled = FALSE, mfa_secret = NULL, mfa_last_step = 0,
+			google_client_id = NULL, google_client_secret = NULL,
+			access_token = NULL, refresh_token = NULL, expiry = NULL, data_key = NULL
+		WHERE id = ?
+	`, user.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = ?`, user.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE api_keys SET active = FALSE WHERE user_id = ?`, user.ID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, time.Now(), user.ID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	user.EmailVerified = true
+	user.PasswordHash = ""
+	user.MFAEnabled = false
+	user.GoogleClientID = ""
+	user.GoogleClientSecret = ""
+	user.AccessToken = ""
+	user.RefreshToken = ""
+	user.Expiry = time.Time{}
+	return nil
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"mcp-gmail-server/internal/db"
+	"mcp-gmail-server/internal/secrets"
+)
+
+// encryptedColumns lists the credential columns sealed with each row's
+// data key.
+var encryptedColumns = map[string][]string{
+	"mailbox_accounts": {"access_token", "refresh_token"},
+	"users":            {"google_client_secret", "mfa_secret"},
+}
+
+// rowBox returns the data key box for a row, creating and storing a data
+// key first if encryption is on and the row has none. Concurrent callers
+// agree on whichever key was stored first.
+func rowBox(table string, id int) (*secrets.Box, error) {
+	var wrapped sql.NullString
+	query := fmt.Sprintf(`SELECT data_key FROM %s WHERE id = ?`, table)
+	if err := db.DB.QueryRow(query, id).Scan(&wrapped); err != nil {
+		return nil, err
+	}
+	if wrapped.String != "" || !secrets.Enabled() {
+		return secrets.OpenBox(wrapped.String)
+	}
+
+	box, err := secrets.NewBox()
+	if err != nil {
+		return nil, err
+	}
+	res, err := db.DB.Exec(fmt.Sprintf(`UPDATE %s SET data_key = ? WHERE id = ? AND data_key IS NULL`, table), box.Wrapped, id)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return box, nil
+	}
+
+	// Someone else stored a key first
+	if err := db.DB.QueryRow(query, id).Scan(&wrapped); err != nil {
+		return nil, err
+	}
+	return secrets.OpenBox(wrapped.String)
+}
+
+// EncryptExistingSecrets seals credential columns still stored as
+// plaintext. Each row is updated only if it hasn't changed since it was
+// read, so it is safe to run while the server is serving traffic.
+func EncryptExistingSecrets() (int, error) {
+	if !secrets.Enabled() {
+		return 0, fmt.Errorf("encryption is not configured")
+	}
+
+	total := 0
+	for table, columns := range encryptedColumns {
+		n, err := encryptTable(table, columns)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	return total, nil
+}
+
+func encryptTable(table string, columns []string) (int, error) {
+	type pending struct {
+		id     int
+		values []sql.NullString
+	}
+
+	rows, err := db.DB.Query(fmt.Sprintf(`SELECT id, %s FROM %s`, strings.Join(columns, ", "), table))
+	if err != nil {
+		return 0, err
+	}
+
+	var todo []pending
+	for rows.Next() {
+		p := pending{values: make([]sql.NullString, len(columns))}
+		dest := []interface{}{&p.id}
+		for i := range p.values {
+			dest = append(dest, &p.values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		for _, v := range p.values {
+			if v.String != "" && !secrets.IsSealed(v.String) {
+				todo = append(todo, p)
+				break
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, p := range todo {
+		box, err := rowBox(table, p.id)
+		if err != nil {
+			return updated, err
+		}
+
+		var set, where string
+		var setArgs, whereArgs []interface{}
+		for i, column := range columns {
+			value := p.values[i].String
+			if !secrets.IsSealed(value) {
+				if value, err = box.Seal(column, value); err != nil {
+					return updated, err
+				}
+			}
+			if set != "" {
+				set += ", "
+			}
+			set += column + " = ?"
+			setArgs = append(setArgs, nullable(p.values[i].Valid, value))
+			// Compare-and-swap against what we read
+			where += " AND " + column + " <=> ?"
+			whereArgs = append(whereArgs, nullable(p.values[i].Valid, p.values[i].String))
+		}
+
+		args := append(append(setArgs, p.id), whereArgs...)
+		res, err := db.DB.Exec(fmt.Sprintf(`UPDATE %s SET %s WHERE id = ?%s`, table, set, where), args...)
+		if err != nil {
+			return updated, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			updated++
+		} else {
+			log.Printf("secrets: %s row %d changed during encryption, skipped (rerun to retry)", table, p.id)
+		}
+	}
+	return updated, nil
+}
+
+// RotateDataKeys re-wraps every data key under the current KEK. Column
+// values are untouched, so it is quick and safe to run live.
+func RotateDataKeys() (int, error) {
+	if !secrets.Enabled() {
+		return 0, fmt.Errorf("encryption is not configured")
+	}
+
+	total := 0
+	for table := range encryptedColumns {
+		rows, err := db.DB.Query(fmt.Sprintf(`SELECT id, data_key FROM %s WHERE data_key IS NOT NULL`, table))
+		if err != nil {
+			return total, err
+		}
+
+		type keyRow struct {
+			id      int
+			wrapped string
+		}
+		var keys []keyRow
+		for rows.Next() {
+			var k keyRow
+			if err := rows.Scan(&k.id, &k.wrapped); err != nil {
+				rows.Close()
+				return total, err
+			}
+			keys = append(keys, k)
+		}
+		rows.Close()
+
+		for _, k := range keys {
+			rewrapped, changed, err := secrets.Rewrap(k.wrapped)
+			if err != nil {
+				return total, fmt.Errorf("%s row %d: %w", table, k.id, err)
+			}
+			if !changed {
+				continue
+			}
+			_, err = db.DB.Exec(fmt.Sprintf(`UPDATE %s SET data_key = ? WHERE id = ? AND data_key = ?`, table),
+				rewrapped, k.id, k.wrapped)
+			if err != nil {
+				return total, err
+			}
+			total++
+		}
+	}
+	return total, nil
+}
+
+func nullable(valid bool, value string) interface{} {
+	if !valid {
+		return nil
+	}
+	return value
+}
@@ -9,21 +9,17 @@ import (
 
 func SaveGoogleCredentials(w http.ResponseWriter, r *http.Request) {
 
-	// 1️⃣ Get auth_token from cookie
-	cookie, err := r.Cookie("auth_token")
+	// 1️⃣ Caller resolved by Middleware
+	user, err := GetUser(r)
 	if err != nil {
-		http.Error(w, "Unauthorized - No cookie", http.StatusUnauthorized)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-
-	claims, err := ValidateToken(cookie.Value)
-	if err != nil {
-		http.Error(w, "Unauthorized - Invalid token", http.StatusUnauthorized)
+	if !GetPrincipal(r).Can(ScopeModify) {
+		http.Error(w, "Forbidden: API key lacks the \"modify\" scope", http.StatusForbidden)
 		return
 	}
 
-	email := claims.Email
-
 	// 2️⃣ Decode request body
 	var req struct {
 		ClientID     string `json:"client_id"`
@@ -35,19 +31,31 @@ func SaveGoogleCredentials(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 3️⃣ Update using email (NOT id)
+	// 3️⃣ Seal the secret with the user's data key
+	box, err := rowBox("users", user.ID)
+	if err != nil {
+		http.Error(w, "Failed to save credentials", 500)
+		return
+	}
+	clientSecret, err := box.Seal("google_client_secret", req.ClientSecret)
+	if err != nil {
+		http.Error(w, "Failed to save credentials", 500)
+		return
+	}
+
+	// 4️⃣ Update
 	_, err = db.DB.Exec(`
         UPDATE users
         SET google_client_id = ?, google_client_secret = ?
-        WHERE email = ?
-    `, req.ClientID, req.ClientSecret, email)
+        WHERE id = ?
+    `, req.ClientID, clientSecret, user.ID)
 
 	if err != nil {
 		http.Error(w, "Failed to save credentials", 500)
 		return
 	}
 
-	fmt.Println("Saved credentials for:", email)
+	fmt.Println("Saved credentials for:", user.Email)
 
 	w.Write([]byte("Credentials saved"))
 }
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrIDTokenMissing   = errors.New("token response has no id_token")
+	ErrEmailNotVerified = errors.New("google account email is not verified")
+)
+
+// googleIssuers are the "iss" values Google uses for ID tokens.
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// GoogleIdentity is the verified subject of a Google ID token. Subject is
+// stable for the lifetime of the Google account; Email can change.
+type GoogleIdentity struct {
+	Subject string
+	Email   string
+	Name    string
+}
+
+type idTokenClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// IDTokenVerifier checks the ID tokens returned by Google's token endpoint.
+type IDTokenVerifier struct {
+	// Keys resolves signing keys; point it at a stub JWKS server in tests.
+	Keys *JWKSCache
+}
+
+// VerifyToken verifies the id_token that came with an OAuth token response.
+// The audience is the client ID the code was exchanged with.
+func (v *IDTokenVerifier) VerifyToken(token *oauth2.Token, audience string) (*GoogleIdentity, error) {
+	raw, _ := token.Extra("id_token").(string)
+	if raw == "" {
+		return nil, ErrIDTokenMissing
+	}
+	return v.Verify(raw, audience)
+}
+
+// Verify checks the signature, audience, issuer, expiry and email_verified
+// claim of a Google ID token.
+func (v *IDTokenVerifier) Verify(raw, audience string) (*GoogleIdentity, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(
+		raw,
+		claims,
+		v.Keys.Keyfunc,
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	issuerOK := false
+	for _, iss := range googleIssuers {
+		if claims.Issuer == iss {
+			issuerOK = true
+		}
+	}
+	if !issuerOK {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("id token has no subject")
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	return &GoogleIdentity{
+		Subject: claims.Subject,
+		Email:   claims.Email,
+		Name:    claims.Name,
+	}, nil
+}
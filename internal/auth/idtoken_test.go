@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"mcp-gmail-server/internal/jwkstest"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const testClientID = "test-client.apps.googleusercontent.com"
+
+// newStubGoogle returns a local signer and a verifier trusting its stub
+// JWKS server.
+func newStubGoogle(t *testing.T) (*jwkstest.Signer, *IDTokenVerifier) {
+	t.Helper()
+	signer := jwkstest.NewSigner(t)
+	return signer, &IDTokenVerifier{Keys: NewJWKSCache(signer.URL)}
+}
+
+func idTokenClaimsFor(email string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            "https://accounts.google.com",
+		"aud":            testClientID,
+		"sub":            "110169484474386276334",
+		"email":          email,
+		"email_verified": true,
+		"name":           "Test User",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestIDTokenVerify(t *testing.T) {
+	signer, verifier := newStubGoogle(t)
+
+	raw := signer.Sign(t, idTokenClaimsFor("user@example.com"))
+	identity, err := verifier.Verify(raw, testClientID)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if identity.Subject != "110169484474386276334" || identity.Email != "user@example.com" || identity.Name != "Test User" {
+		t.Fatalf("got %+v", identity)
+	}
+}
+
+func TestIDTokenVerifyRejects(t *testing.T) {
+	signer, verifier := newStubGoogle(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		key    *rsa.PrivateKey
+		kid    string
+		want   error
+	}{
+		{name: "wrong audience", modify: func(c jwt.MapClaims) { c["aud"] = "other-client.apps.googleusercontent.com" }},
+		{name: "wrong issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "no expiry", modify: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "email not verified", modify: func(c jwt.MapClaims) { c["email_verified"] = false }, want: ErrEmailNotVerified},
+		{name: "no subject", modify: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{name: "unknown kid", kid: "rotated-away"},
+		{name: "wrong signing key", key: otherKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := idTokenClaimsFor("user@example.com")
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			signWith, kid := signer.Key, jwkstest.KeyID
+			if tt.key != nil {
+				signWith = tt.key
+			}
+			if tt.kid != "" {
+				kid = tt.kid
+			}
+
+			_, err := verifier.Verify(jwkstest.SignWith(t, signWith, kid, claims), testClientID)
+			if err == nil {
+				t.Fatal("Verify accepted a bad token")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIDTokenVerifyRejectsHS256(t *testing.T) {
+	_, verifier := newStubGoogle(t)
+
+	// Signed with a shared secret instead of Google's key
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, idTokenClaimsFor("user@example.com"))
+	token.Header["kid"] = jwkstest.KeyID
+	raw, err := token.SignedString([]byte("guessable"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifier.Verify(raw, testClientID); err == nil {
+		t.Fatal("Verify accepted an HS256 token")
+	}
+}
+
+func TestIDTokenVerifyToken(t *testing.T) {
+	signer, verifier := newStubGoogle(t)
+
+	if _, err := verifier.VerifyToken(&oauth2.Token{AccessToken: "ya29.x"}, testClientID); !errors.Is(err, ErrIDTokenMissing) {
+		t.Fatalf("got %v, want ErrIDTokenMissing", err)
+	}
+
+	raw := signer.Sign(t, idTokenClaimsFor("user@example.com"))
+	token := (&oauth2.Token{AccessToken: "ya29.x"}).WithExtra(map[string]any{"id_token": raw})
+	if _, err := verifier.VerifyToken(token, testClientID); err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+}
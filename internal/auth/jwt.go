@@ -13,26 +13,23 @@ type SessionClaims struct {
 	jwt.RegisteredClaims
 }
 
-var jwtKey []byte
-
-func InitJWT(secret string) {
-	jwtKey = []byte(secret)
-}
-
-func GenerateToken(userID int, email string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// GenerateToken signs a session JWT. sessionID becomes the "jti" and is
+// checked against the session store on every request, so the token's own
+// expiry is only the session's maximum age.
+func GenerateToken(userID int, email, sessionID string) (string, error) {
+	expirationTime := time.Now().Add(SessionMaxAge)
 	claims := &SessionClaims{
 		UserID: userID,
 		Email:  email,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "mcp-gmail-server",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := signJWT(claims)
 	if err != nil {
 		return "", err
 	}
@@ -43,12 +40,7 @@ func GenerateToken(userID int, email string) (string, error) {
 func ValidateToken(tokenString string) (*SessionClaims, error) {
 	claims := &SessionClaims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtKey, nil
-	})
+	token, err := parseJWT(tokenString, claims)
 
 	if err != nil {
 		return nil, err
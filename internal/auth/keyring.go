@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"mcp-gmail-server/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Session and OAuth-state JWTs are signed by a keyring. Keys come from
+// JWT_SIGNING_KEYS (or a file named by JWT_SIGNING_KEYS_FILE) as a comma or
+// newline separated list of
+//
+//	kid:alg:base64key[@activation]
+//
+// alg is HS256 (base64 secret, at least 32 bytes), EdDSA (base64 Ed25519
+// seed) or RS256 (base64 PKCS#8 or PKCS#1 DER). activation is an optional
+// RFC 3339 time. The signing key is the one activated most recently (ties go
+// to the earlier entry, so without activation times the first entry signs).
+// Once a key activates, older keys keep verifying for JWT_KEY_OVERLAP and
+// are then rejected, so a rotation can be scheduled ahead of time: add the
+// next key with a future activation, and drop the old one after the overlap.
+//
+// Without JWT_SIGNING_KEYS, JWT_SECRET is used as a single HS256 key. Tokens
+// without a "kid" header (issued before the keyring) verify against it.
+// Asymmetric public keys are published at /.well-known/jwks.json.
+
+// legacyKeyID names the JWT_SECRET key.
+const legacyKeyID = "legacy"
+
+// minHMACKeyLen is the shortest HS256 secret accepted in production.
+const minHMACKeyLen = 32
+
+type signingKey struct {
+	id         string
+	method     jwt.SigningMethod
+	private    interface{}
+	public     interface{}
+	activeFrom time.Time
+}
+
+// Keyring holds the JWT signing and verification keys.
+type Keyring struct {
+	keys    []*signingKey
+	overlap time.Duration
+}
+
+var signingKeys *Keyring
+
+// InitJWT loads the keyring from the configuration. In production it
+// refuses the development fallback secret and short HMAC secrets.
+func InitJWT(cfg *config.Config) error {
+	spec := cfg.JWTSigningKeys
+	if cfg.JWTSigningKeysFile != "" {
+		data, err := os.ReadFile(cfg.JWTSigningKeysFile)
+		if err != nil {
+			return fmt.Errorf("read JWT signing keys: %w", err)
+		}
+		spec = string(data)
+	}
+
+	var ring *Keyring
+	if strings.TrimSpace(spec) != "" {
+		var err error
+		if ring, err = ParseKeyring(spec, cfg.JWTKeyOverlap); err != nil {
+			return err
+		}
+	} else {
+		if cfg.Production && (cfg.JWTSecret == "" || cfg.JWTSecret == config.DevJWTSecret) {
+			return errors.New("refusing to start in production without JWT_SECRET or JWT_SIGNING_KEYS")
+		}
+		if cfg.JWTSecret == config.DevJWTSecret {
+			log.Println("Warning: JWT_SECRET not set, signing sessions with the development secret")
+		}
+		ring = &Keyring{keys: []*signingKey{hmacKey(legacyKeyID, []byte(cfg.JWTSecret))}, overlap: cfg.JWTKeyOverlap}
+	}
+
+	for _, k := range ring.keys {
+		if secret, ok := k.private.([]byte); ok && len(secret) < minHMACKeyLen {
+			if cfg.Production {
+				return fmt.Errorf("JWT key %q: HS256 secrets must be at least %d bytes in production", k.id, minHMACKeyLen)
+			}
+			log.Printf("Warning: JWT key %q is shorter than %d bytes", k.id, minHMACKeyLen)
+		}
+	}
+
+	signingKeys = ring
+	return nil
+}
+
+func hmacKey(id string, secret []byte) *signingKey {
+	return &signingKey{id: id, method: jwt.SigningMethodHS256, private: secret, public: secret}
+}
+
+// ParseKeyring parses a key list (see above).
+func ParseKeyring(spec string, overlap time.Duration) (*Keyring, error) {
+	entries := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+
+	ring := &Keyring{overlap: overlap}
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var activeFrom time.Time
+		if i := strings.LastIndex(entry, "@"); i >= 0 {
+			t, err := time.Parse(time.RFC3339, entry[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("JWT key entry %q: invalid activation time", entry[:i])
+			}
+			entry, activeFrom = entry[:i], t
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, errors.New("JWT key entry must be kid:alg:base64key[@activation]")
+		}
+		id, alg := parts[0], parts[1]
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate JWT key id %q", id)
+		}
+		seen[id] = true
+
+		raw, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: invalid base64", id)
+		}
+
+		k, err := parseSigningKey(id, alg, raw)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", id, err)
+		}
+		k.activeFrom = activeFrom
+		ring.keys = append(ring.keys, k)
+	}
+	if len(ring.keys) == 0 {
+		return nil, errors.New("no JWT signing keys given")
+	}
+	return ring, nil
+}
+
+func parseSigningKey(id, alg string, raw []byte) (*signingKey, error) {
+	switch alg {
+	case "HS256":
+		return hmacKey(id, raw), nil
+
+	case "EdDSA":
+		if len(raw) != ed25519.SeedSize {
+			return nil, fmt.Errorf("EdDSA keys must be a %d byte seed", ed25519.SeedSize)
+		}
+		private := ed25519.NewKeyFromSeed(raw)
+		return &signingKey{id: id, method: jwt.SigningMethodEdDSA, private: private, public: private.Public()}, nil
+
+	case "RS256":
+		var private *rsa.PrivateKey
+		if key, err := x509.ParsePKCS8PrivateKey(raw); err == nil {
+			rsaKey, ok := key.(*rsa.PrivateKey)
+			if !ok {
+				return nil, errors.New("RS256 key is not an RSA key")
+			}
+			private = rsaKey
+		} else if private, err = x509.ParsePKCS1PrivateKey(raw); err != nil {
+			return nil, errors.New("RS256 key must be PKCS#8 or PKCS#1 DER")
+		}
+		if private.N.BitLen() < 2048 {
+			return nil, errors.New("RS256 keys must be at least 2048 bits")
+		}
+		return &signingKey{id: id, method: jwt.SigningMethodRS256, private: private, public: &private.PublicKey}, nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q (HS256, EdDSA or RS256)", alg)
+}
+
+// signer is the key that signs new tokens at now.
+func (k *Keyring) signer(now time.Time) *signingKey {
+	var best *signingKey
+	for _, key := range k.keys {
+		if key.activeFrom.After(now) {
+			continue
+		}
+		if best == nil || key.activeFrom.After(best.activeFrom) {
+			best = key
+		}
+	}
+	return best
+}
+
+// verifier returns the key that may verify a token with this kid at now.
+// Keys older than the signer stop verifying once the overlap has passed.
+func (k *Keyring) verifier(kid string, now time.Time) (*signingKey, error) {
+	if kid == "" {
+		kid = legacyKeyID
+	}
+
+	var key *signingKey
+	for _, candidate := range k.keys {
+		if candidate.id == kid {
+			key = candidate
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if signer := k.signer(now); signer != nil && key != signer &&
+		key.activeFrom.Before(signer.activeFrom) && now.After(signer.activeFrom.Add(k.overlap)) {
+		return nil, fmt.Errorf("key %q was retired", kid)
+	}
+	return key, nil
+}
+
+// signJWT signs claims with the current key and names it in the kid header.
+func signJWT(claims jwt.Claims) (string, error) {
+	key := signingKeys.signer(time.Now())
+	if key == nil {
+		return "", errors.New("no JWT signing key is active yet")
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+// parseJWT verifies a token signed by signJWT. The algorithm must be the
+// one of the key named by its kid header.
+func parseJWT(raw string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := signingKeys.verifier(kid, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.public, nil
+	}, opts...)
+}
+
+// PublicJWKS is the JSON Web Key Set of the asymmetric keys, for services
+// that verify our tokens. HMAC keys are never published.
+func PublicJWKS() map[string]interface{} {
+	keys := []map[string]string{}
+	now := time.Now()
+	for _, k := range signingKeys.keys {
+		if _, err := signingKeys.verifier(k.id, now); err != nil {
+			continue
+		}
+
+		switch public := k.public.(type) {
+		case ed25519.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"use": "sig",
+				"alg": "EdDSA",
+				"kid": k.id,
+				"x":   base64.RawURLEncoding.EncodeToString(public),
+			})
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"kid": k.id,
+				"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		}
+	}
+	return map[string]interface{}{"keys": keys}
+}
+
+// GenerateSigningKey returns a new base64 key for alg, ready for a
+// JWT_SIGNING_KEYS entry.
+func GenerateSigningKey(alg string) (string, error) {
+	switch alg {
+	case "HS256":
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+
+	case "EdDSA":
+		b := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+
+	case "RS256":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return "", err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(der), nil
+	}
+	return "", fmt.Errorf("unsupported algorithm %q (HS256, EdDSA or RS256)", alg)
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"mcp-gmail-server/internal/throttle"
+)
+
+// Login attempts are throttled per client IP and per account. Both slow
+// down with every failure past a few free ones; an account that keeps
+// failing is locked for a while and its owner is emailed. Unknown
+// addresses are counted exactly like real ones, so the responses don't
+// reveal which accounts exist. Password reset requests are limited per IP
+// and per address, and redemptions per IP.
+var loginThrottle struct {
+	ip, account, resetRequest, resetEmail, resetRedeem *throttle.Limiter
+}
+
+// InitThrottle sets up the limiters. lockAfter failed logins lock an
+// account for lockFor.
+func InitThrottle(store throttle.Store, lockAfter int, lockFor time.Duration) {
+	loginThrottle.ip = &throttle.Limiter{Store: store, Prefix: "login-ip:", Policy: throttle.Policy{
+		Window:    time.Hour,
+		Free:      20,
+		Delay:     time.Second,
+		MaxDelay:  time.Minute,
+		LockAfter: 100,
+		LockFor:   time.Hour,
+	}}
+	loginThrottle.account = &throttle.Limiter{Store: store, Prefix: "login-account:", Policy: throttle.Policy{
+		Window:    lockFor,
+		Free:      3,
+		Delay:     time.Second,
+		MaxDelay:  30 * time.Second,
+		LockAfter: lockAfter,
+		LockFor:   lockFor,
+	}}
+	loginThrottle.resetRequest = &throttle.Limiter{Store: store, Prefix: "reset-request-ip:", Policy: throttle.Policy{
+		Window:    time.Hour,
+		LockAfter: 20,
+		LockFor:   time.Hour,
+	}}
+	loginThrottle.resetEmail = &throttle.Limiter{Store: store, Prefix: "reset-request-email:", Policy: throttle.Policy{
+		Window:    time.Hour,
+		LockAfter: 3,
+		LockFor:   time.Hour,
+	}}
+	loginThrottle.resetRedeem = &throttle.Limiter{Store: store, Prefix: "reset-redeem-ip:", Policy: throttle.Policy{
+		Window:    time.Hour,
+		Free:      5,
+		Delay:     time.Second,
+		MaxDelay:  time.Minute,
+		LockAfter: 30,
+		LockFor:   time.Hour,
+	}}
+}
+
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// wait is the longest wait of the limiters for their keys. Store errors
+// fail open: the password check still stands behind the throttle.
+func wait(limiters []*throttle.Limiter, keys []string) time.Duration {
+	var longest time.Duration
+	for i, l := range limiters {
+		if l == nil {
+			continue
+		}
+		d, err := l.Wait(keys[i])
+		if err != nil {
+			log.Printf("Throttle check failed: %v", err)
+			continue
+		}
+		if d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// LoginWait reports how long the caller must wait before trying to sign in
+// as email (0 to go ahead).
+func LoginWait(ip, email string) time.Duration {
+	return wait([]*throttle.Limiter{loginThrottle.ip, loginThrottle.account}, []string{ip, accountKey(email)})
+}
+
+// RecordLoginFailure counts a wrong password (or two-factor code) and
+// tells the owner when it locks their account.
+func RecordLoginFailure(ip, email string) {
+	if loginThrottle.ip == nil {
+		return
+	}
+	if _, err := loginThrottle.ip.Fail(ip); err != nil {
+		log.Printf("Throttle update failed: %v", err)
+	}
+
+	locked, err := loginThrottle.account.Fail(accountKey(email))
+	if err != nil {
+		log.Printf("Throttle update failed: %v", err)
+	}
+	if locked {
+		notifyLockout(email, ip)
+	}
+}
+
+// RecordLoginSuccess clears the account's failures. The IP's stay, or
+// one good account would shield guesses at others.
+func RecordLoginSuccess(email string) {
+	if loginThrottle.account == nil {
+		return
+	}
+	if err := loginThrottle.account.Reset(accountKey(email)); err != nil {
+		log.Printf("Throttle reset failed: %v", err)
+	}
+}
+
+// UnlockLogin lifts an account lockout and clears its failures.
+func UnlockLogin(email string) error {
+	if loginThrottle.account == nil {
+		return nil
+	}
+	return loginThrottle.account.Reset(accountKey(email))
+}
+
+func notifyLockout(email, ip string) {
+	user, err := GetUserFromDB(email)
+	if err != nil {
+		// Nobody to tell
+		return
+	}
+	log.Printf("Login locked for user %d after repeated failures", user.ID)
+
+	lockFor := loginThrottle.account.Policy.LockFor
+	body := fmt.Sprintf("Hello,\n\nThere were too many failed sign-in attempts on your account, the latest from %s. Sign-in is blocked for %s.\n\nIf this wasn't you, consider changing your password and turning on two-factor authentication.", ip, lockFor)
+	if err := queueSystemEmail(user.Email, "Sign-in temporarily blocked", body, lockFor); err != nil {
+		log.Printf("Failed to queue lockout notice for user %d: %v", user.ID, err)
+	}
+}
+
+// ResetRequestWait counts a password reset request from ip and reports how
+// long it must wait if it has made too many.
+func ResetRequestWait(ip string) time.Duration {
+	l := loginThrottle.resetRequest
+	if d := wait([]*throttle.Limiter{l}, []string{ip}); d > 0 {
+		return d
+	}
+	if l != nil {
+		if _, err := l.Fail(ip); err != nil {
+			log.Printf("Throttle update failed: %v", err)
+		}
+	}
+	return 0
+}
+
+// ResetEmailAllowed counts a password reset request for email and reports
+// whether another email may be sent. Callers answer the same either way.
+func ResetEmailAllowed(email string) bool {
+	l := loginThrottle.resetEmail
+	key := accountKey(email)
+	if wait([]*throttle.Limiter{l}, []string{key}) > 0 {
+		return false
+	}
+	if l != nil {
+		if _, err := l.Fail(key); err != nil {
+			log.Printf("Throttle update failed: %v", err)
+		}
+	}
+	return true
+}
+
+// ResetRedeemWait reports how long ip must wait before trying another
+// reset token.
+func ResetRedeemWait(ip string) time.Duration {
+	return wait([]*throttle.Limiter{loginThrottle.resetRedeem}, []string{ip})
+}
+
+// RecordResetFailure counts an invalid or expired reset token from ip.
+func RecordResetFailure(ip string) {
+	if loginThrottle.resetRedeem == nil {
+		return
+	}
+	if _, err := loginThrottle.resetRedeem.Fail(ip); err != nil {
+		log.Printf("Throttle update failed: %v", err)
+	}
+}
@@ -0,0 +1,374 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"mcp-gmail-server/internal/db"
+	"mcp-gmail-server/internal/secrets"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Two-factor authentication: users enrol a TOTP authenticator and get a set
+// of one-time recovery codes. Password logins for enrolled users then take
+// two steps, /auth/login returns a short-lived challenge token which
+// /auth/login/mfa exchanges, together with a code, for the session. Google
+// sign-in counts as the first step only: the callback redirects with the
+// challenge token as mfa_token.
+
+const (
+	// mfaChallengeTTL is how long the second login step may take.
+	mfaChallengeTTL = 5 * time.Minute
+
+	recoveryCodeCount = 10
+
+	// mfaPolicyTTL bounds how stale the cached role policies may be on
+	// other instances after a change.
+	mfaPolicyTTL = time.Minute
+)
+
+var (
+	ErrMFAInvalidCode     = errors.New("invalid two-factor code")
+	ErrMFAAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrMFANotStarted      = errors.New("start two-factor enrolment first")
+	ErrMFANotEnabled      = errors.New("two-factor authentication is not enabled")
+	ErrMFAChallengeFailed = errors.New("two-factor login expired, sign in again")
+)
+
+// MFAStatus describes a user's enrolment.
+type MFAStatus struct {
+	Enabled bool `json:"enabled"`
+	// Required is set when the user's role must use two-factor authentication
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// GetMFAStatus reports a user's enrolment and recovery codes left.
+func GetMFAStatus(user *User) (*MFAStatus, error) {
+	status := &MFAStatus{Enabled: user.MFAEnabled, Required: MFARequired(user.Role)}
+	err := db.DB.QueryRow(`
+		SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = ? AND used_at IS NULL
+	`, user.ID).Scan(&status.RecoveryCodesLeft)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// loadMFASecret returns the user's TOTP secret (confirmed or pending) and
+// the last time step used.
+func loadMFASecret(userID int) (string, int64, error) {
+	var sealed, dataKey sql.NullString
+	var lastStep int64
+	err := db.DB.QueryRow(`
+		SELECT mfa_secret, data_key, mfa_last_step FROM users WHERE id = ?
+	`, userID).Scan(&sealed, &dataKey, &lastStep)
+	if err != nil {
+		return "", 0, err
+	}
+	if sealed.String == "" {
+		return "", 0, ErrMFANotStarted
+	}
+
+	box, err := secrets.OpenBox(dataKey.String)
+	if err != nil {
+		return "", 0, err
+	}
+	secret, err := box.Open("mfa_secret", sealed.String)
+	if err != nil {
+		return "", 0, err
+	}
+	return secret, lastStep, nil
+}
+
+// BeginMFAEnrollment stores a new pending TOTP secret and returns it with
+// its provisioning URI. It replaces any earlier unconfirmed secret.
+func BeginMFAEnrollment(user *User) (string, string, error) {
+	if user.MFAEnabled {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	box, err := rowBox("users", user.ID)
+	if err != nil {
+		return "", "", err
+	}
+	sealed, err := box.Seal("mfa_secret", secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	res, err := db.DB.Exec(`
+		UPDATE users SET mfa_secret = ?, mfa_last_step = 0
+		WHERE id = ? AND mfa_enabled = FALSE
+	`, sealed, user.ID)
+	if err != nil {
+		return "", "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+	return secret, totpURI(secret, user.Email), nil
+}
+
+// ConfirmMFAEnrollment turns two-factor authentication on once the user
+// proves their authenticator works, and returns fresh recovery codes.
+func ConfirmMFAEnrollment(user *User, code string) ([]string, error) {
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if err := verifyTOTP(user.ID, code); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE users SET mfa_enabled = TRUE WHERE id = ?`, user.ID); err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// verifyTOTP checks a code against the stored secret and marks its time
+// step used, so a code can't be replayed.
+func verifyTOTP(userID int, code string) error {
+	secret, lastStep, err := loadMFASecret(userID)
+	if err != nil {
+		return err
+	}
+
+	step, ok := checkTOTP(secret, code, time.Now(), lastStep)
+	if !ok {
+		return ErrMFAInvalidCode
+	}
+
+	// Two requests racing with the same code: only one moves the step on
+	res, err := db.DB.Exec(`
+		UPDATE users SET mfa_last_step = ? WHERE id = ? AND mfa_last_step < ?
+	`, step, userID, step)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMFAInvalidCode
+	}
+	return nil
+}
+
+// VerifyMFA checks the second factor of an enrolled user: a TOTP code or,
+// failing that, an unused recovery code (which is then spent).
+func VerifyMFA(user *User, code, recoveryCode string) error {
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+	if code != "" {
+		return verifyTOTP(user.ID, code)
+	}
+	if recoveryCode != "" {
+		return useRecoveryCode(user.ID, recoveryCode)
+	}
+	return ErrMFAInvalidCode
+}
+
+// RegenerateRecoveryCodes replaces all of a user's recovery codes.
+func RegenerateRecoveryCodes(user *User) ([]string, error) {
+	if !user.MFAEnabled {
+		return nil, ErrMFANotEnabled
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// DisableMFA removes a user's authenticator and recovery codes. Callers
+// check the role policy; admins use it to reset a locked-out user.
+func DisableMFA(userID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users SET mfa_enabled = FALSE, mfa_secret = NULL, mfa_last_step = 0
+		WHERE id = ?
+	`, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recoveryEncoding spells codes in lowercase base32 without padding.
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// replaceRecoveryCodes stores a new set of codes (hashed, like API keys)
+// and returns them for showing once.
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := recoveryEncoding.EncodeToString(b)[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+
+		_, err := tx.Exec(`
+			INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES (?, ?)
+		`, userID, hashAPIKey(normalizeRecoveryCode(codes[i])))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+func useRecoveryCode(userID int, code string) error {
+	res, err := db.DB.Exec(`
+		UPDATE mfa_recovery_codes SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, time.Now(), userID, hashAPIKey(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMFAInvalidCode
+	}
+	return nil
+}
+
+// mfaChallenge is the token between the password and code login steps.
+type mfaChallenge struct {
+	UserID int `json:"uid"`
+	jwt.RegisteredClaims
+}
+
+// IssueMFAChallenge signs the token /auth/login hands to users who still
+// need to enter a code.
+func IssueMFAChallenge(user *User) (string, error) {
+	now := time.Now()
+	return signJWT(&mfaChallenge{
+		UserID: user.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaChallengeTTL)),
+			Issuer:    "mcp-gmail-server",
+			Subject:   "mfa-challenge",
+		},
+	})
+}
+
+// ParseMFAChallenge returns the user a challenge token was issued to.
+func ParseMFAChallenge(raw string) (*User, error) {
+	claims := &mfaChallenge{}
+	if _, err := parseJWT(raw, claims, jwt.WithExpirationRequired()); err != nil {
+		return nil, ErrMFAChallengeFailed
+	}
+	if claims.Subject != "mfa-challenge" {
+		return nil, ErrMFAChallengeFailed
+	}
+
+	user, err := GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, ErrMFAChallengeFailed
+	}
+	return user, nil
+}
+
+var mfaPolicies struct {
+	sync.Mutex
+	required map[string]bool
+	loadedAt time.Time
+}
+
+// MFARequired reports whether users with role must enrol. Policies are
+// cached for mfaPolicyTTL; lookups that fail keep the last known answer.
+func MFARequired(role string) bool {
+	mfaPolicies.Lock()
+	defer mfaPolicies.Unlock()
+
+	if mfaPolicies.required == nil || time.Since(mfaPolicies.loadedAt) > mfaPolicyTTL {
+		if policies, err := MFAPolicies(); err == nil {
+			mfaPolicies.required = policies
+			mfaPolicies.loadedAt = time.Now()
+		}
+	}
+	return mfaPolicies.required[role]
+}
+
+// MFAPolicies returns the roles with a stored policy.
+func MFAPolicies() (map[string]bool, error) {
+	rows, err := db.DB.Query(`SELECT role, required FROM mfa_policies`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := map[string]bool{}
+	for rows.Next() {
+		var role string
+		var required bool
+		if err := rows.Scan(&role, &required); err != nil {
+			return nil, err
+		}
+		policies[role] = required
+	}
+	return policies, rows.Err()
+}
+
+// SetMFAPolicy requires (or stops requiring) two-factor authentication
+// for a role.
+func SetMFAPolicy(role string, required bool) error {
+	_, err := db.DB.Exec(`
+		INSERT INTO mfa_policies (role, required) VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE required = VALUES(required)
+	`, role, required)
+	if err != nil {
+		return err
+	}
+
+	mfaPolicies.Lock()
+	mfaPolicies.required = nil
+	mfaPolicies.Unlock()
+	return nil
+}
@@ -2,14 +2,12 @@ package auth
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
+	"log"
+	"net"
 	"net/http"
 	"strings"
 	"time"
-
-	"mcp-gmail-server/internal/db"
 )
 
 type User struct {
@@ -22,55 +20,242 @@ type User struct {
 	RefreshToken       string
 	Expiry             time.Time
 	PasswordHash       string
+	Active             bool
+	MFAEnabled         bool
+	EmailVerified      bool
 }
 
 type ctxKey string
 
-const userCtxKey ctxKey = "user"
+const principalCtxKey ctxKey = "principal"
 
-func Middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
-		if !strings.HasPrefix(auth, "Bearer ") {
-			http.Error(w, "Missing API key", http.StatusUnauthorized)
-			return
+// How a request was authenticated, see GetAuthMethod.
+const (
+	MethodSession     = "session"
+	MethodAPIKey      = "api_key"
+	MethodAccessToken = "access_token"
+)
+
+// APIKeyPrefix marks our own API keys; other bearer tokens are treated as
+// Google access tokens.
+const APIKeyPrefix = "mcp_live_"
+
+var (
+	ErrNoCredentials  = errors.New("no credentials")
+	ErrUserInactive   = errors.New("user is deactivated")
+	ErrSessionRevoked = errors.New("session was revoked")
+)
+
+var accessTokens *AccessTokenVerifier
+
+// InitAccessTokens accepts Google OAuth access tokens as bearer credentials.
+// Without it only API keys are accepted in the Authorization header.
+func InitAccessTokens(v *AccessTokenVerifier) {
+	accessTokens = v
+}
+
+// Principal is the caller behind an authenticated request.
+type Principal struct {
+	User   *User
+	Method string
+	// APIKeyID is set when Method is MethodAPIKey.
+	APIKeyID int
+	// SessionID is set when Method is MethodSession.
+	SessionID string
+	// Scopes the caller holds, see Can.
+	Scopes []string
+}
+
+// Authenticate resolves the caller from an "Authorization: Bearer" API key
+// or Google access token, falling back to the auth_token session cookie.
+func Authenticate(r *http.Request) (*Principal, error) {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		raw := strings.TrimPrefix(header, "Bearer ")
+
+		if strings.HasPrefix(raw, APIKeyPrefix) {
+			user, key, err := LookupAPIKey(raw)
+			if err != nil {
+				return nil, err
+			}
+			if err := touchAPIKey(key, ClientIP(r)); err != nil {
+				log.Printf("Failed to record use of API key %d: %v", key.ID, err)
+			}
+			return &Principal{User: user, Method: MethodAPIKey, APIKeyID: key.ID, Scopes: key.Scopes}, nil
 		}
 
-		rawKey := strings.TrimPrefix(auth, "Bearer ")
+		if accessTokens == nil {
+			return nil, errors.New("access tokens are not accepted")
+		}
+		user, err := accessTokens.Verify(raw)
+		if err != nil {
+			return nil, err
+		}
+		if !user.Active {
+			return nil, ErrUserInactive
+		}
+		return &Principal{User: user, Method: MethodAccessToken, Scopes: sessionScopes(user)}, nil
+	}
+
+	user, session, err := cookieSession(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{User: user, Method: MethodSession, SessionID: session.ID, Scopes: sessionScopes(user)}, nil
+}
+
+// SessionUser resolves the user behind the auth_token cookie alone.
+func SessionUser(r *http.Request) (*User, error) {
+	user, _, err := cookieSession(r)
+	return user, err
+}
+
+// cookieSession validates the auth_token cookie and its stored session.
+func cookieSession(r *http.Request) (*User, *Session, error) {
+	cookie, err := r.Cookie("auth_token")
+	if err != nil {
+		return nil, nil, ErrNoCredentials
+	}
+	claims, err := ValidateToken(cookie.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := checkSession(claims.ID, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if session.UserID != claims.UserID {
+		return nil, nil, ErrSessionRevoked
+	}
+	user, err := GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.Active {
+		return nil, nil, ErrUserInactive
+	}
+	return user, session, nil
+}
+
+// WithPrincipal returns a copy of r carrying the caller for GetPrincipal,
+// GetUser, GetAPIKeyID and GetAuthMethod.
+func WithPrincipal(r *http.Request, p *Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalCtxKey, p))
+}
 
-		hashBytes := sha256.Sum256([]byte(rawKey))
-		hash := hex.EncodeToString(hashBytes[:])
+// trustedProxyHops is how many proxies in front of the server append to
+// X-Forwarded-For. Entries to the left of theirs are set by the client.
+var trustedProxyHops int
 
-		var user User
-		var active bool
+// SetTrustedProxyHops sets how many X-Forwarded-For entries, counted from
+// the right, were added by proxies we trust. 0 ignores the header.
+func SetTrustedProxyHops(hops int) {
+	trustedProxyHops = hops
+}
 
-		err := db.DB.QueryRow(`
-			SELECT u.id, u.email, u.role, u.active
-			FROM api_keys k
-			JOIN users u ON u.id = k.user_id
-			WHERE k.key_hash=? AND k.active=TRUE
-		`, hash).Scan(&user.ID, &user.Email, &user.Role, &active)
+// ClientIP is the caller's address: the peer address, or with trusted
+// proxies the address the outermost of them saw. Anything further left in
+// X-Forwarded-For is up to the client and is ignored.
+func ClientIP(r *http.Request) string {
+	if trustedProxyHops > 0 {
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(header, ",") {
+				if hop = strings.TrimSpace(hop); hop != "" {
+					hops = append(hops, hop)
+				}
+			}
+		}
+		if len(hops) > 0 {
+			return hops[max(len(hops)-trustedProxyHops, 0)]
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
 
-		if err != nil || !active {
-			http.Error(w, "Invalid or revoked key", http.StatusForbidden)
+// Middleware rejects unauthenticated requests and passes the caller on in
+// the request context.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := Authenticate(r)
+		if err != nil {
+			if errors.Is(err, ErrUserInactive) {
+				http.Error(w, "Account deactivated", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mcp-gmail-server"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userCtxKey, user)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		// Roles required to use two-factor authentication may only enrol
+		// until they have, and only from a session: API keys and Google
+		// access tokens are refused outright, or they would skip enrolment
+		if !p.User.MFAEnabled && MFARequired(p.User.Role) {
+			if p.Method != MethodSession {
+				http.Error(w, "Two-factor authentication required: sign in and enrol at /auth/mfa", http.StatusForbidden)
+				return
+			}
+			if !strings.HasPrefix(r.URL.Path, "/auth/mfa") {
+				http.Error(w, "Two-factor authentication required: enrol at /auth/mfa", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, WithPrincipal(r, p))
+	})
+}
+
+// AdminOnly wraps handlers that need the admin role (and, for API keys,
+// the admin scope). It must run inside Middleware.
+func AdminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := GetPrincipal(r)
+		if p == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if p.User.Role != "admin" || !p.Can(ScopeAdmin) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
 
+// GetPrincipal returns the caller resolved by Middleware, or nil.
+func GetPrincipal(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalCtxKey).(*Principal)
+	return p
+}
+
 func GetUser(r *http.Request) (*User, error) {
-	userVal := r.Context().Value(userCtxKey)
-	if userVal == nil {
+	p := GetPrincipal(r)
+	if p == nil {
 		return nil, errors.New("user not in context")
 	}
 
-	user, ok := userVal.(User)
-	if !ok {
-		return nil, errors.New("invalid user type")
+	user := *p.User
+	return &user, nil
+}
+
+// GetAPIKeyID returns the ID of the API key that authenticated the request,
+// or 0 for other kinds of authentication.
+func GetAPIKeyID(r *http.Request) int {
+	if p := GetPrincipal(r); p != nil {
+		return p.APIKeyID
 	}
+	return 0
+}
 
-	return &user, nil
+// GetAuthMethod reports how the request was authenticated (MethodSession,
+// MethodAPIKey or MethodAccessToken), or "" outside Middleware.
+func GetAuthMethod(r *http.Request) string {
+	if p := GetPrincipal(r); p != nil {
+		return p.Method
+	}
+	return ""
 }
@@ -2,23 +2,52 @@ package auth
 
 import (
 	"os"
+	"slices"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
-func BuildOAuthConfig(user *User) *oauth2.Config {
+// GmailScopes are the Gmail API scopes every mailbox connection requests.
+var GmailScopes = []string{
+	"https://www.googleapis.com/auth/gmail.readonly",
+	"https://www.googleapis.com/auth/gmail.send",
+}
+
+// AddGmailScope adds an optional scope (e.g. gmail.ModifyScope) to
+// GmailScopes. Mailboxes connected before it was added have to reconnect
+// to grant it. The slice is copied, so earlier copies of GmailScopes are
+// never written through.
+func AddGmailScope(scope string) {
+	GmailScopes = append(slices.Clip(GmailScopes), scope)
+}
+
+// NewOAuthConfig is the OAuth client config for connecting a mailbox and
+// signing in: GmailScopes plus the identity scopes.
+func NewOAuthConfig(clientID, clientSecret, redirectURL string) *oauth2.Config {
 	return &oauth2.Config{
-		ClientID:     user.GoogleClientID,
-		ClientSecret: user.GoogleClientSecret,
-		RedirectURL:  os.Getenv("GOOGLE_REDIRECT_URL"),
-		Scopes: []string{
-			"https://www.googleapis.com/auth/gmail.readonly",
-			"https://www.googleapis.com/auth/gmail.send",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes: append(slices.Clone(GmailScopes),
 			"https://www.googleapis.com/auth/userinfo.email",
 			"https://www.googleapis.com/auth/userinfo.profile",
 			"openid",
-		},
+		),
 		Endpoint: google.Endpoint,
 	}
 }
+
+func BuildOAuthConfig(user *User) *oauth2.Config {
+	clientID := user.GoogleClientID
+	clientSecret := user.GoogleClientSecret
+
+	// Users without their own keys were connected through the server's
+	// OAuth client, so refreshes must go through that client too.
+	if clientID == "" || clientSecret == "" {
+		clientID = os.Getenv("GOOGLE_CLIENT_ID")
+		clientSecret = os.Getenv("GOOGLE_CLIENT_SECRET")
+	}
+
+	return NewOAuthConfig(clientID, clientSecret, os.Getenv("GOOGLE_REDIRECT_URL"))
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// oauthFlowTTL bounds how long a user may take on Google's consent screen.
+const oauthFlowTTL = 10 * time.Minute
+
+var (
+	ErrOAuthStateMissing  = errors.New("OAuth state is missing or expired, start the sign-in again")
+	ErrOAuthStateMismatch = errors.New("OAuth state does not match this sign-in")
+)
+
+// OAuthFlow is one Google consent round trip. It lives in a signed,
+// short-lived cookie between the redirect to Google and the callback, so
+// a callback can only complete a flow this browser started.
+type OAuthFlow struct {
+	State string `json:"state"`
+	// Verifier is the PKCE code_verifier; Google only sees its S256 hash.
+	Verifier string `json:"verifier"`
+	// Redirect is the frontend path to land on afterwards.
+	Redirect string `json:"redirect,omitempty"`
+	// UserID is the user logged in when the flow started (0 for none);
+	// the callback must see the same session.
+	UserID int `json:"uid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// NewOAuthFlow starts a flow with a fresh random state and PKCE verifier.
+func NewOAuthFlow(userID int, redirect string) (*OAuthFlow, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &OAuthFlow{
+		State:    hex.EncodeToString(b),
+		Verifier: oauth2.GenerateVerifier(),
+		Redirect: redirect,
+		UserID:   userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(oauthFlowTTL)),
+			Issuer:    "mcp-gmail-server",
+			Subject:   "oauth-flow",
+		},
+	}, nil
+}
+
+// AuthURL is the Google consent URL for this flow.
+func (f *OAuthFlow) AuthURL(conf *oauth2.Config) string {
+	return conf.AuthCodeURL(f.State,
+		oauth2.AccessTypeOffline,
+		oauth2.ApprovalForce,
+		oauth2.S256ChallengeOption(f.Verifier),
+	)
+}
+
+// Exchange trades the callback code for tokens, proving possession of the
+// PKCE verifier.
+func (f *OAuthFlow) Exchange(conf *oauth2.Config, code string) (*oauth2.Token, error) {
+	return conf.Exchange(context.Background(), code, oauth2.VerifierOption(f.Verifier))
+}
+
+// Encode signs the flow for the state cookie.
+func (f *OAuthFlow) Encode() (string, error) {
+	return signJWT(f)
+}
+
+// ParseOAuthFlow verifies the state cookie and checks it against the state
+// Google echoed back.
+func ParseOAuthFlow(cookieValue, state string) (*OAuthFlow, error) {
+	if cookieValue == "" {
+		return nil, ErrOAuthStateMissing
+	}
+
+	flow := &OAuthFlow{}
+	_, err := parseJWT(cookieValue, flow, jwt.WithExpirationRequired())
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrOAuthStateMissing
+		}
+		return nil, fmt.Errorf("%w: %v", ErrOAuthStateMismatch, err)
+	}
+	if flow.Subject != "oauth-flow" {
+		return nil, ErrOAuthStateMismatch
+	}
+
+	if state == "" || subtle.ConstantTimeCompare([]byte(flow.State), []byte(state)) != 1 {
+		return nil, ErrOAuthStateMismatch
+	}
+	return flow, nil
+}
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"sync"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -16,3 +18,17 @@ func CheckPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+var decoyHash struct {
+	sync.Once
+	hash []byte
+}
+
+// SpendPasswordCheck takes as long as checking a real password, so logins
+// for unknown or passwordless accounts can't be told apart by timing.
+func SpendPasswordCheck(password string) {
+	decoyHash.Do(func() {
+		decoyHash.hash, _ = bcrypt.GenerateFromPassword([]byte("decoy"), 14)
+	})
+	bcrypt.CompareHashAndPassword(decoyHash.hash, []byte(password))
+}
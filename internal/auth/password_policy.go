@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"mcp-gmail-server/internal/config"
+)
+
+// bcryptMaxBytes is where bcrypt stops reading: anything longer would be
+// silently truncated, so longer passwords are refused instead.
+const bcryptMaxBytes = 72
+
+// commonPasswords are refused whatever the configuration; PASSWORD_BANNED_FILE
+// adds more.
+var commonPasswords = []string{
+	"123456", "123456789", "12345678", "1234567890", "password", "password1",
+	"password123", "qwerty", "qwerty123", "qwertyuiop", "111111", "123123",
+	"abc123", "iloveyou", "admin", "admin123", "welcome", "welcome1",
+	"letmein", "monkey", "dragon", "football", "baseball", "sunshine",
+	"princess", "master", "shadow", "superman", "trustno1", "passw0rd",
+	"changeme", "000000", "654321", "1q2w3e4r", "1qaz2wsx", "zaq12wsx",
+	"asdfghjkl", "login", "starwars", "whatever", "secret", "p@ssw0rd",
+	"password12345", "qwerty12345", "mcpgmail", "gmail123",
+}
+
+// PasswordViolation is one broken rule, for the frontend to show.
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule a password breaks.
+type PasswordPolicyError struct {
+	Violations []PasswordViolation `json:"violations"`
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return "password rejected: " + strings.Join(messages, "; ")
+}
+
+// PasswordPolicy is the current policy, as shown at /auth/password-policy.
+type PasswordPolicy struct {
+	MinLength      int  `json:"min_length"`
+	MaxBytes       int  `json:"max_bytes"`
+	BreachChecking bool `json:"breach_checking"`
+
+	banned    map[string]bool
+	breachDir string
+}
+
+var passwordPolicy = &PasswordPolicy{MinLength: 10, MaxBytes: bcryptMaxBytes}
+
+// InitPasswordPolicy loads the policy from the configuration.
+// PASSWORD_BREACH_DIR names a directory of Pwned Passwords range files:
+// one file per 5 character SHA-1 prefix (ABCDE.txt, or just ABCDE) holding
+// "SUFFIX:COUNT" lines, as the haveibeenpwned downloader writes them. Only
+// the file for the password's prefix is read.
+func InitPasswordPolicy(cfg *config.Config) error {
+	policy := &PasswordPolicy{
+		MinLength: cfg.PasswordMinLength,
+		MaxBytes:  cfg.PasswordMaxBytes,
+		banned:    map[string]bool{},
+		breachDir: cfg.PasswordBreachDir,
+	}
+	if policy.MaxBytes <= 0 || policy.MaxBytes > bcryptMaxBytes {
+		policy.MaxBytes = bcryptMaxBytes
+	}
+	if policy.MinLength > policy.MaxBytes {
+		return fmt.Errorf("PASSWORD_MIN_LENGTH %d is above the maximum of %d bytes", policy.MinLength, policy.MaxBytes)
+	}
+
+	for _, p := range commonPasswords {
+		policy.banned[p] = true
+	}
+	if cfg.PasswordBannedFile != "" {
+		data, err := os.ReadFile(cfg.PasswordBannedFile)
+		if err != nil {
+			return fmt.Errorf("read banned passwords: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				policy.banned[strings.ToLower(line)] = true
+			}
+		}
+	}
+
+	if policy.breachDir != "" {
+		info, err := os.Stat(policy.breachDir)
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("PASSWORD_BREACH_DIR %q is not a directory", policy.breachDir)
+		}
+		policy.BreachChecking = true
+	}
+
+	passwordPolicy = policy
+	return nil
+}
+
+// GetPasswordPolicy returns the rules new passwords must follow.
+func GetPasswordPolicy() *PasswordPolicy {
+	return passwordPolicy
+}
+
+// CheckPassword returns a *PasswordPolicyError if password breaks the
+// policy for the account email.
+func CheckPassword(password, email string) error {
+	p := passwordPolicy
+	var violations []PasswordViolation
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PasswordViolation{"too_short", fmt.Sprintf("Use at least %d characters", p.MinLength)})
+	}
+	if len(password) > p.MaxBytes {
+		violations = append(violations, PasswordViolation{"too_long", fmt.Sprintf("Use at most %d bytes (fewer for non-ASCII characters)", p.MaxBytes)})
+	}
+
+	lower := strings.ToLower(password)
+	if p.banned[lower] || commonPattern(lower) {
+		violations = append(violations, PasswordViolation{"common", "This password is too common"})
+	}
+	if local, _, _ := strings.Cut(strings.ToLower(email), "@"); len(local) >= 3 && strings.Contains(lower, local) {
+		violations = append(violations, PasswordViolation{"contains_email", "Don't use your email address in your password"})
+	}
+
+	// Only worth a file read if nothing else is wrong
+	if len(violations) == 0 && p.breachDir != "" {
+		breached, err := p.breached(password)
+		if err != nil {
+			log.Printf("Breached password check failed: %v", err)
+		} else if breached {
+			violations = append(violations, PasswordViolation{"breached", "This password has appeared in a data breach, choose another"})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// commonPattern catches one character repeated, e.g. "aaaaaaaa".
+func commonPattern(password string) bool {
+	if password == "" {
+		return false
+	}
+	return strings.Count(password, password[:1]) == len(password)
+}
+
+// breached looks the password's SHA-1 up in its range file.
+func (p *PasswordPolicy) breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(p.breachDir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		f, err = os.Open(filepath.Join(p.breachDir, prefix))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		// Incomplete download; nothing known about this range
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Padded downloads add entries with a count of 0
+		line, count, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(line), suffix) && strings.TrimSpace(count) != "0" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
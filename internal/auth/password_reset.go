@@ -3,18 +3,24 @@ package auth
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"mcp-gmail-server/internal/config"
 	"mcp-gmail-server/internal/db"
-	"mcp-gmail-server/internal/gmail"
-
-	"golang.org/x/oauth2"
 )
 
-// GenerateResetToken creates a secure random token and stores it in the DB
+// resetTokenTTL is how long a reset link stays valid.
+const resetTokenTTL = time.Hour
+
+// ErrResetTokenInvalid covers unknown, replaced and expired tokens alike.
+var ErrResetTokenInvalid = errors.New("invalid or expired reset token")
+
+// GenerateResetToken creates a secure random token for the user with email.
+// Only its hash is stored, and it replaces any earlier token: a user has at
+// most one working reset link.
 func GenerateResetToken(email string) (string, error) {
 	// check if user exists
 	user, err := GetUserFromDB(email)
@@ -28,14 +34,14 @@ func GenerateResetToken(email string) (string, error) {
 	}
 	token := hex.EncodeToString(bytes)
 
-	// expiry 1 hour
-	expiry := time.Now().Add(1 * time.Hour)
-
 	_, err = db.DB.Exec(`
-		INSERT INTO password_resets (user_id, token, expires_at)
+		INSERT INTO password_resets (user_id, token_hash, expires_at)
 		VALUES (?, ?, ?)
-	`, user.ID, token, expiry)
-
+		ON DUPLICATE KEY UPDATE
+			token_hash = VALUES(token_hash),
+			expires_at = VALUES(expires_at),
+			created_at = CURRENT_TIMESTAMP
+	`, user.ID, hashAPIKey(token), time.Now().Add(resetTokenTTL))
 	if err != nil {
 		return "", err
 	}
@@ -49,29 +55,37 @@ func ValidateResetToken(token string) (int, error) {
 	var expiresAt time.Time
 
 	err := db.DB.QueryRow(`
-		SELECT user_id, expires_at 
-		FROM password_resets 
-		WHERE token = ?
-	`, token).Scan(&userID, &expiresAt)
-
+		SELECT user_id, expires_at
+		FROM password_resets
+		WHERE token_hash = ?
+	`, hashAPIKey(token)).Scan(&userID, &expiresAt)
 	if err != nil {
-		return 0, fmt.Errorf("invalid token")
+		return 0, ErrResetTokenInvalid
 	}
 
 	if time.Now().After(expiresAt) {
-		return 0, fmt.Errorf("token expired")
+		return 0, ErrResetTokenInvalid
 	}
 
 	return userID, nil
 }
 
-// ResetPassword updates the user's password and invalidates the token
+// ResetPassword updates the user's password, invalidates the token and
+// every session, and tells the user their password changed.
 func ResetPassword(token, newPassword string) error {
 	userID, err := ValidateResetToken(token)
 	if err != nil {
 		return err
 	}
 
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if err := CheckPassword(newPassword, user.Email); err != nil {
+		return err
+	}
+
 	hash, err := HashPassword(newPassword)
 	if err != nil {
 		return err
@@ -90,75 +104,61 @@ func ResetPassword(token, newPassword string) error {
 		return err
 	}
 
-	// Delete used token (and potentially all tokens for this user to be safe, or just this one)
-	// Let's delete just this one for now, or all to prevent replay if we had multiple.
-	// Safest is delete used token.
-	_, err = tx.Exec("DELETE FROM password_resets WHERE token = ?", token)
+	// Delete the used token. Only the token still stored can succeed, so a
+	// concurrent reset with the same token finds nothing to delete.
+	res, err := tx.Exec("DELETE FROM password_resets WHERE user_id = ? AND token_hash = ?", userID, hashAPIKey(token))
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
-
-	return tx.Commit()
-}
-
-// SendResetEmail sends the reset token via Gmail API using the System Account
-func SendResetEmail(recipient, token string) {
-	// 1. Load Config
-	cfg := config.LoadConfig()
-
-	// Construct link (assuming AllowedOrigin doesn't have trailing slash, or logic handles it)
-	resetLink := fmt.Sprintf("%s/reset-password?token=%s", cfg.AllowedOrigin, token)
-
-	// 2. Log to console regardless (for dev/backup)
-	log.Printf("---------------------------------------------------------")
-	log.Printf("PASSWORD RESET REQUEST FOR: %s", recipient)
-	log.Printf("Reset Link: %s", resetLink)
-	log.Printf("Token: %s", token)
-	log.Printf("---------------------------------------------------------")
-
-	if cfg.SystemEmail == "" {
-		log.Println("Note: SYSTEM_EMAIL not set, skipping Gmail API send.")
-		return
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return ErrResetTokenInvalid
 	}
 
-	// 3. Get System User Credentials
-	user, err := GetUserFromDB(cfg.SystemEmail)
+	// Whoever knew the old password may still be signed in
+	_, err = tx.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", time.Now(), userID)
 	if err != nil {
-		log.Printf("Error: System user '%s' not found in DB: %v. Cannot send email.", cfg.SystemEmail, err)
-		return
+		tx.Rollback()
+		return err
 	}
 
-	if user.AccessToken == "" || user.RefreshToken == "" {
-		log.Printf("Error: System user '%s' has no valid tokens. Cannot send email.", cfg.SystemEmail)
-		return
+	if err := tx.Commit(); err != nil {
+		return err
 	}
 
-	// 4. Build Service
-	// Since we are in 'auth' package, we can use BuildOAuthConfig(user) which is in oauth_builder.go
-	oauthConfig := BuildOAuthConfig(user)
-
-	oauthToken := &oauth2.Token{
-		AccessToken:  user.AccessToken,
-		RefreshToken: user.RefreshToken,
-		Expiry:       user.Expiry,
-		TokenType:    "Bearer",
+	// The owner can now sign in again, even if guessing had locked the account
+	if err := UnlockLogin(user.Email); err != nil {
+		log.Printf("Failed to clear login lockout for user %d: %v", user.ID, err)
 	}
+	sendPasswordChangedEmail(user)
+	return nil
+}
 
-	srv, err := gmail.NewGmailService(oauthConfig, oauthToken)
-	if err != nil {
-		log.Printf("Error creating Gmail service: %v", err)
-		return
-	}
+// SendResetEmail queues the reset link for delivery from the System Account.
+// The token is a credential, so it never goes to the log.
+func SendResetEmail(recipient, token string) {
+	cfg := config.LoadConfig()
+
+	// Construct link (assuming AllowedOrigin doesn't have trailing slash, or logic handles it)
+	resetLink := fmt.Sprintf("%s/reset-password?token=%s", cfg.AllowedOrigin, token)
 
-	// 5. Send Email
+	// Queue it; the send queue worker delivers and retries
 	subject := "Password Reset Request"
-	body := fmt.Sprintf("Hello,\n\nYou requested a password reset. Please click the link below to set a new password:\n\n%s\n\nOr verify this token manually:\n%s\n\nThis link expires in 1 hour.", resetLink, token)
+	body := fmt.Sprintf("Hello,\n\nYou requested a password reset. Please click the link below to set a new password:\n\n%s\n\nThis link expires in 1 hour and stops working if you request another one. If you didn't ask for it, you can ignore this email.", resetLink)
 
-	err = gmail.SendEmail(srv, recipient, subject, body)
-	if err != nil {
-		log.Printf("Error sending email via Gmail API: %v", err)
+	if err := queueSystemEmail(recipient, subject, body, resetTokenTTL); err != nil {
+		log.Printf("Error queueing reset email: %v", err)
 	} else {
-		log.Printf("Reset email sent successfully to %s via %s", recipient, cfg.SystemEmail)
+		log.Printf("Reset email queued via %s", cfg.SystemEmail)
+	}
+}
+
+// sendPasswordChangedEmail tells a user their password was reset, so a
+// reset they didn't ask for doesn't go unnoticed.
+func sendPasswordChangedEmail(user *User) {
+	body := "Hello,\n\nThe password for your account was just reset, and every device signed in to it was signed out.\n\nIf this wasn't you, reset your password again right away and contact your administrator."
+	if err := queueSystemEmail(user.Email, "Your password was changed", body, 0); err != nil {
+		log.Printf("Error queueing password change notice for user %d: %v", user.ID, err)
 	}
 }
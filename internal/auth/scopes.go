@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scopes limit what an API key may do. Sessions and Google access tokens
+// carry every scope their user's role allows.
+const (
+	// ScopeSearch runs natural-language searches (/mcp/search).
+	ScopeSearch = "search"
+	// ScopeRead lists mailboxes, labels, drafts and scheduled mail.
+	ScopeRead = "read"
+	// ScopeSend sends, replies, forwards, schedules and writes drafts.
+	ScopeSend = "send"
+	// ScopeModify changes labels, archives and trashes, and manages
+	// connected mailboxes and OAuth settings.
+	ScopeModify = "modify"
+	// ScopeAdmin reaches the /admin API and, with ScopeSearch, delegated
+	// Workspace mailboxes; only effective for admins.
+	ScopeAdmin = "admin"
+)
+
+// AllScopes lists every scope in display order.
+var AllScopes = []string{ScopeSearch, ScopeRead, ScopeSend, ScopeModify, ScopeAdmin}
+
+// userScopes are granted to sessions, and to API keys created before keys
+// had scopes.
+var userScopes = []string{ScopeSearch, ScopeRead, ScopeSend, ScopeModify}
+
+// ValidateScopes checks requested scopes against the user's role and
+// returns them deduplicated in canonical order.
+func ValidateScopes(user *User, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+
+	want := make(map[string]bool, len(requested))
+	for _, s := range requested {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if !isScope(s) {
+			return nil, fmt.Errorf("unknown scope %q", s)
+		}
+		if s == ScopeAdmin && user.Role != "admin" {
+			return nil, fmt.Errorf("only admins may grant the admin scope")
+		}
+		want[s] = true
+	}
+
+	var scopes []string
+	for _, s := range AllScopes {
+		if want[s] {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes, nil
+}
+
+func isScope(s string) bool {
+	for _, known := range AllScopes {
+		if s == known {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionScopes are the scopes of a caller that isn't restricted by a key.
+func sessionScopes(user *User) []string {
+	if user.Role == "admin" {
+		return AllScopes
+	}
+	return userScopes
+}
+
+// Can reports whether the caller holds scope. The admin scope also
+// requires the admin role, in case a key outlived its owner's promotion,
+// and search requires a verified email address.
+func (p *Principal) Can(scope string) bool {
+	if scope == "" {
+		return true
+	}
+	if scope == ScopeAdmin && p.User.Role != "admin" {
+		return false
+	}
+	if scope == ScopeSearch && !p.User.EmailVerified {
+		return false
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func joinScopes(scopes []string) string {
+	return strings.Join(scopes, ",")
+}
+
+func splitScopes(s string) []string {
+	var scopes []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			scopes = append(scopes, part)
+		}
+	}
+	return scopes
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"mcp-gmail-server/internal/db"
+)
+
+// A session ends after SessionIdleTimeout without requests (each request
+// slides the deadline) and after SessionMaxAge regardless.
+const (
+	SessionIdleTimeout = 24 * time.Hour
+	SessionMaxAge      = 30 * 24 * time.Hour
+
+	// sessionTouchInterval limits last-seen writes to one a minute.
+	sessionTouchInterval = time.Minute
+)
+
+// Session is a signed-in browser or device. Its ID is the "jti" of the
+// session JWT.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current marks the session making the request in listings.
+	Current bool `json:"current"`
+
+	revoked bool
+}
+
+const sessionColumns = `
+	id, user_id, user_agent, ip, created_at, last_seen_at, expires_at,
+	revoked_at IS NOT NULL
+`
+
+func scanSession(row rowScanner) (*Session, error) {
+	var s Session
+	var userAgent, ip sql.NullString
+
+	err := row.Scan(
+		&s.ID,
+		&s.UserID,
+		&userAgent,
+		&ip,
+		&s.CreatedAt,
+		&s.LastSeenAt,
+		&s.ExpiresAt,
+		&s.revoked,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.UserAgent = userAgent.String
+	s.IP = ip.String
+	return &s, nil
+}
+
+// StartSession records a new session for user and returns the signed token
+// for the auth_token cookie.
+func StartSession(user *User, r *http.Request) (string, *Session, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	s := &Session{
+		ID:         hex.EncodeToString(b),
+		UserID:     user.ID,
+		UserAgent:  truncate(r.UserAgent(), 255),
+		IP:         ClientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(SessionIdleTimeout),
+	}
+
+	// Forget the user's dead sessions while we're here
+	_, err := db.DB.Exec(`
+		DELETE FROM sessions
+		WHERE user_id = ? AND (expires_at < ? OR revoked_at IS NOT NULL)
+	`, user.ID, now)
+	if err != nil {
+		return "", nil, err
+	}
+
+	_, err = db.DB.Exec(`
+		INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, s.ID, s.UserID, s.UserAgent, s.IP, s.CreatedAt, s.LastSeenAt, s.ExpiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := GenerateToken(user.ID, user.Email, s.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, s, nil
+}
+
+// checkSession loads a live session and slides its idle deadline.
+func checkSession(id string, r *http.Request) (*Session, error) {
+	if id == "" {
+		// Issued before sessions were stored
+		return nil, ErrSessionRevoked
+	}
+
+	s, err := scanSession(db.DB.QueryRow(`
+		SELECT `+sessionColumns+` FROM sessions WHERE id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if s.revoked || !now.Before(s.ExpiresAt) || !now.Before(s.CreatedAt.Add(SessionMaxAge)) {
+		return nil, ErrSessionRevoked
+	}
+
+	ip := ClientIP(r)
+	if now.Sub(s.LastSeenAt) >= sessionTouchInterval || s.IP != ip {
+		s.LastSeenAt = now
+		s.ExpiresAt = now.Add(SessionIdleTimeout)
+		s.IP = ip
+		_, err := db.DB.Exec(`
+			UPDATE sessions SET last_seen_at = ?, expires_at = ?, ip = ?
+			WHERE id = ? AND revoked_at IS NULL
+		`, s.LastSeenAt, s.ExpiresAt, s.IP, s.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// ListSessions returns a user's live sessions, most recently used first.
+func ListSessions(userID int) ([]*Session, error) {
+	rows, err := db.DB.Query(`
+		SELECT `+sessionColumns+`
+		FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ? AND created_at > ?
+		ORDER BY last_seen_at DESC
+	`, userID, time.Now(), time.Now().Add(-SessionMaxAge))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession ends one of the user's sessions. Sessions owned by someone
+// else are reported as sql.ErrNoRows.
+func RevokeSession(userID int, id string) error {
+	res, err := db.DB.Exec(`
+		UPDATE sessions SET revoked_at = ?
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`, time.Now(), id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeSessions ends all of a user's sessions except the one with ID
+// except (pass "" to end them all) and reports how many were ended.
+func RevokeSessions(userID int, except string) (int64, error) {
+	res, err := db.DB.Exec(`
+		UPDATE sessions SET revoked_at = ?
+		WHERE user_id = ? AND id != ? AND revoked_at IS NULL
+	`, time.Now(), userID, except)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// EndSession revokes the session behind the request's auth_token cookie,
+// if any. Used by logout.
+func EndSession(r *http.Request) error {
+	cookie, err := r.Cookie("auth_token")
+	if err != nil {
+		return nil
+	}
+	claims, err := ValidateToken(cookie.Value)
+	if err != nil || claims.ID == "" {
+		return nil
+	}
+
+	err = RevokeSession(claims.UserID, claims.ID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	return err
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
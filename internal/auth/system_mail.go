@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"mcp-gmail-server/internal/config"
+	"mcp-gmail-server/internal/gmail"
+	"mcp-gmail-server/internal/sendqueue"
+)
+
+// ErrNoSystemMailer means SYSTEM_EMAIL is unset or has no usable mailbox.
+var ErrNoSystemMailer = errors.New("system mailer is not configured")
+
+// queueSystemEmail queues a message from the System Account (SYSTEM_EMAIL)
+// for the send queue worker, which delivers and retries it. Mail carrying
+// a link passes the link's lifetime as ttl so it isn't kept, or sent,
+// after the link is dead; 0 keeps trying.
+func queueSystemEmail(recipient, subject, body string, ttl time.Duration) error {
+	cfg := config.LoadConfig()
+	if cfg.SystemEmail == "" {
+		return fmt.Errorf("%w: SYSTEM_EMAIL not set", ErrNoSystemMailer)
+	}
+
+	user, err := GetUserFromDB(cfg.SystemEmail)
+	if err != nil {
+		return fmt.Errorf("%w: system user %q not found: %v", ErrNoSystemMailer, cfg.SystemEmail, err)
+	}
+
+	account, err := PrimaryAccount(user.ID)
+	if err != nil || account.RefreshToken == "" {
+		return fmt.Errorf("%w: system user %q has no connected Gmail account", ErrNoSystemMailer, cfg.SystemEmail)
+	}
+
+	msg := &gmail.OutgoingMessage{To: recipient, Subject: subject, Body: body}
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	_, err = sendqueue.EnqueueSystem(user.ID, account.ID, msg, expiresAt)
+	return err
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"mcp-gmail-server/internal/db"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrNeedsReconnect is returned for mailboxes whose refresh token Google
+// has revoked or expired; the user must connect them again.
+var ErrNeedsReconnect = errors.New("mailbox access was revoked, reconnect it")
+
+// persistingTokenSource writes refreshed tokens back to the account row, so
+// the next request reuses them and rotated refresh tokens aren't lost.
+type persistingTokenSource struct {
+	accountID int
+	base      oauth2.TokenSource
+
+	mu   sync.Mutex
+	last string
+}
+
+func newPersistingTokenSource(accountID int, conf *oauth2.Config, token *oauth2.Token) oauth2.TokenSource {
+	return &persistingTokenSource{
+		accountID: accountID,
+		base:      conf.TokenSource(context.Background(), token),
+		last:      token.AccessToken,
+	}
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.base.Token()
+	if err != nil {
+		if isInvalidGrant(err) {
+			markNeedsReconnect(s.accountID, err)
+			return nil, ErrNeedsReconnect
+		}
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token.AccessToken != s.last {
+		if err := saveRefreshedToken(s.accountID, token); err != nil {
+			// The token is still good for this request
+			log.Printf("Failed to persist refreshed token for account %d: %v", s.accountID, err)
+		} else {
+			s.last = token.AccessToken
+		}
+	}
+	return token, nil
+}
+
+func isInvalidGrant(err error) bool {
+	var rerr *oauth2.RetrieveError
+	return errors.As(err, &rerr) && rerr.ErrorCode == "invalid_grant"
+}
+
+// saveRefreshedToken stores a refreshed token in one statement. A token
+// older than the stored one (a concurrent refresh finished first) is
+// ignored, and an empty refresh token keeps the existing one.
+func saveRefreshedToken(accountID int, token *oauth2.Token) error {
+	box, err := rowBox("mailbox_accounts", accountID)
+	if err != nil {
+		return err
+	}
+	accessToken, err := box.Seal("access_token", token.AccessToken)
+	if err != nil {
+		return err
+	}
+	refreshToken, err := box.Seal("refresh_token", token.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.DB.Exec(`
+		UPDATE mailbox_accounts
+		SET access_token = ?,
+			refresh_token = IF(? = '', refresh_token, ?),
+			expiry = ?,
+			needs_reconnect = FALSE,
+			token_error = NULL
+		WHERE id = ? AND (expiry IS NULL OR expiry <= ?)
+	`, accessToken, refreshToken, refreshToken, token.Expiry, accountID, token.Expiry)
+	return err
+}
+
+func markNeedsReconnect(accountID int, cause error) {
+	log.Printf("Account %d needs reconnecting: %v", accountID, cause)
+
+	_, err := db.DB.Exec(`
+		UPDATE mailbox_accounts SET needs_reconnect = TRUE, token_error = ? WHERE id = ?
+	`, cause.Error(), accountID)
+	if err != nil {
+		log.Printf("Failed to flag account %d for reconnect: %v", accountID, err)
+	}
+}
@@ -2,7 +2,10 @@ package auth
 
 import (
 	"database/sql"
+	"fmt"
+
 	"mcp-gmail-server/internal/db"
+	"mcp-gmail-server/internal/secrets"
 
 	"golang.org/x/oauth2"
 )
@@ -24,20 +27,25 @@ func SaveUser(email string, token *oauth2.Token) error {
 	return err
 }
 
-func GetUserFromDB(email string) (*User, error) {
-	var passwordHash sql.NullString
+const userColumns = `
+	id, email, role,
+	google_client_id, google_client_secret,
+	access_token, refresh_token, expiry,
+	password_hash, data_key, active, mfa_enabled, email_verified
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	var passwordHash, dataKey sql.NullString
 	var user User
 	var clientID, clientSecret, accessToken, refreshToken sql.NullString
 	var expiry sql.NullTime
+	var active sql.NullBool
 
-	err := db.DB.QueryRow(`
-        SELECT id, email, role,
-               google_client_id, google_client_secret,
-               access_token, refresh_token, expiry,
-               password_hash
-        FROM users
-        WHERE email = ?
-    `, email).Scan(
+	err := row.Scan(
 		&user.ID,
 		&user.Email,
 		&user.Role,
@@ -47,6 +55,10 @@ func GetUserFromDB(email string) (*User, error) {
 		&refreshToken,
 		&expiry,
 		&passwordHash,
+		&dataKey,
+		&active,
+		&user.MFAEnabled,
+		&user.EmailVerified,
 	)
 
 	if err != nil {
@@ -57,7 +69,13 @@ func GetUserFromDB(email string) (*User, error) {
 		user.GoogleClientID = clientID.String
 	}
 	if clientSecret.Valid {
-		user.GoogleClientSecret = clientSecret.String
+		box, err := secrets.OpenBox(dataKey.String)
+		if err != nil {
+			return nil, fmt.Errorf("user %d: %w", user.ID, err)
+		}
+		if user.GoogleClientSecret, err = box.Open("google_client_secret", clientSecret.String); err != nil {
+			return nil, fmt.Errorf("user %d: %w", user.ID, err)
+		}
 	}
 	if accessToken.Valid {
 		user.AccessToken = accessToken.String
@@ -71,14 +89,25 @@ func GetUserFromDB(email string) (*User, error) {
 	if passwordHash.Valid {
 		user.PasswordHash = passwordHash.String
 	}
+	user.Active = !active.Valid || active.Bool
 
 	return &user, nil
 }
 
+func GetUserFromDB(email string) (*User, error) {
+	return scanUser(db.DB.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = ?`, email))
+}
+
+func GetUserByID(id int) (*User, error) {
+	return scanUser(db.DB.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// CreateUser adds a password user. Their email address stays unverified
+// until they follow the link from SendVerificationEmail.
 func CreateUser(email string, passwordHash string) error {
 	_, err := db.DB.Exec(`
-		INSERT INTO users (email, password_hash)
-		VALUES (?, ?)
+		INSERT INTO users (email, password_hash, email_verified)
+		VALUES (?, ?, FALSE)
 	`, email, passwordHash)
 	return err
 }
@@ -2,12 +2,18 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
+// DevJWTSecret signs sessions when JWT_SECRET is unset. It is refused in
+// production.
+const DevJWTSecret = "very-secret-key-change-me"
+
 type Config struct {
 	ClientID      string
 	ClientSecret  string
@@ -17,6 +23,56 @@ type Config struct {
 	JWTSecret     string
 	AllowedOrigin string
 	SystemEmail   string
+
+	// Production is set by APP_ENV or RAILWAY_ENVIRONMENT=production
+	Production bool
+
+	// JWT keyring (see internal/auth/keyring.go); JWTSecret is the fallback
+	JWTSigningKeys     string
+	JWTSigningKeysFile string
+	// How long tokens signed by a replaced key stay valid
+	JWTKeyOverlap time.Duration
+
+	// Background mailbox sync (see internal/mailsync)
+	SyncEnabled       bool
+	SyncInterval      time.Duration
+	SyncBackfillLimit int
+
+	// Gmail push notifications via Pub/Sub (users.watch)
+	PubSubTopic        string
+	PushAudience       string
+	PushServiceAccount string
+	PushJWKSURL        string
+
+	// Request gmail.modify for label/archive/trash operations
+	ModifyEnabled bool
+	// Request gmail.compose for draft management
+	ComposeEnabled bool
+
+	// How often the send queue looks for due messages
+	SendQueueInterval time.Duration
+
+	// Workspace domain-wide delegation: service account key (inline JSON or path)
+	ServiceAccountKey string
+
+	// Keys used to verify ID tokens from the OAuth callback
+	GoogleJWKSURL string
+	// Endpoint used to verify Google access tokens sent as bearer credentials
+	GoogleTokenInfoURL string
+
+	// Login throttling: "memory" (one instance) or "db" (shared)
+	ThrottleStore string
+	// Failed logins that lock an account, and for how long
+	LoginLockoutThreshold int
+	LoginLockoutDuration  time.Duration
+	// Proxies in front of the server that append to X-Forwarded-For
+	TrustedProxyHops int
+
+	// Password policy (see internal/auth/password_policy.go)
+	PasswordMinLength  int
+	PasswordMaxBytes   int
+	PasswordBannedFile string
+	PasswordBreachDir  string
 }
 
 func LoadConfig() *Config {
@@ -26,15 +82,91 @@ func LoadConfig() *Config {
 
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
-		// Fallback for dev only, or panic in prod
-		jwtSecret = "very-secret-key-change-me"
+		// Fallback for dev only, auth.InitJWT refuses it in production
+		jwtSecret = DevJWTSecret
 	}
 
+	production := os.Getenv("APP_ENV") == "production" || os.Getenv("RAILWAY_ENVIRONMENT") == "production"
+
 	allowedOrigin := os.Getenv("ALLOWED_ORIGIN")
 	if allowedOrigin == "" {
 		allowedOrigin = "http://localhost:3000"
 	}
 
+	syncInterval, err := time.ParseDuration(os.Getenv("MAIL_SYNC_INTERVAL"))
+	if err != nil || syncInterval <= 0 {
+		syncInterval = 5 * time.Minute
+	}
+
+	syncBackfillLimit, err := strconv.Atoi(os.Getenv("MAIL_SYNC_BACKFILL_LIMIT"))
+	if err != nil || syncBackfillLimit <= 0 {
+		syncBackfillLimit = 500
+	}
+
+	sendQueueInterval, err := time.ParseDuration(os.Getenv("SEND_QUEUE_INTERVAL"))
+	if err != nil || sendQueueInterval <= 0 {
+		sendQueueInterval = 30 * time.Second
+	}
+
+	// Defaults to the longest a session can live
+	jwtKeyOverlap, err := time.ParseDuration(os.Getenv("JWT_KEY_OVERLAP"))
+	if err != nil || jwtKeyOverlap <= 0 {
+		jwtKeyOverlap = 30 * 24 * time.Hour
+	}
+
+	// Overridable so push deliveries can be signed by a local test key
+	pushJWKSURL := os.Getenv("PUBSUB_PUSH_JWKS_URL")
+	if pushJWKSURL == "" {
+		pushJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+	}
+
+	// Same for ID tokens, so the login flow can run against a stub issuer
+	googleJWKSURL := os.Getenv("GOOGLE_OIDC_JWKS_URL")
+	if googleJWKSURL == "" {
+		googleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+	}
+
+	googleTokenInfoURL := os.Getenv("GOOGLE_TOKENINFO_URL")
+	if googleTokenInfoURL == "" {
+		googleTokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+	}
+
+	throttleStore := os.Getenv("THROTTLE_STORE")
+	if throttleStore == "" {
+		throttleStore = "memory"
+	}
+
+	loginLockoutThreshold, err := strconv.Atoi(os.Getenv("LOGIN_LOCKOUT_THRESHOLD"))
+	if err != nil || loginLockoutThreshold <= 0 {
+		loginLockoutThreshold = 10
+	}
+
+	loginLockoutDuration, err := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT_DURATION"))
+	if err != nil || loginLockoutDuration <= 0 {
+		loginLockoutDuration = 15 * time.Minute
+	}
+
+	// Railway's edge proxy appends the client address; elsewhere the
+	// header is only trusted when configured
+	trustedProxyHops, err := strconv.Atoi(os.Getenv("TRUSTED_PROXY_HOPS"))
+	if err != nil || trustedProxyHops < 0 {
+		trustedProxyHops = 0
+		if os.Getenv("RAILWAY_ENVIRONMENT") != "" {
+			trustedProxyHops = 1
+		}
+	}
+
+	passwordMinLength, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH"))
+	if err != nil || passwordMinLength <= 0 {
+		passwordMinLength = 10
+	}
+
+	// bcrypt ignores everything past 72 bytes
+	passwordMaxBytes, err := strconv.Atoi(os.Getenv("PASSWORD_MAX_LENGTH"))
+	if err != nil || passwordMaxBytes <= 0 || passwordMaxBytes > 72 {
+		passwordMaxBytes = 72
+	}
+
 	return &Config{
 		ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
 		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
@@ -48,5 +180,40 @@ func LoadConfig() *Config {
 		JWTSecret:     jwtSecret,
 		AllowedOrigin: allowedOrigin,
 		SystemEmail:   os.Getenv("SYSTEM_EMAIL"),
+
+		Production: production,
+
+		JWTSigningKeys:     os.Getenv("JWT_SIGNING_KEYS"),
+		JWTSigningKeysFile: os.Getenv("JWT_SIGNING_KEYS_FILE"),
+		JWTKeyOverlap:      jwtKeyOverlap,
+
+		SyncEnabled:       os.Getenv("MAIL_SYNC_ENABLED") == "true",
+		SyncInterval:      syncInterval,
+		SyncBackfillLimit: syncBackfillLimit,
+
+		PubSubTopic:        os.Getenv("GMAIL_PUBSUB_TOPIC"),
+		PushAudience:       os.Getenv("PUBSUB_PUSH_AUDIENCE"),
+		PushServiceAccount: os.Getenv("PUBSUB_PUSH_SERVICE_ACCOUNT"),
+		PushJWKSURL:        pushJWKSURL,
+
+		ModifyEnabled:  os.Getenv("GMAIL_MODIFY_ENABLED") == "true",
+		ComposeEnabled: os.Getenv("GMAIL_COMPOSE_ENABLED") == "true",
+
+		SendQueueInterval: sendQueueInterval,
+
+		ServiceAccountKey: os.Getenv("GOOGLE_SERVICE_ACCOUNT_KEY"),
+
+		GoogleJWKSURL:      googleJWKSURL,
+		GoogleTokenInfoURL: googleTokenInfoURL,
+
+		ThrottleStore:         throttleStore,
+		LoginLockoutThreshold: loginLockoutThreshold,
+		LoginLockoutDuration:  loginLockoutDuration,
+		TrustedProxyHops:      trustedProxyHops,
+
+		PasswordMinLength:  passwordMinLength,
+		PasswordMaxBytes:   passwordMaxBytes,
+		PasswordBannedFile: os.Getenv("PASSWORD_BANNED_FILE"),
+		PasswordBreachDir:  os.Getenv("PASSWORD_BREACH_DIR"),
 	}
 }
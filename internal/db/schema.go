@@ -27,6 +27,7 @@ func CreateTables() {
 			id INT AUTO_INCREMENT PRIMARY KEY,
 			user_id INT NOT NULL,
 			key_hash VARCHAR(255) NOT NULL,
+			active BOOLEAN DEFAULT TRUE,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 		);`,
@@ -38,6 +39,263 @@ func CreateTables() {
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 		);`,
+		// Local mailbox mirror maintained by the sync worker (internal/mailsync)
+		`CREATE TABLE IF NOT EXISTS sync_state (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			email_address VARCHAR(255),
+			history_id BIGINT UNSIGNED,
+			status VARCHAR(32) DEFAULT 'pending',
+			last_error TEXT,
+			last_synced_at DATETIME,
+			UNIQUE KEY uniq_sync_user (user_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS mail_messages (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			gmail_id VARCHAR(64) NOT NULL,
+			thread_id VARCHAR(64),
+			from_addr TEXT,
+			to_addr TEXT,
+			subject TEXT,
+			date_header VARCHAR(255),
+			snippet TEXT,
+			body MEDIUMTEXT,
+			label_ids TEXT,
+			internal_date BIGINT,
+			history_id BIGINT UNSIGNED,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			UNIQUE KEY uniq_user_message (user_id, gmail_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		`ALTER TABLE sync_state ADD COLUMN watch_expiration DATETIME;`,
+		`ALTER TABLE mail_messages ADD COLUMN attachment_names TEXT;`,
+		`ALTER TABLE mail_messages ADD COLUMN attachment_text MEDIUMTEXT;`,
+		// Embedded chunks of mirrored messages for semantic search (internal/vector)
+		`CREATE TABLE IF NOT EXISTS message_chunks (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			gmail_id VARCHAR(64) NOT NULL,
+			chunk_index INT NOT NULL,
+			content TEXT,
+			model VARCHAR(128) NOT NULL,
+			embedding MEDIUMBLOB NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uniq_chunk (user_id, gmail_id, chunk_index),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		// A user can connect several Gmail mailboxes
+		`CREATE TABLE IF NOT EXISTS mailbox_accounts (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			email_address VARCHAR(255) NOT NULL,
+			label VARCHAR(100),
+			access_token TEXT,
+			refresh_token TEXT,
+			expiry DATETIME,
+			is_primary BOOLEAN DEFAULT FALSE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uniq_user_mailbox (user_id, email_address),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		// Move tokens from the single-mailbox columns on users into accounts.
+		// The Gmail address was only known to the sync worker, so fall back to
+		// the login email. The users columns are cleared so a removed account
+		// is not resurrected on the next start.
+		`INSERT IGNORE INTO mailbox_accounts (user_id, email_address, access_token, refresh_token, expiry, is_primary)
+			SELECT u.id, COALESCE(s.email_address, u.email), u.access_token, u.refresh_token, u.expiry, TRUE
+			FROM users u
+			LEFT JOIN sync_state s ON s.user_id = u.id
+			WHERE u.refresh_token IS NOT NULL AND u.refresh_token != '';`,
+		`UPDATE users SET access_token = NULL, refresh_token = NULL, expiry = NULL
+			WHERE id IN (SELECT user_id FROM mailbox_accounts);`,
+		// The mirror is now per mailbox rather than per user
+		`ALTER TABLE sync_state ADD COLUMN account_id INT;`,
+		`ALTER TABLE mail_messages ADD COLUMN account_id INT;`,
+		`ALTER TABLE message_chunks ADD COLUMN account_id INT;`,
+		`UPDATE sync_state s JOIN mailbox_accounts a ON a.user_id = s.user_id AND a.is_primary
+			SET s.account_id = a.id WHERE s.account_id IS NULL;`,
+		`UPDATE mail_messages m JOIN mailbox_accounts a ON a.user_id = m.user_id AND a.is_primary
+			SET m.account_id = a.id WHERE m.account_id IS NULL;`,
+		`UPDATE message_chunks c JOIN mailbox_accounts a ON a.user_id = c.user_id AND a.is_primary
+			SET c.account_id = a.id WHERE c.account_id IS NULL;`,
+		`DELETE FROM sync_state WHERE account_id IS NULL;`,
+		`DELETE FROM mail_messages WHERE account_id IS NULL;`,
+		`DELETE FROM message_chunks WHERE account_id IS NULL;`,
+		// Keep an index on user_id for the foreign keys before dropping the
+		// old per-user unique keys
+		`ALTER TABLE sync_state ADD KEY idx_sync_user (user_id);`,
+		`ALTER TABLE mail_messages ADD KEY idx_message_user (user_id);`,
+		`ALTER TABLE message_chunks ADD KEY idx_chunk_user (user_id);`,
+		`ALTER TABLE sync_state DROP INDEX uniq_sync_user;`,
+		`ALTER TABLE mail_messages DROP INDEX uniq_user_message;`,
+		`ALTER TABLE message_chunks DROP INDEX uniq_chunk;`,
+		`ALTER TABLE sync_state ADD UNIQUE KEY uniq_sync_account (account_id);`,
+		`ALTER TABLE mail_messages ADD UNIQUE KEY uniq_account_message (account_id, gmail_id);`,
+		`ALTER TABLE message_chunks ADD UNIQUE KEY uniq_account_chunk (account_id, gmail_id, chunk_index);`,
+		// Set when Google rejects the refresh token (invalid_grant)
+		`ALTER TABLE mailbox_accounts ADD COLUMN needs_reconnect BOOLEAN NOT NULL DEFAULT FALSE;`,
+		`ALTER TABLE mailbox_accounts ADD COLUMN token_error TEXT;`,
+		// Wrapped per-row data keys for credential encryption (see internal/secrets)
+		`ALTER TABLE users ADD COLUMN data_key VARCHAR(255);`,
+		`ALTER TABLE mailbox_accounts ADD COLUMN data_key VARCHAR(255);`,
+		// Workspace domain-wide delegation: mailboxes admins may open through
+		// the service account, and a record of every such access
+		`CREATE TABLE IF NOT EXISTS delegated_mailboxes (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			email_address VARCHAR(255) UNIQUE NOT NULL,
+			note VARCHAR(255),
+			added_by INT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (added_by) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS delegation_audit (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT,
+			mailbox VARCHAR(255) NOT NULL,
+			action VARCHAR(64) NOT NULL,
+			allowed BOOLEAN NOT NULL,
+			detail TEXT,
+			ip VARCHAR(64),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			KEY idx_audit_mailbox (mailbox),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+		// Drafts created through the API, attributed to the requesting
+		// user and API key (NULL for cookie sessions)
+		`CREATE TABLE IF NOT EXISTS drafts (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			account_id INT NOT NULL,
+			api_key_id INT,
+			gmail_draft_id VARCHAR(64) NOT NULL,
+			thread_id VARCHAR(64),
+			subject TEXT,
+			sent_message_id VARCHAR(64),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			sent_at DATETIME,
+			UNIQUE KEY uniq_account_draft (account_id, gmail_draft_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (account_id) REFERENCES mailbox_accounts(id) ON DELETE CASCADE,
+			FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE SET NULL
+		);`,
+		// Scheduled sends, retries and system mail (see internal/sendqueue)
+		`CREATE TABLE IF NOT EXISTS send_queue (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			account_id INT NOT NULL,
+			kind VARCHAR(16) NOT NULL DEFAULT 'user',
+			payload LONGTEXT NOT NULL,
+			send_at DATETIME NOT NULL,
+			status VARCHAR(16) NOT NULL DEFAULT 'pending',
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			sent_message_id VARCHAR(64),
+			sent_at DATETIME,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			KEY idx_queue_due (status, send_at),
+			KEY idx_queue_user (user_id, send_at),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (account_id) REFERENCES mailbox_accounts(id) ON DELETE CASCADE
+		);`,
+		// Stable Google account ID ("sub" of the ID token) behind each
+		// mailbox; NULL until the mailbox is next connected
+		`ALTER TABLE mailbox_accounts ADD COLUMN google_sub VARCHAR(255);`,
+		`ALTER TABLE mailbox_accounts ADD KEY idx_account_sub (google_sub);`,
+		// API key lifecycle: names, scopes, expiry and last use. Keys from
+		// before scopes get every non-admin scope, as they had until now.
+		`ALTER TABLE api_keys ADD COLUMN active BOOLEAN DEFAULT TRUE;`,
+		`ALTER TABLE api_keys ADD COLUMN name VARCHAR(100);`,
+		`ALTER TABLE api_keys ADD COLUMN key_prefix VARCHAR(32);`,
+		`ALTER TABLE api_keys ADD COLUMN scopes VARCHAR(255);`,
+		`ALTER TABLE api_keys ADD COLUMN expires_at DATETIME;`,
+		`ALTER TABLE api_keys ADD COLUMN last_used_at DATETIME;`,
+		`ALTER TABLE api_keys ADD COLUMN last_used_ip VARCHAR(64);`,
+		`UPDATE api_keys SET scopes = 'search,read,send,modify' WHERE scopes IS NULL;`,
+		`ALTER TABLE api_keys ADD UNIQUE KEY uniq_key_hash (key_hash);`,
+		// Server-side sessions; the id is the session JWT's jti
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id VARCHAR(64) PRIMARY KEY,
+			user_id INT NOT NULL,
+			user_agent VARCHAR(255),
+			ip VARCHAR(64),
+			created_at DATETIME NOT NULL,
+			last_seen_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			revoked_at DATETIME,
+			KEY idx_session_user (user_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		// TOTP two-factor authentication. mfa_secret is sealed with the
+		// user's data key and set before enrolment is confirmed;
+		// mfa_last_step stops a code from being used twice.
+		`ALTER TABLE users ADD COLUMN mfa_secret TEXT;`,
+		`ALTER TABLE users ADD COLUMN mfa_enabled BOOLEAN NOT NULL DEFAULT FALSE;`,
+		`ALTER TABLE users ADD COLUMN mfa_last_step BIGINT NOT NULL DEFAULT 0;`,
+		`CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			code_hash VARCHAR(64) NOT NULL,
+			used_at DATETIME,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			KEY idx_recovery_user (user_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		// Roles whose members must enrol before using their session
+		`CREATE TABLE IF NOT EXISTS mfa_policies (
+			role VARCHAR(20) PRIMARY KEY,
+			required BOOLEAN NOT NULL DEFAULT FALSE,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+		);`,
+		// Email ownership. Existing users are grandfathered in as verified;
+		// password signups start unverified.
+		`ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT TRUE;`,
+		`ALTER TABLE users ADD COLUMN verification_sent_at DATETIME;`,
+		// Failure counters for login throttling when THROTTLE_STORE=db
+		// (see internal/throttle)
+		`CREATE TABLE IF NOT EXISTS throttle (
+			throttle_key VARCHAR(255) PRIMARY KEY,
+			failures INT NOT NULL DEFAULT 0,
+			last_failure DATETIME NOT NULL,
+			locked_until DATETIME,
+			window_ends DATETIME NOT NULL,
+			KEY idx_throttle_window (window_ends)
+		);`,
+		// Reset tokens are stored hashed, one per user. Outstanding raw
+		// tokens (valid for an hour at most) are dropped.
+		`ALTER TABLE password_resets ADD COLUMN token_hash VARCHAR(64);`,
+		`DELETE FROM password_resets WHERE token_hash IS NULL;`,
+		`ALTER TABLE password_resets MODIFY token VARCHAR(255) NULL;`,
+		`ALTER TABLE password_resets ADD UNIQUE KEY uniq_reset_user (user_id);`,
+		`ALTER TABLE password_resets ADD UNIQUE KEY uniq_reset_token (token_hash);`,
+		// System mail carries reset and verification links: it expires
+		// unsent, and its body is dropped once it is done with
+		`ALTER TABLE send_queue ADD COLUMN expires_at DATETIME;`,
+		`UPDATE send_queue SET payload = JSON_REMOVE(payload, '$.body', '$.html_body', '$.attachments')
+			WHERE kind = 'system' AND status <> 'pending' AND JSON_CONTAINS_PATH(payload, 'one', '$.body');`,
+		// Messages the embedding provider rejected, retried with backoff
+		// instead of blocking the queue (internal/vector)
+		`CREATE TABLE IF NOT EXISTS embedding_failures (
+			account_id INT NOT NULL,
+			gmail_id VARCHAR(64) NOT NULL,
+			model VARCHAR(128) NOT NULL,
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			retry_after DATETIME NOT NULL,
+			PRIMARY KEY (account_id, gmail_id, model)
+		);`,
+		// Messages Gmail failed to return during a sync, retried with
+		// backoff so the history cursor can move on (internal/mailsync)
+		`CREATE TABLE IF NOT EXISTS sync_failures (
+			account_id INT NOT NULL,
+			gmail_id VARCHAR(64) NOT NULL,
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			retry_after DATETIME NOT NULL,
+			PRIMARY KEY (account_id, gmail_id)
+		);`,
 	}
 
 	for _, query := range queries {
@@ -48,6 +306,11 @@ func CreateTables() {
 			if strings.Contains(err.Error(), "Duplicate column name") {
 				continue
 			}
+			// Same for re-running index migrations (MySQL Errors 1061 and 1091)
+			if strings.Contains(err.Error(), "Duplicate key name") ||
+				strings.Contains(err.Error(), "check that column/key exists") {
+				continue
+			}
 
 			log.Fatalf("Failed to create table/column: %v\nQuery: %s", err, query)
 		}
@@ -0,0 +1,102 @@
+// Package drafts records which user and API key created each draft, so
+// agent-prepared mail can be traced back to the key that wrote it.
+package drafts
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"mcp-gmail-server/internal/db"
+	"mcp-gmail-server/internal/gmail"
+)
+
+// Record is the server-side bookkeeping for a draft.
+type Record struct {
+	UserID        int        `json:"user_id"`
+	AccountID     int        `json:"account_id"`
+	APIKeyID      int        `json:"api_key_id,omitempty"`
+	SentMessageID string     `json:"sent_message_id,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
+
+func nullableKey(apiKeyID int) interface{} {
+	if apiKeyID == 0 {
+		return nil
+	}
+	return apiKeyID
+}
+
+// Save records a newly created draft, or an update to one created
+// elsewhere (e.g. in the Gmail UI).
+func Save(userID, accountID, apiKeyID int, d *gmail.Draft) error {
+	_, err := db.DB.Exec(`
+		INSERT INTO drafts (user_id, account_id, api_key_id, gmail_draft_id, thread_id, subject)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			thread_id = VALUES(thread_id),
+			subject = VALUES(subject)
+	`, userID, accountID, nullableKey(apiKeyID), d.ID, d.ThreadID, d.Subject)
+	return err
+}
+
+func MarkSent(accountID int, draftID, messageID string) error {
+	_, err := db.DB.Exec(`
+		UPDATE drafts SET sent_message_id = ?, sent_at = NOW()
+		WHERE account_id = ? AND gmail_draft_id = ?
+	`, messageID, accountID, draftID)
+	return err
+}
+
+func Delete(accountID int, draftID string) error {
+	_, err := db.DB.Exec(`
+		DELETE FROM drafts WHERE account_id = ? AND gmail_draft_id = ?
+	`, accountID, draftID)
+	return err
+}
+
+// Lookup returns the records for the given Gmail draft IDs, keyed by ID.
+// Drafts created outside the server have no record.
+func Lookup(accountID int, draftIDs []string) (map[string]*Record, error) {
+	result := make(map[string]*Record)
+	if len(draftIDs) == 0 {
+		return result, nil
+	}
+
+	args := []interface{}{accountID}
+	for _, id := range draftIDs {
+		args = append(args, id)
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT gmail_draft_id, user_id, account_id, api_key_id, sent_message_id,
+			created_at, updated_at, sent_at
+		FROM drafts
+		WHERE account_id = ? AND gmail_draft_id IN (?`+strings.Repeat(",?", len(draftIDs)-1)+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var rec Record
+		var apiKeyID sql.NullInt64
+		var sentMessageID sql.NullString
+		var sentAt sql.NullTime
+		if err := rows.Scan(&id, &rec.UserID, &rec.AccountID, &apiKeyID, &sentMessageID,
+			&rec.CreatedAt, &rec.UpdatedAt, &sentAt); err != nil {
+			return nil, err
+		}
+		rec.APIKeyID = int(apiKeyID.Int64)
+		rec.SentMessageID = sentMessageID.String
+		if sentAt.Valid {
+			rec.SentAt = &sentAt.Time
+		}
+		result[id] = &rec
+	}
+	return result, rows.Err()
+}
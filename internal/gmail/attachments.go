@@ -0,0 +1,103 @@
+package gmail
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// maxAttachmentText caps how much text is pulled out of a single attachment
+// for indexing.
+const maxAttachmentText = 1 << 20
+
+type Attachment struct {
+	Filename     string `json:"filename"`
+	MimeType     string `json:"mime_type"`
+	Size         int64  `json:"size"`
+	AttachmentID string `json:"attachment_id,omitempty"`
+	PartID       string `json:"-"`
+}
+
+// collectAttachments walks the MIME tree and returns every part that carries
+// a filename.
+func collectAttachments(part *gmail.MessagePart) []Attachment {
+	if part == nil {
+		return nil
+	}
+
+	var out []Attachment
+	if part.Filename != "" && part.Body != nil {
+		out = append(out, Attachment{
+			Filename:     part.Filename,
+			MimeType:     part.MimeType,
+			Size:         part.Body.Size,
+			AttachmentID: part.Body.AttachmentId,
+			PartID:       part.PartId,
+		})
+	}
+
+	for _, p := range part.Parts {
+		out = append(out, collectAttachments(p)...)
+	}
+
+	return out
+}
+
+// isTextAttachment reports whether an attachment is plain enough to index
+// without a document parser.
+func isTextAttachment(a Attachment) bool {
+	switch {
+	case strings.HasPrefix(a.MimeType, "text/"):
+		return true
+	case a.MimeType == "application/json", a.MimeType == "application/xml":
+		return true
+	}
+	return false
+}
+
+// inlineAttachmentText returns the text of small attachments whose data
+// Gmail already included in the message payload.
+func inlineAttachmentText(part *gmail.MessagePart) string {
+	if part == nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	if part.Filename != "" && part.Body != nil && part.Body.Data != "" &&
+		isTextAttachment(Attachment{MimeType: part.MimeType}) {
+		data, _ := base64.URLEncoding.DecodeString(part.Body.Data)
+		sb.Write(data)
+		sb.WriteString("\n")
+	}
+
+	for _, p := range part.Parts {
+		sb.WriteString(inlineAttachmentText(p))
+	}
+
+	return sb.String()
+}
+
+// fetchAttachmentText downloads text attachments that Gmail stores out of
+// line (those with an attachment ID).
+func fetchAttachmentText(service *gmail.Service, messageID string, attachments []Attachment) string {
+	var sb strings.Builder
+	for _, a := range attachments {
+		if a.AttachmentID == "" || !isTextAttachment(a) || a.Size > maxAttachmentText {
+			continue
+		}
+
+		body, err := service.Users.Messages.Attachments.Get("me", messageID, a.AttachmentID).Do()
+		if err != nil {
+			continue
+		}
+
+		data, err := base64.URLEncoding.DecodeString(body.Data)
+		if err != nil {
+			continue
+		}
+		sb.Write(data)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -5,29 +5,8 @@ import (
 	"encoding/json"
 
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
-func GetOAuthConfig(clientID, clientSecret, redirectURL string) *oauth2.Config {
-	return &oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		RedirectURL:  redirectURL,
-		Scopes: []string{
-			"https://www.googleapis.com/auth/gmail.readonly",
-			"https://www.googleapis.com/auth/gmail.send",
-			"https://www.googleapis.com/auth/userinfo.email",
-			"https://www.googleapis.com/auth/userinfo.profile",
-			"openid",
-		},
-		Endpoint: google.Endpoint,
-	}
-}
-
-func GetAuthURL(config *oauth2.Config) string {
-	return config.AuthCodeURL("state-token", oauth2.AccessTypeOffline, oauth2.ApprovalForce)
-}
-
 func ExchangeToken(config *oauth2.Config, code string) (*oauth2.Token, error) {
 	return config.Exchange(context.Background(), code)
 }
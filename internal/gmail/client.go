@@ -13,3 +13,9 @@ func NewGmailService(config *oauth2.Config, token *oauth2.Token) (*gmail.Service
 	ts := config.TokenSource(ctx, token)
 	return gmail.NewService(ctx, option.WithTokenSource(ts))
 }
+
+// NewGmailServiceFromTokenSource builds a client from any token source, e.g.
+// a service account impersonating a Workspace user.
+func NewGmailServiceFromTokenSource(ts oauth2.TokenSource) (*gmail.Service, error) {
+	return gmail.NewService(context.Background(), option.WithTokenSource(ts))
+}
@@ -0,0 +1,163 @@
+package gmail
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+type Draft struct {
+	ID        string `json:"id"`
+	MessageID string `json:"message_id"`
+	ThreadID  string `json:"thread_id"`
+	To        string `json:"to"`
+	Subject   string `json:"subject"`
+	Snippet   string `json:"snippet"`
+	Body      string `json:"body,omitempty"`
+}
+
+func parseDraft(d *gmail.Draft) *Draft {
+	draft := &Draft{ID: d.Id}
+	if d.Message == nil {
+		return draft
+	}
+
+	e := ParseMessage(d.Message)
+	draft.MessageID = d.Message.Id
+	draft.ThreadID = d.Message.ThreadId
+	draft.To = e.To
+	draft.Subject = e.Subject
+	draft.Snippet = e.Snippet
+	draft.Body = e.Body
+	return draft
+}
+
+// prepareThread fills in the threading headers Gmail needs to keep a
+// message in ThreadID, and a "Re:" subject when none was given. Messages
+// built by ComposeReply/ComposeForward already carry their headers.
+func prepareThread(service *gmail.Service, m *OutgoingMessage) error {
+	if m.ThreadID == "" || m.InReplyTo != "" {
+		return nil
+	}
+
+	thread, err := service.Users.Threads.Get("me", m.ThreadID).
+		Format("metadata").
+		MetadataHeaders("Message-ID", "References", "Subject").
+		Do()
+	if err != nil {
+		return fmt.Errorf("thread %s: %w", m.ThreadID, err)
+	}
+	if len(thread.Messages) == 0 {
+		return nil
+	}
+
+	last := thread.Messages[len(thread.Messages)-1]
+	var messageID, references, subject string
+	if last.Payload != nil {
+		for _, h := range last.Payload.Headers {
+			switch strings.ToLower(h.Name) {
+			case "message-id":
+				messageID = h.Value
+			case "references":
+				references = h.Value
+			case "subject":
+				subject = h.Value
+			}
+		}
+	}
+
+	if messageID != "" {
+		m.InReplyTo = messageID
+		m.References = strings.TrimSpace(references + " " + messageID)
+	}
+	if m.Subject == "" && subject != "" {
+		m.Subject = prefixSubject("Re:", subject)
+	}
+	return nil
+}
+
+func draftPayload(service *gmail.Service, m *OutgoingMessage) (*gmail.Draft, error) {
+	if err := prepareThread(service, m); err != nil {
+		return nil, err
+	}
+	raw, err := buildRawMessage(m)
+	if err != nil {
+		return nil, err
+	}
+	return &gmail.Draft{
+		Message: &gmail.Message{
+			Raw:      raw,
+			ThreadId: m.ThreadID,
+		},
+	}, nil
+}
+
+func CreateDraft(service *gmail.Service, m *OutgoingMessage) (*Draft, error) {
+	payload, err := draftPayload(service, m)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := service.Users.Drafts.Create("me", payload).Do()
+	if err != nil {
+		return nil, scopeError(err)
+	}
+	return GetDraft(service, d.Id)
+}
+
+// UpdateDraft replaces the draft's content.
+func UpdateDraft(service *gmail.Service, id string, m *OutgoingMessage) (*Draft, error) {
+	payload, err := draftPayload(service, m)
+	if err != nil {
+		return nil, err
+	}
+	payload.Id = id
+
+	if _, err := service.Users.Drafts.Update("me", id, payload).Do(); err != nil {
+		return nil, scopeError(err)
+	}
+	return GetDraft(service, id)
+}
+
+func GetDraft(service *gmail.Service, id string) (*Draft, error) {
+	d, err := service.Users.Drafts.Get("me", id).Format("full").Do()
+	if err != nil {
+		return nil, scopeError(err)
+	}
+	return parseDraft(d), nil
+}
+
+// ListDrafts returns up to limit drafts, newest first, without bodies.
+func ListDrafts(service *gmail.Service, limit int) ([]*Draft, error) {
+	res, err := service.Users.Drafts.List("me").MaxResults(int64(limit)).Do()
+	if err != nil {
+		return nil, scopeError(err)
+	}
+
+	drafts := make([]*Draft, 0, len(res.Drafts))
+	for _, d := range res.Drafts {
+		full, err := service.Users.Drafts.Get("me", d.Id).Format("metadata").Do()
+		if err != nil {
+			// Deleted between list and get
+			continue
+		}
+		draft := parseDraft(full)
+		draft.Body = ""
+		drafts = append(drafts, draft)
+	}
+	return drafts, nil
+}
+
+func DeleteDraft(service *gmail.Service, id string) error {
+	return scopeError(service.Users.Drafts.Delete("me", id).Do())
+}
+
+// SendDraft sends the draft and returns the resulting message.
+func SendDraft(service *gmail.Service, id string) (*gmail.Message, error) {
+	msg, err := service.Users.Drafts.Send("me", &gmail.Draft{Id: id}).Do()
+	if err != nil {
+		return nil, scopeError(err)
+	}
+	return msg, nil
+}
@@ -8,12 +8,25 @@ import (
 )
 
 type Email struct {
-	ID      string `json:"id"`
-	From    string `json:"from"`
-	Subject string `json:"subject"`
-	Date    string `json:"date"`
-	Snippet string `json:"snippet"`
-	Body    string `json:"body"`
+	ID           string   `json:"id"`
+	ThreadID     string   `json:"thread_id,omitempty"`
+	From         string   `json:"from"`
+	To           string   `json:"to,omitempty"`
+	Subject      string   `json:"subject"`
+	Date         string   `json:"date"`
+	Snippet      string   `json:"snippet"`
+	Body         string   `json:"body"`
+	LabelIDs     []string `json:"label_ids,omitempty"`
+	InternalDate int64    `json:"internal_date,omitempty"`
+	HistoryID    uint64   `json:"history_id,omitempty"`
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// AttachmentText is the concatenated content of text attachments.
+	AttachmentText string `json:"-"`
+
+	// Account names the mailbox the message came from when several
+	// mailboxes were searched together.
+	Account string `json:"account,omitempty"`
 }
 
 func FetchEmails(service *gmail.Service, query string, limit int) ([]Email, error) {
@@ -22,6 +35,19 @@ func FetchEmails(service *gmail.Service, query string, limit int) ([]Email, erro
 	}
 
 	// 1. List messages first to get IDs and maintain order
+	messageIDs, err := ListMessageIDs(service, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Fetch details concurrently
+	return GetEmails(service, messageIDs), nil
+}
+
+// ListMessageIDs pages through users.messages.list until limit IDs are
+// collected or the result set is exhausted. An error is only returned if the
+// very first page fails; later failures return what was collected so far.
+func ListMessageIDs(service *gmail.Service, query string, limit int) ([]string, error) {
 	var messageIDs []string
 	var pageToken string
 
@@ -38,6 +64,9 @@ func FetchEmails(service *gmail.Service, query string, limit int) ([]Email, erro
 
 		res, err := req.Do()
 		if err != nil {
+			if pageToken == "" {
+				return nil, err
+			}
 			break
 		}
 
@@ -54,10 +83,27 @@ func FetchEmails(service *gmail.Service, query string, limit int) ([]Email, erro
 		pageToken = res.NextPageToken
 	}
 
-	// 2. Fetch details concurrently
+	return messageIDs, nil
+}
+
+// GetEmail fetches and parses a single message in "full" format.
+func GetEmail(service *gmail.Service, id string) (Email, error) {
+	msg, err := service.Users.Messages.Get("me", id).Format("full").Do()
+	if err != nil {
+		return Email{}, err
+	}
+
+	email := ParseMessage(msg)
+	email.AttachmentText += fetchAttachmentText(service, id, email.Attachments)
+	return email, nil
+}
+
+// GetEmails fetches the given message IDs concurrently, preserving order.
+// Messages that fail to load are returned with only their ID populated.
+func GetEmails(service *gmail.Service, messageIDs []string) []Email {
 	count := len(messageIDs)
 	if count == 0 {
-		return []Email{}, nil
+		return []Email{}
 	}
 
 	allEmails := make([]Email, count)
@@ -73,43 +119,18 @@ func FetchEmails(service *gmail.Service, query string, limit int) ([]Email, erro
 	worker := func() {
 		defer wg.Done()
 		for j := range jobs {
-			msg, err := service.Users.Messages.Get("me", j.msgID).Format("full").Do()
+			email, err := GetEmail(service, j.msgID)
 			if err != nil {
-				// If error, we might leave empty or handle partial.
-				// For now, keep it empty or retry?
-				// Let's just continue, the slot will be empty struct.
-				// Better: try to populate ID at least.
+				// Keep the ID so callers can tell which message failed.
 				allEmails[j.index].ID = j.msgID
 				continue
 			}
 
-			email := Email{
-				ID:      msg.Id,
-				Snippet: msg.Snippet,
-			}
-
-			for _, h := range msg.Payload.Headers {
-				switch h.Name {
-				case "From":
-					email.From = h.Value
-				case "Subject":
-					email.Subject = h.Value
-				case "Date":
-					email.Date = h.Value
-				}
-			}
-
-			email.Body = extractBody(msg.Payload)
-			if email.Body == "" {
-				email.Body = msg.Snippet
-			}
-
 			// No mutex needed
 			allEmails[j.index] = email
 		}
 	}
 
-	// Start workers
 	// Start workers
 	numWorkers := 20
 	if count < 20 {
@@ -130,7 +151,46 @@ func FetchEmails(service *gmail.Service, query string, limit int) ([]Email, erro
 	close(jobs)
 	wg.Wait()
 
-	return allEmails, nil
+	return allEmails
+}
+
+// ParseMessage converts a Gmail API message into an Email.
+func ParseMessage(msg *gmail.Message) Email {
+	email := Email{
+		ID:           msg.Id,
+		ThreadID:     msg.ThreadId,
+		Snippet:      msg.Snippet,
+		LabelIDs:     msg.LabelIds,
+		InternalDate: msg.InternalDate,
+		HistoryID:    msg.HistoryId,
+	}
+
+	if msg.Payload == nil {
+		return email
+	}
+
+	for _, h := range msg.Payload.Headers {
+		switch h.Name {
+		case "From":
+			email.From = h.Value
+		case "To":
+			email.To = h.Value
+		case "Subject":
+			email.Subject = h.Value
+		case "Date":
+			email.Date = h.Value
+		}
+	}
+
+	email.Body = extractBody(msg.Payload)
+	if email.Body == "" {
+		email.Body = msg.Snippet
+	}
+
+	email.Attachments = collectAttachments(msg.Payload)
+	email.AttachmentText = inlineAttachmentText(msg.Payload)
+
+	return email
 }
 
 func extractBody(part *gmail.MessagePart) string {
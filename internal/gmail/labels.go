@@ -0,0 +1,161 @@
+package gmail
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Message actions accepted by ApplyAction.
+const (
+	ActionAddLabels    = "add_labels"
+	ActionRemoveLabels = "remove_labels"
+	ActionArchive      = "archive"
+	ActionUnarchive    = "unarchive"
+	ActionTrash        = "trash"
+	ActionUntrash      = "untrash"
+	ActionStar         = "star"
+	ActionUnstar       = "unstar"
+	ActionMarkRead     = "mark_read"
+	ActionMarkUnread   = "mark_unread"
+)
+
+// batchModifyLimit is the most ids users.messages.batchModify accepts.
+const batchModifyLimit = 1000
+
+type Label struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	Type           string `json:"type"`
+	MessagesTotal  int64  `json:"messages_total,omitempty"`
+	MessagesUnread int64  `json:"messages_unread,omitempty"`
+}
+
+func ListLabels(service *gmail.Service) ([]Label, error) {
+	res, err := service.Users.Labels.List("me").Do()
+	if err != nil {
+		return nil, scopeError(err)
+	}
+
+	labels := make([]Label, 0, len(res.Labels))
+	for _, l := range res.Labels {
+		labels = append(labels, Label{
+			ID:             l.Id,
+			Name:           l.Name,
+			Type:           l.Type,
+			MessagesTotal:  l.MessagesTotal,
+			MessagesUnread: l.MessagesUnread,
+		})
+	}
+	return labels, nil
+}
+
+func CreateLabel(service *gmail.Service, name string) (*Label, error) {
+	l, err := service.Users.Labels.Create("me", &gmail.Label{
+		Name:                  name,
+		LabelListVisibility:   "labelShow",
+		MessageListVisibility: "show",
+	}).Do()
+	if err != nil {
+		return nil, scopeError(err)
+	}
+	return &Label{ID: l.Id, Name: l.Name, Type: l.Type}, nil
+}
+
+// ResolveLabelIDs maps label names (case-insensitive) or IDs to label IDs.
+func ResolveLabelIDs(service *gmail.Service, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	labels, err := ListLabels(service)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(names))
+	for _, name := range names {
+		found := ""
+		for _, l := range labels {
+			if l.ID == name || strings.EqualFold(l.Name, name) {
+				found = l.ID
+				break
+			}
+		}
+		if found == "" {
+			return nil, fmt.Errorf("unknown label %q", name)
+		}
+		ids = append(ids, found)
+	}
+	return ids, nil
+}
+
+// ApplyAction performs a message action on every id. labels is only used
+// by add_labels and remove_labels and may hold names or IDs.
+func ApplyAction(service *gmail.Service, ids []string, action string, labels []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var add, remove []string
+	switch action {
+	case ActionAddLabels, ActionRemoveLabels:
+		if len(labels) == 0 {
+			return fmt.Errorf("%s needs at least one label", action)
+		}
+		resolved, err := ResolveLabelIDs(service, labels)
+		if err != nil {
+			return err
+		}
+		if action == ActionAddLabels {
+			add = resolved
+		} else {
+			remove = resolved
+		}
+	case ActionArchive:
+		remove = []string{"INBOX"}
+	case ActionUnarchive:
+		add = []string{"INBOX"}
+	case ActionStar:
+		add = []string{"STARRED"}
+	case ActionUnstar:
+		remove = []string{"STARRED"}
+	case ActionMarkRead:
+		remove = []string{"UNREAD"}
+	case ActionMarkUnread:
+		add = []string{"UNREAD"}
+	case ActionTrash, ActionUntrash:
+		// No batch endpoint for trash; batchDelete is permanent
+		for _, id := range ids {
+			var err error
+			if action == ActionTrash {
+				_, err = service.Users.Messages.Trash("me", id).Do()
+			} else {
+				_, err = service.Users.Messages.Untrash("me", id).Do()
+			}
+			if err != nil {
+				return scopeError(err)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+
+	for start := 0; start < len(ids); start += batchModifyLimit {
+		end := start + batchModifyLimit
+		if end > len(ids) {
+			end = len(ids)
+		}
+		err := service.Users.Messages.BatchModify("me", &gmail.BatchModifyMessagesRequest{
+			Ids:            ids[start:end],
+			AddLabelIds:    add,
+			RemoveLabelIds: remove,
+		}).Do()
+		if err != nil {
+			return scopeError(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package gmail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+)
+
+// MaxMessageSize is Gmail's limit for a whole message, attachments and
+// encoding included.
+const MaxMessageSize = 25 << 20
+
+var (
+	ErrHeaderInjection = errors.New("header values must not contain line breaks")
+	ErrNoRecipients    = errors.New("message has no recipients")
+	ErrMessageTooLarge = fmt.Errorf("message exceeds Gmail's %dMB limit", MaxMessageSize>>20)
+)
+
+// entity is a node of the MIME tree: either a leaf with an encoded body or
+// a multipart container.
+type entity struct {
+	header   textproto.MIMEHeader
+	body     []byte
+	subtype  string
+	children []*entity
+}
+
+// render returns the entity's headers and encoded body. Multipart
+// containers get their boundary here.
+func (e *entity) render() (textproto.MIMEHeader, []byte, error) {
+	if e.subtype == "" {
+		return e.header, e.body, nil
+	}
+	if len(e.children) == 1 {
+		return e.children[0].render()
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, child := range e.children {
+		h, body, err := child.render()
+		if err != nil {
+			return nil, nil, err
+		}
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, nil, err
+		}
+		pw.Write(body)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", fmt.Sprintf("multipart/%s; boundary=%q", e.subtype, mw.Boundary()))
+	return header, buf.Bytes(), nil
+}
+
+func textEntity(mediaType, text string) *entity {
+	var buf bytes.Buffer
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(text))
+	qp.Close()
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mediaType+"; charset=\"utf-8\"")
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	return &entity{header: header, body: buf.Bytes()}
+}
+
+func attachmentEntity(a OutgoingAttachment) (*entity, error) {
+	if err := checkHeader(a.Filename); err != nil {
+		return nil, err
+	}
+
+	mimeType := a.MimeType
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(filepath.Ext(a.Filename))
+	}
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mimeType = mediaType
+	} else {
+		mimeType = "application/octet-stream"
+	}
+
+	disposition := "attachment"
+	if a.ContentID != "" {
+		disposition = "inline"
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(mimeType, map[string]string{"name": a.Filename}))
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename}))
+	header.Set("Content-Transfer-Encoding", "base64")
+	if a.ContentID != "" {
+		if err := checkHeader(a.ContentID); err != nil {
+			return nil, err
+		}
+		header.Set("Content-ID", "<"+strings.Trim(a.ContentID, "<>")+">")
+	}
+	if header.Get("Content-Type") == "" || header.Get("Content-Disposition") == "" {
+		return nil, fmt.Errorf("invalid attachment %q", a.Filename)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(a.Data)
+	var body bytes.Buffer
+	for len(encoded) > 76 {
+		body.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	body.WriteString(encoded + "\r\n")
+
+	return &entity{header: header, body: body.Bytes()}, nil
+}
+
+// checkHeader rejects values that could start a new header line.
+func checkHeader(value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return ErrHeaderInjection
+	}
+	return nil
+}
+
+// formatAddressList validates a comma-separated address list and renders
+// it with RFC 2047 encoded display names.
+func formatAddressList(field, value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "", nil
+	}
+	if err := checkHeader(value); err != nil {
+		return "", err
+	}
+
+	list, err := mail.ParseAddressList(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s address: %w", field, err)
+	}
+
+	out := make([]string, 0, len(list))
+	for _, a := range list {
+		out = append(out, a.String())
+	}
+	return strings.Join(out, ", "), nil
+}
+
+// Compose renders m as an RFC 5322 message. The body tree is
+//
+//	multipart/mixed          (when there are regular attachments)
+//	  multipart/related      (when there are inline images)
+//	    multipart/alternative (when both text and HTML are given)
+//	      text/plain
+//	      text/html
+//	    inline images
+//	  attachments
+//
+// with single-child containers collapsed.
+func Compose(m *OutgoingMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	writeHeader := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+		}
+	}
+
+	for _, f := range []struct{ name, value string }{
+		{"To", m.To}, {"Cc", m.Cc}, {"Bcc", m.Bcc},
+	} {
+		formatted, err := formatAddressList(f.name, f.value)
+		if err != nil {
+			return nil, err
+		}
+		writeHeader(f.name, formatted)
+	}
+
+	for _, v := range []string{m.Subject, m.InReplyTo, m.References} {
+		if err := checkHeader(v); err != nil {
+			return nil, err
+		}
+	}
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader("In-Reply-To", m.InReplyTo)
+	writeHeader("References", m.References)
+	writeHeader("MIME-Version", "1.0")
+
+	alternative := &entity{subtype: "alternative"}
+	if m.Body != "" || m.HTMLBody == "" {
+		alternative.children = append(alternative.children, textEntity("text/plain", m.Body))
+	}
+	if m.HTMLBody != "" {
+		alternative.children = append(alternative.children, textEntity("text/html", m.HTMLBody))
+	}
+
+	related := &entity{subtype: "related", children: []*entity{alternative}}
+	mixed := &entity{subtype: "mixed", children: []*entity{related}}
+	for _, a := range m.Attachments {
+		part, err := attachmentEntity(a)
+		if err != nil {
+			return nil, err
+		}
+		if a.ContentID != "" {
+			related.children = append(related.children, part)
+		} else {
+			mixed.children = append(mixed.children, part)
+		}
+	}
+
+	header, body, err := mixed.render()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+		writeHeader(name, header.Get(name))
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	if buf.Len() > MaxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+	return buf.Bytes(), nil
+}
+
+// buildRawMessage composes m in the URL-safe base64 form the Gmail API
+// expects.
+func buildRawMessage(m *OutgoingMessage) (string, error) {
+	raw, err := Compose(m)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(raw), nil
+}
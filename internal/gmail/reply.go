@@ -0,0 +1,239 @@
+package gmail
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// maxForwardAttachments caps the combined size of attachments copied into
+// a forward, leaving headroom under Gmail's 25MB message limit.
+const maxForwardAttachments = 20 << 20
+
+// sourceMessage is the parts of an original message a reply or forward
+// needs.
+type sourceMessage struct {
+	Email
+	Cc         string
+	ReplyTo    string
+	MessageID  string
+	References string
+	payload    *gmail.MessagePart
+}
+
+func loadSource(service *gmail.Service, id string) (*sourceMessage, error) {
+	msg, err := service.Users.Messages.Get("me", id).Format("full").Do()
+	if err != nil {
+		return nil, fmt.Errorf("message %s: %w", id, err)
+	}
+
+	src := &sourceMessage{Email: ParseMessage(msg), payload: msg.Payload}
+	if msg.Payload != nil {
+		for _, h := range msg.Payload.Headers {
+			switch strings.ToLower(h.Name) {
+			case "cc":
+				src.Cc = h.Value
+			case "reply-to":
+				src.ReplyTo = h.Value
+			case "message-id":
+				src.MessageID = h.Value
+			case "references":
+				src.References = h.Value
+			}
+		}
+	}
+	return src, nil
+}
+
+// threadHeaders points m at the source message's conversation.
+func (src *sourceMessage) threadHeaders(m *OutgoingMessage) {
+	m.ThreadID = src.ThreadID
+	if src.MessageID != "" {
+		m.InReplyTo = src.MessageID
+		m.References = strings.TrimSpace(src.References + " " + src.MessageID)
+	}
+}
+
+// prefixSubject adds "Re:" or "Fwd:" unless the subject already starts with
+// an equivalent prefix.
+func prefixSubject(prefix, subject string) string {
+	lower := strings.ToLower(strings.TrimSpace(subject))
+	existing := []string{strings.ToLower(prefix)}
+	if prefix == "Fwd:" {
+		existing = append(existing, "fw:")
+	}
+	for _, p := range existing {
+		if strings.HasPrefix(lower, p) {
+			return subject
+		}
+	}
+	return prefix + " " + subject
+}
+
+// parseAddresses splits a header into addresses, keeping unparsable
+// entries verbatim.
+func parseAddresses(header string) []*mail.Address {
+	if strings.TrimSpace(header) == "" {
+		return nil
+	}
+	if list, err := mail.ParseAddressList(header); err == nil {
+		return list
+	}
+
+	var out []*mail.Address
+	for _, part := range strings.Split(header, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, &mail.Address{Address: part})
+		}
+	}
+	return out
+}
+
+// joinAddresses formats addresses, dropping any in skip (lowercased) and
+// duplicates.
+func joinAddresses(addrs []*mail.Address, skip map[string]bool) string {
+	var out []string
+	for _, a := range addrs {
+		key := strings.ToLower(a.Address)
+		if skip[key] {
+			continue
+		}
+		skip[key] = true
+		out = append(out, a.String())
+	}
+	return strings.Join(out, ", ")
+}
+
+func quoteBody(src *sourceMessage) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "On %s, %s wrote:\n", src.Date, src.From)
+	for _, line := range strings.Split(strings.TrimRight(src.Body, "\r\n"), "\n") {
+		b.WriteString("> " + strings.TrimRight(line, "\r") + "\n")
+	}
+	return b.String()
+}
+
+// ComposeReply builds a reply to message id from the mailbox self. With
+// all set, the other original recipients are copied (reply-all).
+func ComposeReply(service *gmail.Service, id, self, body string, all bool) (*OutgoingMessage, error) {
+	src, err := loadSource(service, id)
+	if err != nil {
+		return nil, err
+	}
+
+	selfKey := strings.ToLower(self)
+	target := parseAddresses(src.ReplyTo)
+	if len(target) == 0 {
+		target = parseAddresses(src.From)
+	}
+	// Replying to our own message goes back to its recipients
+	if len(target) == 1 && strings.EqualFold(target[0].Address, self) {
+		target = parseAddresses(src.To)
+	}
+
+	seen := map[string]bool{selfKey: true}
+	m := &OutgoingMessage{
+		To:      joinAddresses(target, seen),
+		Subject: prefixSubject("Re:", src.Subject),
+		Body:    body + "\n\n" + quoteBody(src),
+	}
+	if all {
+		others := append(parseAddresses(src.To), parseAddresses(src.Cc)...)
+		m.Cc = joinAddresses(others, seen)
+	}
+	if m.To == "" {
+		return nil, fmt.Errorf("message %s has no one to reply to", id)
+	}
+
+	src.threadHeaders(m)
+	return m, nil
+}
+
+// ComposeForward builds a forward of message id to the given recipients,
+// optionally copying the original attachments.
+func ComposeForward(service *gmail.Service, id, to, body string, includeAttachments bool) (*OutgoingMessage, error) {
+	src, err := loadSource(service, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	if body != "" {
+		b.WriteString(body + "\n\n")
+	}
+	b.WriteString("---------- Forwarded message ---------\n")
+	fmt.Fprintf(&b, "From: %s\nDate: %s\nSubject: %s\nTo: %s\n", src.From, src.Date, src.Subject, src.To)
+	if src.Cc != "" {
+		fmt.Fprintf(&b, "Cc: %s\n", src.Cc)
+	}
+	b.WriteString("\n" + src.Body)
+
+	m := &OutgoingMessage{
+		To:      to,
+		Subject: prefixSubject("Fwd:", src.Subject),
+		Body:    b.String(),
+	}
+	src.threadHeaders(m)
+
+	if includeAttachments {
+		m.Attachments, err = downloadAttachments(service, src)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func downloadAttachments(service *gmail.Service, src *sourceMessage) ([]OutgoingAttachment, error) {
+	var total int64
+	for _, a := range src.Attachments {
+		total += a.Size
+	}
+	if total > maxForwardAttachments {
+		return nil, fmt.Errorf("attachments total %d bytes, too large to forward", total)
+	}
+
+	inline := inlineAttachmentData(src.payload)
+
+	var out []OutgoingAttachment
+	for _, a := range src.Attachments {
+		data, ok := inline[a.PartID]
+		if !ok && a.AttachmentID != "" {
+			body, err := service.Users.Messages.Attachments.Get("me", src.ID, a.AttachmentID).Do()
+			if err != nil {
+				return nil, fmt.Errorf("attachment %s: %w", a.Filename, err)
+			}
+			data, err = base64.URLEncoding.DecodeString(body.Data)
+			if err != nil {
+				return nil, fmt.Errorf("attachment %s: %w", a.Filename, err)
+			}
+		}
+		out = append(out, OutgoingAttachment{Filename: a.Filename, MimeType: a.MimeType, Data: data})
+	}
+	return out, nil
+}
+
+// inlineAttachmentData returns attachment bytes Gmail already included in
+// the payload, keyed by part ID.
+func inlineAttachmentData(part *gmail.MessagePart) map[string][]byte {
+	out := make(map[string][]byte)
+	var walk func(p *gmail.MessagePart)
+	walk = func(p *gmail.MessagePart) {
+		if p == nil {
+			return
+		}
+		if p.Filename != "" && p.Body != nil && p.Body.Data != "" {
+			if data, err := base64.URLEncoding.DecodeString(p.Body.Data); err == nil {
+				out[p.PartId] = data
+			}
+		}
+		for _, c := range p.Parts {
+			walk(c)
+		}
+	}
+	walk(part)
+	return out
+}
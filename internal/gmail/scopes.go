@@ -0,0 +1,39 @@
+package gmail
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Optional scopes, requested only when the matching feature is enabled.
+const (
+	// ModifyScope lets the server change labels and move mail to trash
+	// (GMAIL_MODIFY_ENABLED).
+	ModifyScope = "https://www.googleapis.com/auth/gmail.modify"
+	// ComposeScope lets the server manage drafts (GMAIL_COMPOSE_ENABLED).
+	ComposeScope = "https://www.googleapis.com/auth/gmail.compose"
+)
+
+// ErrScopeNotGranted means the mailbox was connected before an optional
+// scope was enabled and has to be reconnected.
+var ErrScopeNotGranted = errors.New("mailbox has not granted the Gmail scope this needs, reconnect it")
+
+// scopeError turns Gmail's insufficient-scope response into
+// ErrScopeNotGranted so callers can ask for a reconnect.
+func scopeError(err error) error {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusForbidden {
+		for _, e := range gerr.Errors {
+			if e.Reason == "insufficientPermissions" {
+				return ErrScopeNotGranted
+			}
+		}
+		if strings.Contains(gerr.Message, "insufficient") {
+			return ErrScopeNotGranted
+		}
+	}
+	return err
+}
@@ -1,20 +1,68 @@
 package gmail
 
 import (
-	"encoding/base64"
-	"fmt"
+	"strings"
 
 	"google.golang.org/api/gmail/v1"
 )
 
+// OutgoingMessage is a message to send or save as a draft. See Compose for
+// how it is rendered.
+type OutgoingMessage struct {
+	To      string `json:"to"`
+	Cc      string `json:"cc,omitempty"`
+	Bcc     string `json:"bcc,omitempty"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	// HTMLBody is sent as multipart/alternative alongside Body.
+	HTMLBody string `json:"html_body,omitempty"`
+	// ThreadID places the message in an existing conversation.
+	ThreadID string `json:"thread_id,omitempty"`
+
+	Attachments []OutgoingAttachment `json:"attachments,omitempty"`
+
+	// Threading headers, filled in from the thread when ThreadID is set
+	InReplyTo  string `json:"in_reply_to,omitempty"`
+	References string `json:"references,omitempty"`
+}
+
+// OutgoingAttachment is a file attached to an outgoing message. Data is
+// base64 in JSON.
+type OutgoingAttachment struct {
+	Filename string `json:"filename"`
+	MimeType string `json:"mime_type,omitempty"`
+	Data     []byte `json:"data"`
+	// ContentID makes this an inline image, referenced from the HTML body
+	// as cid:<ContentID>.
+	ContentID string `json:"content_id,omitempty"`
+}
+
+func (m *OutgoingMessage) hasRecipients() bool {
+	return strings.TrimSpace(m.To+m.Cc+m.Bcc) != ""
+}
+
 // SendEmail sends a plain text email using the Gmail API
 func SendEmail(service *gmail.Service, to string, subject string, bodyText string) error {
-	msgStr := fmt.Sprintf("To: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=\"utf-8\"\r\n\r\n%s", to, subject, bodyText)
-	msg := []byte(msgStr)
-	message := &gmail.Message{
-		Raw: base64.URLEncoding.EncodeToString(msg),
+	_, err := Send(service, &OutgoingMessage{To: to, Subject: subject, Body: bodyText})
+	return err
+}
+
+// Send sends m, keeping it in m.ThreadID when set.
+func Send(service *gmail.Service, m *OutgoingMessage) (*gmail.Message, error) {
+	if !m.hasRecipients() {
+		return nil, ErrNoRecipients
+	}
+	if err := prepareThread(service, m); err != nil {
+		return nil, err
 	}
 
-	_, err := service.Users.Messages.Send("me", message).Do()
-	return err
+	raw, err := buildRawMessage(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.Users.Messages.Send("me", &gmail.Message{
+		Raw:      raw,
+		ThreadId: m.ThreadID,
+	}).Do()
 }
@@ -35,3 +35,30 @@ func NewLLM() (Client, error) {
 		return nil, fmt.Errorf("unsupported LLM_PROVIDER: %s", provider)
 	}
 }
+
+// NewEmbedder builds the embedding provider selected by EMBEDDING_PROVIDER.
+// EMBEDDING_MODEL overrides each provider's default model.
+func NewEmbedder() (Embedder, error) {
+	provider := os.Getenv("EMBEDDING_PROVIDER")
+	model := os.Getenv("EMBEDDING_MODEL")
+
+	switch provider {
+
+	case "gemini":
+		key := os.Getenv("GEMINI_API_KEY")
+		if key == "" {
+			return nil, fmt.Errorf("GEMINI_API_KEY not set")
+		}
+		return NewGeminiEmbedder(key, model), nil
+
+	case "openai":
+		// Key is optional for self-hosted OpenAI-compatible servers
+		return NewOpenAIEmbedder(os.Getenv("OPENAI_API_KEY"), os.Getenv("EMBEDDING_BASE_URL"), model), nil
+
+	case "ollama":
+		return NewOllamaEmbedder(os.Getenv("OLLAMA_URL"), model), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported EMBEDDING_PROVIDER: %s", provider)
+	}
+}
@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type GeminiEmbedder struct {
+	ApiKey    string
+	ModelName string
+}
+
+func NewGeminiEmbedder(apiKey, model string) *GeminiEmbedder {
+	if model == "" {
+		model = "text-embedding-004"
+	}
+	return &GeminiEmbedder{ApiKey: apiKey, ModelName: model}
+}
+
+func (g *GeminiEmbedder) Model() string {
+	return "gemini/" + g.ModelName
+}
+
+func (g *GeminiEmbedder) Embed(texts []string) ([][]float32, error) {
+	url := fmt.Sprintf(
+		"https://generativelanguage.googleapis.com/v1beta/models/%s:batchEmbedContents?key=%s",
+		g.ModelName, g.ApiKey,
+	)
+
+	requests := make([]map[string]interface{}, len(texts))
+	for i, text := range texts {
+		requests[i] = map[string]interface{}{
+			"model": "models/" + g.ModelName,
+			"content": map[string]interface{}{
+				"parts": []map[string]string{{"text": text}},
+			},
+		}
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"requests": requests})
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Gemini embedding error (%d): %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var res struct {
+		Embeddings []struct {
+			Values []float32 `json:"values"`
+		} `json:"embeddings"`
+	}
+	if err := json.Unmarshal(bodyBytes, &res); err != nil {
+		return nil, err
+	}
+
+	if len(res.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("Gemini returned %d embeddings for %d inputs", len(res.Embeddings), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for i, e := range res.Embeddings {
+		vectors[i] = e.Values
+	}
+	return vectors, nil
+}
@@ -3,3 +3,12 @@ package llm
 type Client interface {
 	Extract(prompt string) (string, error)
 }
+
+// Embedder turns text into vectors for semantic search.
+type Embedder interface {
+	// Embed returns one vector per input text, in order.
+	Embed(texts []string) ([][]float32, error)
+	// Model identifies the embedding space; vectors from different models
+	// must not be compared.
+	Model() string
+}
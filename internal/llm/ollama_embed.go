@@ -0,0 +1,69 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OllamaEmbedder uses a local Ollama server, so mail never leaves the host.
+type OllamaEmbedder struct {
+	BaseURL   string
+	ModelName string
+}
+
+func NewOllamaEmbedder(baseURL, model string) *OllamaEmbedder {
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	if model == "" {
+		model = "nomic-embed-text"
+	}
+	return &OllamaEmbedder{BaseURL: strings.TrimRight(baseURL, "/"), ModelName: model}
+}
+
+func (o *OllamaEmbedder) Model() string {
+	return "ollama/" + o.ModelName
+}
+
+func (o *OllamaEmbedder) Embed(texts []string) ([][]float32, error) {
+	payload := map[string]interface{}{
+		"model": o.ModelName,
+		"input": texts,
+	}
+
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequest("POST", o.BaseURL+"/api/embed", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Ollama embedding error (%d): %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var res struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := json.Unmarshal(bodyBytes, &res); err != nil {
+		return nil, err
+	}
+
+	if len(res.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("Ollama returned %d embeddings for %d inputs", len(res.Embeddings), len(texts))
+	}
+	return res.Embeddings, nil
+}
@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OpenAIEmbedder talks to any OpenAI-compatible /embeddings endpoint
+// (OpenAI, Azure-style proxies, vLLM, LM Studio, ...).
+type OpenAIEmbedder struct {
+	ApiKey    string
+	BaseURL   string
+	ModelName string
+}
+
+func NewOpenAIEmbedder(apiKey, baseURL, model string) *OpenAIEmbedder {
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	if model == "" {
+		model = "text-embedding-3-small"
+	}
+	return &OpenAIEmbedder{
+		ApiKey:    apiKey,
+		BaseURL:   strings.TrimRight(baseURL, "/"),
+		ModelName: model,
+	}
+}
+
+func (o *OpenAIEmbedder) Model() string {
+	return "openai/" + o.ModelName
+}
+
+func (o *OpenAIEmbedder) Embed(texts []string) ([][]float32, error) {
+	payload := map[string]interface{}{
+		"model": o.ModelName,
+		"input": texts,
+	}
+
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequest("POST", o.BaseURL+"/embeddings", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	if o.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.ApiKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding API error (%d): %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var res struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(bodyBytes, &res); err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range res.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding API returned out-of-range index %d", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if v == nil {
+			return nil, fmt.Errorf("embedding API returned no vector for input %d", i)
+		}
+	}
+	return vectors, nil
+}
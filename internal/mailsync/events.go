@@ -0,0 +1,54 @@
+package mailsync
+
+import (
+	"sync"
+
+	"mcp-gmail-server/internal/gmail"
+)
+
+type EventType string
+
+const (
+	// EventAdded fires when a message enters the mirror (backfill or new mail).
+	EventAdded EventType = "added"
+	// EventDeleted fires when a message is removed from the mailbox.
+	EventDeleted EventType = "deleted"
+	// EventLabelsChanged fires when only the labels of a message changed.
+	EventLabelsChanged EventType = "labels_changed"
+	// EventReset fires before a full resync wipes a mailbox's mirror.
+	EventReset EventType = "reset"
+)
+
+// Event describes a change applied to the local mirror.
+type Event struct {
+	Type      EventType
+	AccountID int
+	UserID    int
+	MessageID string
+	// Email is set for EventAdded.
+	Email *gmail.Email
+	// Labels holds the current label IDs for EventLabelsChanged.
+	Labels []string
+}
+
+var (
+	listenersMu sync.RWMutex
+	listeners   []func(Event)
+)
+
+// Subscribe registers fn to receive every change applied by the sync worker.
+// Listeners are called synchronously from the sync goroutine, so they should
+// return quickly.
+func Subscribe(fn func(Event)) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	listeners = append(listeners, fn)
+}
+
+func publish(ev Event) {
+	listenersMu.RLock()
+	defer listenersMu.RUnlock()
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
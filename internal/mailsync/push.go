@@ -0,0 +1,150 @@
+package mailsync
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"mcp-gmail-server/internal/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// PushHandler receives Gmail change notifications delivered by a Pub/Sub
+// push subscription and triggers a history sync for the affected mailbox.
+//
+// Pub/Sub signs each delivery with a Google OIDC token in the Authorization
+// header. The subscription must be created with --push-auth-service-account
+// and --push-auth-token-audience matching Audience and ServiceAccount.
+type PushHandler struct {
+	// Audience is the expected "aud" claim, usually the endpoint URL.
+	Audience string
+	// ServiceAccount must match the token's "email" claim. It is required:
+	// anyone can get Google to sign a token for their own service account.
+	ServiceAccount string
+	// Keys resolves signing keys; point it at a stub JWKS server in tests.
+	Keys *auth.JWKSCache
+}
+
+// pushEnvelope is the JSON body Pub/Sub posts to push endpoints.
+type pushEnvelope struct {
+	Message struct {
+		Data      string `json:"data"`
+		MessageID string `json:"messageId"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// Notification is the payload Gmail publishes for a mailbox change.
+type Notification struct {
+	EmailAddress string `json:"emailAddress"`
+	HistoryID    uint64 `json:"historyId"`
+}
+
+type pushClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	jwt.RegisteredClaims
+}
+
+// Verify checks the push token on r.
+func (h *PushHandler) Verify(r *http.Request) error {
+	if h.ServiceAccount == "" {
+		return fmt.Errorf("no push service account configured")
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return fmt.Errorf("missing bearer token")
+	}
+
+	claims := &pushClaims{}
+	_, err := jwt.ParseWithClaims(
+		strings.TrimPrefix(header, "Bearer "),
+		claims,
+		h.Keys.Keyfunc,
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithAudience(h.Audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return err
+	}
+
+	if claims.Issuer != "accounts.google.com" && claims.Issuer != "https://accounts.google.com" {
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+
+	if claims.Email != h.ServiceAccount || !claims.EmailVerified {
+		return fmt.Errorf("unexpected service account %q", claims.Email)
+	}
+
+	return nil
+}
+
+// ParseNotification decodes a Pub/Sub push body into a Gmail notification.
+func ParseNotification(body []byte) (*Notification, error) {
+	var env pushEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, fmt.Errorf("decode envelope: %w", err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(env.Message.Data)
+	if err != nil {
+		return nil, fmt.Errorf("decode message data: %w", err)
+	}
+
+	var n Notification
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, fmt.Errorf("decode notification: %w", err)
+	}
+	if n.EmailAddress == "" {
+		return nil, fmt.Errorf("notification has no emailAddress")
+	}
+
+	return &n, nil
+}
+
+func (h *PushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.Verify(r); err != nil {
+		log.Printf("mailsync: rejected push delivery: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	n, err := ParseNotification(body)
+	if err != nil {
+		// A malformed payload will never succeed; ack it so Pub/Sub stops
+		// redelivering.
+		log.Printf("mailsync: ignoring push delivery: %v", err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	accountIDs, err := accountsForAddress(n.EmailAddress)
+	if err != nil {
+		// Let Pub/Sub retry on our own failures.
+		http.Error(w, "Lookup failed", http.StatusInternalServerError)
+		return
+	}
+
+	for _, id := range accountIDs {
+		Trigger(id)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
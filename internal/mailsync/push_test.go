@@ -0,0 +1,191 @@
+package mailsync
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"mcp-gmail-server/internal/auth"
+	"mcp-gmail-server/internal/jwkstest"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testAudience       = "https://example.com/gmail/push"
+	testServiceAccount = "push@project.iam.gserviceaccount.com"
+)
+
+// pushHandler accepts tokens from signer, which stands in for Google.
+func pushHandler(signer *jwkstest.Signer) *PushHandler {
+	return &PushHandler{
+		Audience:       testAudience,
+		ServiceAccount: testServiceAccount,
+		Keys:           auth.NewJWKSCache(signer.URL),
+	}
+}
+
+// validClaims are the claims Pub/Sub sends, for tests to tweak.
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            "https://accounts.google.com",
+		"aud":            testAudience,
+		"email":          testServiceAccount,
+		"email_verified": true,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+}
+
+// pushBody wraps a Gmail notification the way Pub/Sub posts it.
+func pushBody(notification string) string {
+	envelope := map[string]any{
+		"message": map[string]string{
+			"data":      base64.StdEncoding.EncodeToString([]byte(notification)),
+			"messageId": "1",
+		},
+		"subscription": "projects/p/subscriptions/gmail-push",
+	}
+	body, _ := json.Marshal(envelope)
+	return string(body)
+}
+
+func pushRequest(token, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/gmail/push", strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestPushVerify(t *testing.T) {
+	signer := jwkstest.NewSigner(t)
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		kid    string
+		ok     bool
+	}{
+		{name: "valid", ok: true},
+		{name: "wrong audience", modify: func(c jwt.MapClaims) { c["aud"] = "https://other.example.com/push" }},
+		{name: "wrong issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "other service account", modify: func(c jwt.MapClaims) { c["email"] = "attacker@evil.iam.gserviceaccount.com" }},
+		{name: "unverified email", modify: func(c jwt.MapClaims) { c["email_verified"] = false }},
+		{name: "unknown kid", kid: "other-key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			kid := tt.kid
+			if kid == "" {
+				kid = jwkstest.KeyID
+			}
+
+			err := pushHandler(signer).Verify(pushRequest(jwkstest.SignWith(t, signer.Key, kid, claims), ""))
+			if tt.ok && err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("Verify accepted a bad token")
+			}
+		})
+	}
+}
+
+func TestPushVerifyRequiresServiceAccount(t *testing.T) {
+	signer := jwkstest.NewSigner(t)
+	h := pushHandler(signer)
+	h.ServiceAccount = ""
+
+	if err := h.Verify(pushRequest(signer.Sign(t, validClaims()), "")); err == nil {
+		t.Fatal("Verify accepted a token with no service account configured")
+	}
+}
+
+func TestPushVerifyMissingToken(t *testing.T) {
+	signer := jwkstest.NewSigner(t)
+	if err := pushHandler(signer).Verify(pushRequest("", "")); err == nil {
+		t.Fatal("Verify accepted a request without a token")
+	}
+}
+
+func TestParseNotification(t *testing.T) {
+	n, err := ParseNotification([]byte(pushBody(`{"emailAddress":"user@example.com","historyId":9876543210}`)))
+	if err != nil {
+		t.Fatalf("ParseNotification: %v", err)
+	}
+	if n.EmailAddress != "user@example.com" || n.HistoryID != 9876543210 {
+		t.Fatalf("got %+v", n)
+	}
+
+	bad := []struct {
+		name string
+		body string
+	}{
+		{"not json", `{`},
+		{"bad base64", `{"message":{"data":"%%%"}}`},
+		{"data not json", pushBody(`not json`)},
+		{"no email", pushBody(`{"historyId":1}`)},
+	}
+	for _, tt := range bad {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseNotification([]byte(tt.body)); err == nil {
+				t.Fatal("ParseNotification accepted a bad payload")
+			}
+		})
+	}
+}
+
+func TestPushServeHTTP(t *testing.T) {
+	signer := jwkstest.NewSigner(t)
+	token := signer.Sign(t, validClaims())
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{
+			name:   "wrong method",
+			req:    httptest.NewRequest(http.MethodGet, "/gmail/push", nil),
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "unsigned",
+			req:    pushRequest("", pushBody(`{"emailAddress":"user@example.com","historyId":1}`)),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "invalid body",
+			req:    pushRequest(token, `not json`),
+			status: http.StatusBadRequest,
+		},
+		{
+			// Acked so Pub/Sub stops redelivering it
+			name:   "malformed notification",
+			req:    pushRequest(token, pushBody(`{"historyId":1}`)),
+			status: http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			pushHandler(signer).ServeHTTP(w, tt.req)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package mailsync
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"mcp-gmail-server/internal/db"
+	"mcp-gmail-server/internal/gmail"
+)
+
+// State is the per-mailbox sync bookmark stored in sync_state.
+type State struct {
+	AccountID    int
+	UserID       int
+	EmailAddress string
+	HistoryID    uint64
+	Status       string
+	LastError    string
+	LastSyncedAt time.Time
+	// WatchExpiration is when the users.watch registration lapses.
+	WatchExpiration time.Time
+}
+
+func loadState(accountID, userID int) (*State, error) {
+	var st State
+	var email, lastError sql.NullString
+	var historyID sql.NullInt64
+	var lastSynced, watchExpiration sql.NullTime
+
+	err := db.DB.QueryRow(`
+		SELECT account_id, user_id, email_address, history_id, status, last_error, last_synced_at, watch_expiration
+		FROM sync_state
+		WHERE account_id = ?
+	`, accountID).Scan(&st.AccountID, &st.UserID, &email, &historyID, &st.Status, &lastError, &lastSynced, &watchExpiration)
+
+	if err == sql.ErrNoRows {
+		return &State{AccountID: accountID, UserID: userID, Status: "pending"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	st.EmailAddress = email.String
+	st.HistoryID = uint64(historyID.Int64)
+	st.LastError = lastError.String
+	if lastSynced.Valid {
+		st.LastSyncedAt = lastSynced.Time
+	}
+	if watchExpiration.Valid {
+		st.WatchExpiration = watchExpiration.Time
+	}
+
+	return &st, nil
+}
+
+func saveState(st *State) error {
+	_, err := db.DB.Exec(`
+		INSERT INTO sync_state (account_id, user_id, email_address, history_id, status, last_error, last_synced_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			email_address = VALUES(email_address),
+			history_id = VALUES(history_id),
+			status = VALUES(status),
+			last_error = VALUES(last_error),
+			last_synced_at = VALUES(last_synced_at)
+	`, st.AccountID, st.UserID, st.EmailAddress, st.HistoryID, st.Status, st.LastError, st.LastSyncedAt)
+	return err
+}
+
+func setStatus(st *State, status, lastError string) error {
+	_, err := db.DB.Exec(`
+		INSERT INTO sync_state (account_id, user_id, status, last_error)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE status = VALUES(status), last_error = VALUES(last_error)
+	`, st.AccountID, st.UserID, status, lastError)
+	return err
+}
+
+func saveWatchExpiration(accountID int, expiration time.Time) error {
+	_, err := db.DB.Exec(`UPDATE sync_state SET watch_expiration = ? WHERE account_id = ?`, expiration, accountID)
+	return err
+}
+
+// accountsForAddress maps a Gmail address from a push notification back to
+// the mirrored mailboxes for that address.
+func accountsForAddress(emailAddress string) ([]int, error) {
+	rows, err := db.DB.Query(`SELECT account_id FROM sync_state WHERE email_address = ?`, emailAddress)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func upsertMessage(st *State, e *gmail.Email) error {
+	_, err := db.DB.Exec(`
+		INSERT INTO mail_messages
+			(account_id, user_id, gmail_id, thread_id, from_addr, to_addr, subject, date_header,
+			 snippet, body, label_ids, internal_date, history_id,
+			 attachment_names, attachment_text)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			thread_id = VALUES(thread_id),
+			from_addr = VALUES(from_addr),
+			to_addr = VALUES(to_addr),
+			subject = VALUES(subject),
+			date_header = VALUES(date_header),
+			snippet = VALUES(snippet),
+			body = VALUES(body),
+			label_ids = VALUES(label_ids),
+			internal_date = VALUES(internal_date),
+			history_id = VALUES(history_id),
+			attachment_names = VALUES(attachment_names),
+			attachment_text = VALUES(attachment_text)
+	`,
+		st.AccountID, st.UserID, e.ID, e.ThreadID, e.From, e.To, e.Subject, e.Date,
+		e.Snippet, e.Body, strings.Join(e.LabelIDs, ","), e.InternalDate, e.HistoryID,
+		strings.Join(attachmentNames(e.Attachments), "\n"), e.AttachmentText,
+	)
+	return err
+}
+
+func updateLabels(accountID int, messageID string, labels []string) error {
+	_, err := db.DB.Exec(`
+		UPDATE mail_messages SET label_ids = ? WHERE account_id = ? AND gmail_id = ?
+	`, strings.Join(labels, ","), accountID, messageID)
+	return err
+}
+
+func deleteMessage(accountID int, messageID string) error {
+	_, err := db.DB.Exec(`DELETE FROM mail_messages WHERE account_id = ? AND gmail_id = ?`, accountID, messageID)
+	if err != nil {
+		return err
+	}
+	return clearFetchFailure(accountID, messageID)
+}
+
+func clearMirror(accountID int) error {
+	_, err := db.DB.Exec(`DELETE FROM mail_messages WHERE account_id = ?`, accountID)
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.Exec(`DELETE FROM sync_failures WHERE account_id = ?`, accountID)
+	return err
+}
+
+// maxFetchAttempts is how often a message Gmail won't return is retried
+// before the sync gives up on it.
+const maxFetchAttempts = 5
+
+// recordFetchFailure sets a message Gmail failed to return aside for a
+// while, longer after every attempt, and reports how many attempts it has
+// had.
+func recordFetchFailure(accountID int, messageID string, fetchErr error) (int, error) {
+	var attempts int
+	err := db.DB.QueryRow(`
+		SELECT attempts FROM sync_failures WHERE account_id = ? AND gmail_id = ?
+	`, accountID, messageID).Scan(&attempts)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	attempts++
+
+	_, err = db.DB.Exec(`
+		INSERT INTO sync_failures (account_id, gmail_id, attempts, last_error, retry_after)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE attempts = VALUES(attempts), last_error = VALUES(last_error),
+			retry_after = VALUES(retry_after)
+	`, accountID, messageID, attempts, fetchErr.Error(), time.Now().Add(fetchBackoff(attempts)).UTC())
+	return attempts, err
+}
+
+func clearFetchFailure(accountID int, messageID string) error {
+	_, err := db.DB.Exec(`DELETE FROM sync_failures WHERE account_id = ? AND gmail_id = ?`, accountID, messageID)
+	return err
+}
+
+// dueFetchFailures returns the failed messages whose backoff has passed and
+// that haven't used up their attempts.
+func dueFetchFailures(accountID int) ([]string, error) {
+	rows, err := db.DB.Query(`
+		SELECT gmail_id FROM sync_failures
+		WHERE account_id = ? AND attempts < ? AND retry_after <= ?
+		ORDER BY retry_after
+	`, accountID, maxFetchAttempts, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// fetchBackoff is 5m, 10m, 20m... for successive failures.
+func fetchBackoff(attempts int) time.Duration {
+	return min(5*time.Minute<<(attempts-1), 24*time.Hour)
+}
+
+func attachmentNames(attachments []gmail.Attachment) []string {
+	names := make([]string, 0, len(attachments))
+	for _, a := range attachments {
+		names = append(names, a.Filename)
+	}
+	return names
+}
+
+const messageColumns = `
+	gmail_id, thread_id, from_addr, to_addr, subject, date_header,
+	snippet, body, label_ids, internal_date, history_id,
+	attachment_names, attachment_text
+`
+
+func scanMessage(rows *sql.Rows) (gmail.Email, error) {
+	var e gmail.Email
+	var threadID, from, to, subject, date, snippet, body, labels sql.NullString
+	var names, attachmentText sql.NullString
+	var internalDate, historyID sql.NullInt64
+
+	err := rows.Scan(&e.ID, &threadID, &from, &to, &subject, &date,
+		&snippet, &body, &labels, &internalDate, &historyID,
+		&names, &attachmentText)
+	if err != nil {
+		return e, err
+	}
+
+	e.ThreadID = threadID.String
+	e.From = from.String
+	e.To = to.String
+	e.Subject = subject.String
+	e.Date = date.String
+	e.Snippet = snippet.String
+	e.Body = body.String
+	if labels.String != "" {
+		e.LabelIDs = strings.Split(labels.String, ",")
+	}
+	e.InternalDate = internalDate.Int64
+	e.HistoryID = uint64(historyID.Int64)
+	if names.String != "" {
+		for _, name := range strings.Split(names.String, "\n") {
+			e.Attachments = append(e.Attachments, gmail.Attachment{Filename: name})
+		}
+	}
+	e.AttachmentText = attachmentText.String
+
+	return e, nil
+}
+
+// GetState returns the sync bookmark for a mailbox.
+func GetState(accountID int) (*State, error) {
+	return loadState(accountID, 0)
+}
+
+// LoadMessages reads mirrored messages by Gmail ID, preserving the order of
+// ids. Unknown IDs are skipped.
+func LoadMessages(accountID int, ids []string) ([]gmail.Email, error) {
+	if len(ids) == 0 {
+		return []gmail.Email{}, nil
+	}
+
+	args := []interface{}{accountID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT `+messageColumns+`
+		FROM mail_messages
+		WHERE account_id = ? AND gmail_id IN (?`+strings.Repeat(",?", len(ids)-1)+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[string]gmail.Email, len(ids))
+	for rows.Next() {
+		e, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[e.ID] = e
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]gmail.Email, 0, len(ids))
+	for _, id := range ids {
+		if e, ok := byID[id]; ok {
+			result = append(result, e)
+		}
+	}
+
+	return result, nil
+}
+
+// ForEachMessage streams every mirrored message of a mailbox to fn.
+func ForEachMessage(accountID int, fn func(gmail.Email)) error {
+	rows, err := db.DB.Query(`
+		SELECT `+messageColumns+`
+		FROM mail_messages
+		WHERE account_id = ?
+	`, accountID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanMessage(rows)
+		if err != nil {
+			return err
+		}
+		fn(e)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,260 @@
+package mailsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"mcp-gmail-server/internal/auth"
+	"mcp-gmail-server/internal/gmail"
+
+	gmailapi "google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// backfillLimit bounds how many messages a full sync mirrors. Set by Start.
+var backfillLimit = 500
+
+// fetchBatchSize is how many messages are fetched and written per round
+// during a backfill, to keep memory bounded on large mailboxes.
+const fetchBatchSize = 100
+
+// SyncAccount brings the mirror for one mailbox up to date. The first run
+// (or a run after Gmail expired our history bookmark) does a bounded
+// backfill; later runs replay users.history.list from the stored historyId.
+func SyncAccount(ctx context.Context, account *auth.Account) error {
+	lock := accountLock(account.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	service, err := auth.NewGmailServiceForAccount(account)
+	if err != nil {
+		return err
+	}
+
+	st, err := loadState(account.ID, account.UserID)
+	if err != nil {
+		return err
+	}
+
+	if st.HistoryID == 0 {
+		err = fullSync(ctx, service, st)
+	} else {
+		err = incrementalSync(ctx, service, st)
+		if isHistoryExpired(err) {
+			log.Printf("mailsync: history %d too old for account %d, doing full resync", st.HistoryID, account.ID)
+			err = fullSync(ctx, service, st)
+		}
+	}
+
+	if err != nil {
+		if serr := setStatus(st, "error", err.Error()); serr != nil {
+			log.Printf("mailsync: failed to record error for account %d: %v", account.ID, serr)
+		}
+		return err
+	}
+
+	ensureWatch(ctx, service, st)
+	return nil
+}
+
+// isHistoryExpired reports whether history.list rejected our startHistoryId.
+// Gmail returns 404 once the bookmark falls outside its retention window.
+func isHistoryExpired(err error) bool {
+	return isNotFound(err)
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+func fullSync(ctx context.Context, service *gmailapi.Service, st *State) error {
+	if err := setStatus(st, "syncing", ""); err != nil {
+		return err
+	}
+
+	// Take the bookmark before listing so changes made during the backfill
+	// are replayed by the next incremental run.
+	profile, err := service.Users.GetProfile("me").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("get profile: %w", err)
+	}
+
+	ids, err := gmail.ListMessageIDs(service, "", backfillLimit)
+	if err != nil {
+		return fmt.Errorf("list messages: %w", err)
+	}
+
+	if err := clearMirror(st.AccountID); err != nil {
+		return err
+	}
+	publish(Event{Type: EventReset, AccountID: st.AccountID, UserID: st.UserID})
+
+	for start := 0; start < len(ids); start += fetchBatchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		end := start + fetchBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		if err := storeAdded(service, st, gmail.GetEmails(service, ids[start:end]), nil); err != nil {
+			return err
+		}
+	}
+
+	st.EmailAddress = profile.EmailAddress
+	st.HistoryID = profile.HistoryId
+	st.Status = "ok"
+	st.LastError = ""
+	st.LastSyncedAt = time.Now()
+
+	log.Printf("mailsync: backfilled %d messages for account %d", len(ids), st.AccountID)
+	return saveState(st)
+}
+
+// change is the net effect of the history records seen for one message.
+type change struct {
+	added     bool
+	deleted   bool
+	labels    []string
+	hasLabels bool
+}
+
+func incrementalSync(ctx context.Context, service *gmailapi.Service, st *State) error {
+	changes := make(map[string]*change)
+	var order []string
+	latest := st.HistoryID
+
+	get := func(id string) *change {
+		c, ok := changes[id]
+		if !ok {
+			c = &change{}
+			changes[id] = c
+			order = append(order, id)
+		}
+		return c
+	}
+
+	call := service.Users.History.List("me").StartHistoryId(st.HistoryID).MaxResults(500)
+	err := call.Pages(ctx, func(res *gmailapi.ListHistoryResponse) error {
+		for _, h := range res.History {
+			for _, m := range h.MessagesAdded {
+				c := get(m.Message.Id)
+				c.added, c.deleted = true, false
+			}
+			for _, m := range h.MessagesDeleted {
+				c := get(m.Message.Id)
+				c.added, c.deleted = false, true
+			}
+			for _, m := range h.LabelsAdded {
+				c := get(m.Message.Id)
+				c.labels, c.hasLabels = m.Message.LabelIds, true
+			}
+			for _, m := range h.LabelsRemoved {
+				c := get(m.Message.Id)
+				c.labels, c.hasLabels = m.Message.LabelIds, true
+			}
+		}
+		if res.HistoryId > latest {
+			latest = res.HistoryId
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var addedIDs []string
+	for _, id := range order {
+		c := changes[id]
+		switch {
+		case c.deleted:
+			if err := deleteMessage(st.AccountID, id); err != nil {
+				return err
+			}
+			publish(Event{Type: EventDeleted, AccountID: st.AccountID, UserID: st.UserID, MessageID: id})
+		case c.added:
+			addedIDs = append(addedIDs, id)
+		case c.hasLabels:
+			if err := updateLabels(st.AccountID, id, c.labels); err != nil {
+				return err
+			}
+			publish(Event{Type: EventLabelsChanged, AccountID: st.AccountID, UserID: st.UserID, MessageID: id, Labels: c.labels})
+		}
+	}
+
+	// Messages that failed to fetch on an earlier pass and are due a retry
+	retrying := make(map[string]bool)
+	due, err := dueFetchFailures(st.AccountID)
+	if err != nil {
+		return err
+	}
+	for _, id := range due {
+		if _, seen := changes[id]; !seen {
+			addedIDs = append(addedIDs, id)
+			retrying[id] = true
+		}
+	}
+
+	if err := storeAdded(service, st, gmail.GetEmails(service, addedIDs), retrying); err != nil {
+		return err
+	}
+
+	st.HistoryID = latest
+	st.Status = "ok"
+	st.LastError = ""
+	st.LastSyncedAt = time.Now()
+
+	return saveState(st)
+}
+
+// storeAdded mirrors newly added messages. retrying marks messages that are
+// in sync_failures, cleared once they are stored.
+func storeAdded(service *gmailapi.Service, st *State, emails []gmail.Email, retrying map[string]bool) error {
+	for i := range emails {
+		e := &emails[i]
+		// GetEmails leaves only the ID set when a fetch failed. Try once
+		// more to learn why: a message deleted again before we got to it is
+		// skipped, anything else is recorded and retried on a later pass
+		// with backoff, so one bad message can't hold the history cursor.
+		if e.ThreadID == "" {
+			email, err := gmail.GetEmail(service, e.ID)
+			if isNotFound(err) {
+				if err := clearFetchFailure(st.AccountID, e.ID); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				attempts, rerr := recordFetchFailure(st.AccountID, e.ID, err)
+				if rerr != nil {
+					return fmt.Errorf("fetch message %s: %w", e.ID, err)
+				}
+				if attempts >= maxFetchAttempts {
+					log.Printf("mailsync: giving up on message %s for account %d after %d attempts: %v", e.ID, st.AccountID, attempts, err)
+				} else {
+					log.Printf("mailsync: fetch failed for message %s in account %d (attempt %d): %v", e.ID, st.AccountID, attempts, err)
+				}
+				continue
+			}
+			e = &email
+		}
+		if err := upsertMessage(st, e); err != nil {
+			return err
+		}
+		if retrying[e.ID] {
+			if err := clearFetchFailure(st.AccountID, e.ID); err != nil {
+				return err
+			}
+		}
+		publish(Event{Type: EventAdded, AccountID: st.AccountID, UserID: st.UserID, MessageID: e.ID, Email: e})
+	}
+	return nil
+}
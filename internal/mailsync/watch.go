@@ -0,0 +1,44 @@
+package mailsync
+
+import (
+	"context"
+	"log"
+	"time"
+
+	gmailapi "google.golang.org/api/gmail/v1"
+)
+
+// pubSubTopic is the fully qualified Pub/Sub topic Gmail publishes mailbox
+// changes to (projects/<project>/topics/<topic>). Empty disables watches.
+var pubSubTopic string
+
+// watchRenewBefore is how long before expiry a watch is renewed. Gmail
+// watches last 7 days; Google recommends renewing daily.
+const watchRenewBefore = 6 * 24 * time.Hour
+
+// ensureWatch registers (or renews) the users.watch subscription for a
+// mailbox when it is missing or close to expiring.
+func ensureWatch(ctx context.Context, service *gmailapi.Service, st *State) {
+	if pubSubTopic == "" {
+		return
+	}
+	if time.Until(st.WatchExpiration) > watchRenewBefore {
+		return
+	}
+
+	res, err := service.Users.Watch("me", &gmailapi.WatchRequest{
+		TopicName: pubSubTopic,
+	}).Context(ctx).Do()
+	if err != nil {
+		log.Printf("mailsync: watch failed for account %d: %v", st.AccountID, err)
+		return
+	}
+
+	st.WatchExpiration = time.UnixMilli(res.Expiration)
+	if err := saveWatchExpiration(st.AccountID, st.WatchExpiration); err != nil {
+		log.Printf("mailsync: failed to store watch expiration for account %d: %v", st.AccountID, err)
+		return
+	}
+
+	log.Printf("mailsync: watch registered for account %d until %s", st.AccountID, st.WatchExpiration.Format(time.RFC3339))
+}
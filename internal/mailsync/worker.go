@@ -0,0 +1,107 @@
+package mailsync
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"mcp-gmail-server/internal/auth"
+)
+
+var (
+	locksMu sync.Mutex
+	locks   = make(map[int]*sync.Mutex)
+
+	triggers = make(chan int, 256)
+)
+
+// accountLock serialises syncs for a single mailbox so the periodic pass
+// and an on-demand trigger never replay the same history concurrently.
+func accountLock(accountID int) *sync.Mutex {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+
+	l, ok := locks[accountID]
+	if !ok {
+		l = &sync.Mutex{}
+		locks[accountID] = l
+	}
+	return l
+}
+
+// Trigger asks the worker to sync a mailbox as soon as possible. It never
+// blocks; if the queue is full the next periodic pass picks it up.
+func Trigger(accountID int) {
+	select {
+	case triggers <- accountID:
+	default:
+	}
+}
+
+// Options configures the sync worker.
+type Options struct {
+	Interval      time.Duration
+	BackfillLimit int
+	// PubSubTopic enables users.watch push notifications when set.
+	PubSubTopic string
+}
+
+// Start runs the sync worker until ctx is cancelled: a full pass over every
+// connected mailbox each interval, plus on-demand syncs requested via
+// Trigger (for example from PushHandler).
+func Start(ctx context.Context, opts Options) {
+	if opts.BackfillLimit > 0 {
+		backfillLimit = opts.BackfillLimit
+	}
+	pubSubTopic = opts.PubSubTopic
+
+	log.Printf("mailsync: worker started (interval %s, backfill limit %d)", opts.Interval, backfillLimit)
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	syncAll(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			syncAll(ctx)
+		case accountID := <-triggers:
+			// Mailboxes needing reconnection or owned by deactivated users
+			// are skipped, as in the periodic pass
+			account, err := auth.GetConnectedAccount(accountID)
+			if err == sql.ErrNoRows {
+				log.Printf("mailsync: ignoring trigger for account %d: not connected", accountID)
+				continue
+			}
+			if err != nil {
+				log.Printf("mailsync: failed to load account %d: %v", accountID, err)
+				continue
+			}
+			if err := SyncAccount(ctx, account); err != nil {
+				log.Printf("mailsync: sync failed for account %d: %v", accountID, err)
+			}
+		}
+	}
+}
+
+func syncAll(ctx context.Context) {
+	accounts, err := auth.ListConnectedAccounts()
+	if err != nil {
+		log.Printf("mailsync: failed to list connected accounts: %v", err)
+		return
+	}
+
+	for _, account := range accounts {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := SyncAccount(ctx, account); err != nil {
+			log.Printf("mailsync: sync failed for account %d: %v", account.ID, err)
+		}
+	}
+}
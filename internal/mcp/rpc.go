@@ -0,0 +1,135 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"mcp-gmail-server/internal/auth"
+)
+
+// ProtocolVersion is the MCP revision this server speaks.
+const ProtocolVersion = "2025-06-18"
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// IsNotification reports whether the request expects no response.
+func (r *RPCRequest) IsNotification() bool {
+	return len(r.ID) == 0
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// ParseError is the response for a body that isn't valid JSON-RPC.
+func ParseError() *RPCResponse {
+	return &RPCResponse{
+		JSONRPC: "2.0",
+		ID:      json.RawMessage("null"),
+		Error:   &RPCError{Code: rpcParseError, Message: "Parse error"},
+	}
+}
+
+// Dispatch handles one JSON-RPC request from an authenticated caller.
+func Dispatch(p *auth.Principal, req *RPCRequest) *RPCResponse {
+	resp := &RPCResponse{JSONRPC: "2.0", ID: req.ID}
+	if req.IsNotification() {
+		resp.ID = json.RawMessage("null")
+	}
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = &RPCError{Code: rpcInvalidRequest, Message: "Invalid request"}
+		return resp
+	}
+
+	switch req.Method {
+	case "initialize":
+		resp.Result = map[string]interface{}{
+			"protocolVersion": ProtocolVersion,
+			"capabilities": map[string]interface{}{
+				"tools": map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{
+				"name":    "mcp-gmail-server",
+				"version": "1.0.0",
+			},
+		}
+
+	case "ping", "notifications/initialized":
+		resp.Result = map[string]interface{}{}
+
+	case "tools/list":
+		resp.Result = map[string]interface{}{"tools": toolsFor(p)}
+
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.Name == "" {
+			resp.Error = &RPCError{Code: rpcInvalidParams, Message: "Invalid params"}
+			return resp
+		}
+
+		tool := findTool(params.Name)
+		if tool == nil {
+			resp.Error = &RPCError{Code: rpcInvalidParams, Message: fmt.Sprintf("Unknown tool: %s", params.Name)}
+			return resp
+		}
+
+		if !p.Can(tool.Scope) {
+			resp.Error = &RPCError{Code: rpcInvalidParams, Message: fmt.Sprintf("Tool %s requires the %q scope", tool.Name, tool.Scope)}
+			return resp
+		}
+
+		resp.Result = callTool(tool, &Call{User: p.User, APIKeyID: p.APIKeyID, Arguments: params.Arguments})
+
+	default:
+		resp.Error = &RPCError{Code: rpcMethodNotFound, Message: fmt.Sprintf("Method not found: %s", req.Method)}
+	}
+
+	return resp
+}
+
+// callTool runs a tool and wraps its output as MCP content. Tool failures
+// are reported in the result (isError) so the model can see them.
+func callTool(tool *Tool, call *Call) map[string]interface{} {
+	out, err := tool.Handler(call)
+	if err != nil {
+		return map[string]interface{}{
+			"content": []map[string]string{{"type": "text", "text": err.Error()}},
+			"isError": true,
+		}
+	}
+
+	text, err := json.Marshal(out)
+	if err != nil {
+		text = []byte(fmt.Sprintf("%v", out))
+	}
+
+	return map[string]interface{}{
+		"content": []map[string]string{{"type": "text", "text": string(text)}},
+		"isError": false,
+	}
+}
@@ -0,0 +1,98 @@
+package mcp
+
+import (
+	"encoding/json"
+	"sync"
+
+	"mcp-gmail-server/internal/auth"
+)
+
+// Call is a single tool invocation on behalf of an authenticated user.
+type Call struct {
+	User *auth.User
+	// APIKeyID is set when the caller authenticated with an API key.
+	APIKeyID  int
+	Arguments json.RawMessage
+}
+
+// Decode unmarshals the tool arguments into v.
+func (c *Call) Decode(v interface{}) error {
+	if len(c.Arguments) == 0 {
+		return nil
+	}
+	return json.Unmarshal(c.Arguments, v)
+}
+
+// Tool is an operation exposed to MCP clients through tools/list and
+// tools/call. InputSchema is a JSON Schema object. Callers without Scope
+// (see auth.Principal.Can) neither see nor call the tool.
+type Tool struct {
+	Name        string                                `json:"name"`
+	Description string                                `json:"description"`
+	InputSchema map[string]interface{}                `json:"inputSchema"`
+	Scope       string                                `json:"-"`
+	Handler     func(call *Call) (interface{}, error) `json:"-"`
+}
+
+var (
+	toolsMu sync.RWMutex
+	tools   []*Tool
+)
+
+// RegisterTool adds a tool, replacing any earlier tool with the same name.
+func RegisterTool(t *Tool) {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+
+	for i, existing := range tools {
+		if existing.Name == t.Name {
+			tools[i] = t
+			return
+		}
+	}
+	tools = append(tools, t)
+}
+
+// Tools returns the registered tools in registration order.
+func Tools() []*Tool {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+
+	return append([]*Tool(nil), tools...)
+}
+
+// toolsFor returns the tools the caller's scopes allow.
+func toolsFor(p *auth.Principal) []*Tool {
+	allowed := []*Tool{}
+	for _, t := range Tools() {
+		if p.Can(t.Scope) {
+			allowed = append(allowed, t)
+		}
+	}
+	return allowed
+}
+
+func findTool(name string) *Tool {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+
+	for _, t := range tools {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// ObjectSchema is a shorthand for a JSON Schema object with the given
+// properties.
+func ObjectSchema(properties map[string]interface{}, required ...string) map[string]interface{} {
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
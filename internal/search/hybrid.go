@@ -0,0 +1,87 @@
+package search
+
+import (
+	"log"
+	"sort"
+
+	"mcp-gmail-server/internal/gmail"
+	"mcp-gmail-server/internal/mailsync"
+	"mcp-gmail-server/internal/vector"
+
+	gmailapi "google.golang.org/api/gmail/v1"
+)
+
+// rrfK dampens the weight of top ranks in reciprocal rank fusion; 60 is
+// the value from the original RRF paper.
+const rrfK = 60
+
+// FuseRRF merges ranked ID lists with reciprocal rank fusion and returns at
+// most limit IDs.
+func FuseRRF(limit int, lists ...[]string) []string {
+	scores := make(map[string]float64)
+	var order []string
+
+	for _, list := range lists {
+		for rank, id := range list {
+			if _, seen := scores[id]; !seen {
+				order = append(order, id)
+			}
+			scores[id] += 1.0 / float64(rrfK+rank+1)
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+
+	if limit > 0 && len(order) > limit {
+		order = order[:limit]
+	}
+	return order
+}
+
+// Hybrid combines the Gmail query with semantic matches for the intent.
+// Keyword results come from the Gmail API; semantic results come from the
+// mailbox's embedded mirror. If semantic search is unavailable it degrades
+// to the keyword results alone.
+func Hybrid(service *gmailapi.Service, accountID int, intent, gmailQuery string, limit int) ([]gmail.Email, error) {
+	keywordIDs, err := gmail.ListMessageIDs(service, gmailQuery, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var semanticIDs []string
+	hits, err := vector.Search(accountID, intent, limit)
+	if err != nil {
+		log.Printf("Semantic search unavailable for account %d: %v", accountID, err)
+	}
+	for _, h := range hits {
+		semanticIDs = append(semanticIDs, h.MessageID)
+	}
+
+	ids := FuseRRF(limit, keywordIDs, semanticIDs)
+
+	// Prefer the mirror; fetch whatever it does not have from Gmail.
+	mirrored, err := mailsync.LoadMessages(accountID, ids)
+	if err != nil {
+		mirrored = nil
+	}
+	byID := make(map[string]gmail.Email, len(ids))
+	for _, e := range mirrored {
+		byID[e.ID] = e
+	}
+
+	var missing []string
+	for _, id := range ids {
+		if _, ok := byID[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	for _, e := range gmail.GetEmails(service, missing) {
+		byID[e.ID] = e
+	}
+
+	emails := make([]gmail.Email, 0, len(ids))
+	for _, id := range ids {
+		emails = append(emails, byID[id])
+	}
+	return emails, nil
+}
@@ -0,0 +1,305 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"mcp-gmail-server/internal/gmail"
+)
+
+type field int
+
+const (
+	fieldSubject field = iota
+	fieldFrom
+	fieldTo
+	fieldBody
+	fieldAttachment
+	numFields
+)
+
+// allFields is the default scope of a bare search term.
+var allFields = []field{fieldSubject, fieldFrom, fieldTo, fieldBody, fieldAttachment}
+
+// fieldWeights boosts matches in short, descriptive fields over the body.
+var fieldWeights = [numFields]float64{
+	fieldSubject:    2.0,
+	fieldFrom:       1.5,
+	fieldTo:         1.0,
+	fieldBody:       1.0,
+	fieldAttachment: 0.7,
+}
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+type document struct {
+	id           string
+	internalDate int64
+	labels       map[string]bool
+	filenames    []string
+	// terms holds token positions per field, used for phrase matching and
+	// to unlink the document from postings on removal.
+	terms   [numFields]map[string][]int
+	lengths [numFields]int
+}
+
+// Index is an in-memory inverted index over one user's mirrored mail.
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[string]*document
+	postings [numFields]map[string]map[string]struct{}
+	totalLen [numFields]int
+}
+
+func NewIndex() *Index {
+	idx := &Index{docs: make(map[string]*document)}
+	for f := range idx.postings {
+		idx.postings[f] = make(map[string]map[string]struct{})
+	}
+	return idx
+}
+
+// Hit is a single search result.
+type Hit struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// Len returns the number of indexed messages.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Add indexes a message, replacing any previous version of it.
+func (idx *Index) Add(e *gmail.Email) {
+	doc := buildDocument(e)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.insert(doc)
+}
+
+func buildDocument(e *gmail.Email) *document {
+	doc := &document{
+		id:           e.ID,
+		internalDate: e.InternalDate,
+		labels:       make(map[string]bool, len(e.LabelIDs)),
+	}
+	for _, l := range e.LabelIDs {
+		doc.labels[strings.ToUpper(l)] = true
+	}
+	for _, a := range e.Attachments {
+		doc.filenames = append(doc.filenames, strings.ToLower(a.Filename))
+	}
+
+	var attachmentText strings.Builder
+	for _, name := range doc.filenames {
+		attachmentText.WriteString(name)
+		attachmentText.WriteString(" ")
+	}
+	attachmentText.WriteString(e.AttachmentText)
+
+	texts := [numFields]string{
+		fieldSubject:    e.Subject,
+		fieldFrom:       e.From,
+		fieldTo:         e.To,
+		fieldBody:       e.Body,
+		fieldAttachment: attachmentText.String(),
+	}
+
+	for f, text := range texts {
+		tokens := tokenize(text)
+		positions := make(map[string][]int)
+		for i, tok := range tokens {
+			positions[tok] = append(positions[tok], i)
+		}
+		doc.terms[f] = positions
+		doc.lengths[f] = len(tokens)
+	}
+
+	return doc
+}
+
+// addLocked is Add for callers already holding idx.mu.
+func (idx *Index) addLocked(e *gmail.Email) {
+	idx.insert(buildDocument(e))
+}
+
+func (idx *Index) insert(doc *document) {
+	idx.remove(doc.id)
+
+	idx.docs[doc.id] = doc
+	for f := field(0); f < numFields; f++ {
+		for term := range doc.terms[f] {
+			set, ok := idx.postings[f][term]
+			if !ok {
+				set = make(map[string]struct{})
+				idx.postings[f][term] = set
+			}
+			set[doc.id] = struct{}{}
+		}
+		idx.totalLen[f] += doc.lengths[f]
+	}
+}
+
+// Remove drops a message from the index.
+func (idx *Index) Remove(id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+}
+
+func (idx *Index) remove(id string) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+
+	for f := field(0); f < numFields; f++ {
+		for term := range doc.terms[f] {
+			set := idx.postings[f][term]
+			delete(set, id)
+			if len(set) == 0 {
+				delete(idx.postings[f], term)
+			}
+		}
+		idx.totalLen[f] -= doc.lengths[f]
+	}
+	delete(idx.docs, id)
+}
+
+// SetLabels updates the label set of an indexed message.
+func (idx *Index) SetLabels(id string, labels []string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	doc.labels = make(map[string]bool, len(labels))
+	for _, l := range labels {
+		doc.labels[strings.ToUpper(l)] = true
+	}
+}
+
+// Search runs a Gmail-style query and returns up to limit hits ordered by
+// BM25 score. Queries made only of filters (no free text) are ordered
+// newest first, like Gmail.
+func (idx *Index) Search(query string, limit int) ([]Hit, error) {
+	q, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	terms := q.positiveTerms()
+
+	// Candidates are docs sharing at least one token with a positive term;
+	// pure filter queries have to look at everything.
+	candidates := idx.docs
+	if requiresTerm(q.root) {
+		candidates = make(map[string]*document)
+		for _, t := range terms {
+			for _, f := range t.fields {
+				for id := range idx.postings[f][t.tokens[0]] {
+					candidates[id] = idx.docs[id]
+				}
+			}
+		}
+	}
+
+	var hits []Hit
+	for _, doc := range candidates {
+		if !q.match(doc) {
+			continue
+		}
+
+		score := 0.0
+		for _, t := range terms {
+			score += idx.score(doc, t)
+		}
+		hits = append(hits, Hit{ID: doc.id, Score: score})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return idx.docs[hits[i].ID].internalDate > idx.docs[hits[j].ID].internalDate
+	})
+
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	return hits, nil
+}
+
+// score is the field-weighted BM25 contribution of one term or phrase.
+func (idx *Index) score(doc *document, t *termNode) float64 {
+	n := float64(len(idx.docs))
+	total := 0.0
+
+	for _, f := range t.fields {
+		tf := float64(phraseCount(doc.terms[f], t.tokens))
+		if tf == 0 {
+			continue
+		}
+
+		// Approximate phrase document frequency by its rarest token.
+		df := math.MaxFloat64
+		for _, tok := range t.tokens {
+			df = math.Min(df, float64(len(idx.postings[f][tok])))
+		}
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		avgLen := float64(idx.totalLen[f]) / n
+		if avgLen == 0 {
+			avgLen = 1
+		}
+		norm := bm25K1 * (1 - bm25B + bm25B*float64(doc.lengths[f])/avgLen)
+
+		total += fieldWeights[f] * idf * tf * (bm25K1 + 1) / (tf + norm)
+	}
+
+	return total
+}
+
+// phraseCount returns how often tokens occur consecutively in a field.
+func phraseCount(positions map[string][]int, tokens []string) int {
+	first := positions[tokens[0]]
+	if len(tokens) == 1 {
+		return len(first)
+	}
+
+	count := 0
+	for _, start := range first {
+		matched := true
+		for i := 1; i < len(tokens); i++ {
+			if !containsInt(positions[tokens[i]], start+i) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			count++
+		}
+	}
+	return count
+}
+
+func containsInt(sorted []int, v int) bool {
+	i := sort.SearchInts(sorted, v)
+	return i < len(sorted) && sorted[i] == v
+}
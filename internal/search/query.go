@@ -0,0 +1,548 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrUnsupported is returned for queries that rely on Gmail-side semantics
+// the local index cannot reproduce (user label names, size filters, ...).
+// Callers should fall back to the Gmail API for those.
+var ErrUnsupported = errors.New("query not supported by local index")
+
+type node interface {
+	match(doc *document) bool
+}
+
+// termNode matches a word, or a phrase when it has several tokens.
+type termNode struct {
+	fields []field
+	tokens []string
+}
+
+func (t *termNode) match(doc *document) bool {
+	for _, f := range t.fields {
+		if phraseCount(doc.terms[f], t.tokens) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type filterNode struct {
+	fn func(doc *document) bool
+}
+
+func (n *filterNode) match(doc *document) bool { return n.fn(doc) }
+
+type notNode struct{ child node }
+
+func (n *notNode) match(doc *document) bool { return !n.child.match(doc) }
+
+type andNode struct{ children []node }
+
+func (n *andNode) match(doc *document) bool {
+	for _, c := range n.children {
+		if !c.match(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode struct{ children []node }
+
+func (n *orNode) match(doc *document) bool {
+	for _, c := range n.children {
+		if c.match(doc) {
+			return true
+		}
+	}
+	return false
+}
+
+// Query is a parsed Gmail search query.
+type Query struct {
+	root node
+	// Like Gmail, trash and spam are excluded unless asked for.
+	includeTrash bool
+	includeSpam  bool
+}
+
+func (q *Query) match(doc *document) bool {
+	if doc.labels["TRASH"] && !q.includeTrash {
+		return false
+	}
+	if doc.labels["SPAM"] && !q.includeSpam {
+		return false
+	}
+	return q.root.match(doc)
+}
+
+// positiveTerms collects the terms that contribute to ranking, i.e. those
+// not under a negation.
+func (q *Query) positiveTerms() []*termNode {
+	var out []*termNode
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case *termNode:
+			out = append(out, n)
+		case *andNode:
+			for _, c := range n.children {
+				walk(c)
+			}
+		case *orNode:
+			for _, c := range n.children {
+				walk(c)
+			}
+		}
+	}
+	walk(q.root)
+	return out
+}
+
+// requiresTerm reports whether every match must contain a positive term,
+// which lets Search start from postings instead of scanning all documents.
+func requiresTerm(n node) bool {
+	switch n := n.(type) {
+	case *termNode:
+		return true
+	case *andNode:
+		for _, c := range n.children {
+			if requiresTerm(c) {
+				return true
+			}
+		}
+		return false
+	case *orNode:
+		for _, c := range n.children {
+			if !requiresTerm(c) {
+				return false
+			}
+		}
+		return len(n.children) > 0
+	}
+	return false
+}
+
+// ---------------------------------------------------------------------------
+// Lexer
+
+type tokKind int
+
+const (
+	tWord tokKind = iota
+	tPhrase
+	tLParen
+	tRParen
+	tLBrace
+	tRBrace
+	tOr
+	tNot
+)
+
+type lexTok struct {
+	kind tokKind
+	text string
+}
+
+func lex(query string) []lexTok {
+	var toks []lexTok
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			toks = append(toks, lexTok{kind: tLParen})
+			i++
+		case r == ')':
+			toks = append(toks, lexTok{kind: tRParen})
+			i++
+		case r == '{':
+			toks = append(toks, lexTok{kind: tLBrace})
+			i++
+		case r == '}':
+			toks = append(toks, lexTok{kind: tRBrace})
+			i++
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]):
+			toks = append(toks, lexTok{kind: tNot})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			toks = append(toks, lexTok{kind: tPhrase, text: string(runes[i+1 : end])})
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune(`(){}"`, runes[end]) {
+				end++
+			}
+			word := string(runes[i:end])
+			switch word {
+			case "OR", "|":
+				toks = append(toks, lexTok{kind: tOr})
+			case "AND":
+				// Implicit anyway
+			default:
+				toks = append(toks, lexTok{kind: tWord, text: word})
+			}
+			i = end
+		}
+	}
+
+	return toks
+}
+
+// ---------------------------------------------------------------------------
+// Parser
+
+var addressOperators = map[string][]field{
+	"from":    {fieldFrom},
+	"to":      {fieldTo},
+	"cc":      {fieldTo},
+	"bcc":     {fieldTo},
+	"subject": {fieldSubject},
+}
+
+// Gmail operators we recognise but cannot evaluate locally.
+var unsupportedOperators = map[string]bool{
+	"larger": true, "smaller": true, "size": true, "list": true,
+	"deliveredto": true, "rfc822msgid": true, "around": true, "lang": true,
+}
+
+var systemLabels = map[string]string{
+	"inbox": "INBOX", "sent": "SENT", "starred": "STARRED", "unread": "UNREAD",
+	"important": "IMPORTANT", "trash": "TRASH", "spam": "SPAM", "draft": "DRAFT",
+	"drafts": "DRAFT", "chat": "CHAT", "chats": "CHAT",
+}
+
+var categoryLabels = map[string]string{
+	"primary":    "CATEGORY_PERSONAL",
+	"social":     "CATEGORY_SOCIAL",
+	"promotions": "CATEGORY_PROMOTIONS",
+	"updates":    "CATEGORY_UPDATES",
+	"forums":     "CATEGORY_FORUMS",
+}
+
+type parser struct {
+	toks  []lexTok
+	pos   int
+	query *Query
+	now   time.Time
+}
+
+// Parse turns a Gmail search query into an executable Query.
+func Parse(query string) (*Query, error) {
+	p := &parser{toks: lex(query), query: &Query{}, now: time.Now()}
+
+	children, err := p.parseSeq(allFields, -1)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected token in query")
+	}
+
+	p.query.root = &andNode{children: children}
+	return p.query, nil
+}
+
+func (p *parser) peek() (lexTok, bool) {
+	if p.pos >= len(p.toks) {
+		return lexTok{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+// parseSeq parses implicit-AND items until the closing token (or the end).
+func (p *parser) parseSeq(fields []field, closing tokKind) ([]node, error) {
+	var nodes []node
+	for {
+		tok, ok := p.peek()
+		if !ok {
+			if closing >= 0 {
+				return nil, fmt.Errorf("unbalanced brackets in query")
+			}
+			return nodes, nil
+		}
+		if closing >= 0 && tok.kind == closing {
+			p.pos++
+			return nodes, nil
+		}
+		if tok.kind == tRParen || tok.kind == tRBrace {
+			return nil, fmt.Errorf("unbalanced brackets in query")
+		}
+
+		n, err := p.parseOr(fields)
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+	}
+}
+
+func (p *parser) parseOr(fields []field) (node, error) {
+	first, err := p.parseUnary(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	children := []node{first}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind != tOr {
+			break
+		}
+		p.pos++
+		next, err := p.parseUnary(fields)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, next)
+	}
+
+	var kept []node
+	for _, c := range children {
+		if c != nil {
+			kept = append(kept, c)
+		}
+	}
+	switch len(kept) {
+	case 0:
+		return nil, nil
+	case 1:
+		return kept[0], nil
+	}
+	return &orNode{children: kept}, nil
+}
+
+func (p *parser) parseUnary(fields []field) (node, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("query ends unexpectedly")
+	}
+	if tok.kind == tNot {
+		p.pos++
+		child, err := p.parseUnary(fields)
+		if err != nil || child == nil {
+			return nil, err
+		}
+		return &notNode{child: child}, nil
+	}
+	return p.parsePrimary(fields)
+}
+
+func (p *parser) parsePrimary(fields []field) (node, error) {
+	tok, _ := p.peek()
+	p.pos++
+
+	switch tok.kind {
+	case tLParen:
+		children, err := p.parseSeq(fields, tRParen)
+		if err != nil {
+			return nil, err
+		}
+		return &andNode{children: children}, nil
+	case tLBrace:
+		children, err := p.parseSeq(fields, tRBrace)
+		if err != nil {
+			return nil, err
+		}
+		return &orNode{children: children}, nil
+	case tPhrase:
+		return termFor(fields, tok.text), nil
+	case tWord:
+		return p.parseWord(fields, tok.text)
+	case tOr:
+		return nil, fmt.Errorf("OR without left operand")
+	}
+
+	return nil, fmt.Errorf("unexpected token in query")
+}
+
+func termFor(fields []field, text string) node {
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return nil
+	}
+	return &termNode{fields: fields, tokens: tokens}
+}
+
+func (p *parser) parseWord(fields []field, word string) (node, error) {
+	colon := strings.Index(word, ":")
+	if colon <= 0 {
+		return termFor(fields, word), nil
+	}
+
+	op := strings.ToLower(word[:colon])
+	value := word[colon+1:]
+
+	if opFields, ok := addressOperators[op]; ok {
+		if value != "" {
+			return termFor(opFields, value), nil
+		}
+		// from:"Jane Doe", subject:(quarterly report)
+		return p.parsePrimary(opFields)
+	}
+
+	if unsupportedOperators[op] {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, op)
+	}
+
+	if !isFilterOperator(op) {
+		// Not an operator at all, e.g. "10:30"
+		return termFor(fields, word), nil
+	}
+
+	if value == "" {
+		tok, ok := p.peek()
+		if !ok || tok.kind != tPhrase {
+			return nil, fmt.Errorf("missing value for %s:", op)
+		}
+		p.pos++
+		value = tok.text
+	}
+
+	return p.filter(op, strings.ToLower(value))
+}
+
+func isFilterOperator(op string) bool {
+	switch op {
+	case "label", "in", "is", "has", "filename", "category",
+		"newer_than", "older_than", "after", "before", "newer", "older":
+		return true
+	}
+	return false
+}
+
+func hasLabel(label string) node {
+	return &filterNode{fn: func(doc *document) bool { return doc.labels[label] }}
+}
+
+func (p *parser) filter(op, value string) (node, error) {
+	switch op {
+	case "label", "in":
+		if op == "in" && value == "anywhere" {
+			p.query.includeTrash, p.query.includeSpam = true, true
+			return nil, nil
+		}
+		label, ok := systemLabels[value]
+		if !ok {
+			// User label names would need the account's label list.
+			return nil, fmt.Errorf("%w: %s:%s", ErrUnsupported, op, value)
+		}
+		switch label {
+		case "TRASH":
+			p.query.includeTrash = true
+		case "SPAM":
+			p.query.includeSpam = true
+		}
+		return hasLabel(label), nil
+
+	case "category":
+		label, ok := categoryLabels[value]
+		if !ok {
+			return nil, fmt.Errorf("%w: category:%s", ErrUnsupported, value)
+		}
+		return hasLabel(label), nil
+
+	case "is":
+		switch value {
+		case "unread", "starred", "important":
+			return hasLabel(strings.ToUpper(value)), nil
+		case "read":
+			return &notNode{child: hasLabel("UNREAD")}, nil
+		}
+		return nil, fmt.Errorf("%w: is:%s", ErrUnsupported, value)
+
+	case "has":
+		if value == "attachment" {
+			return &filterNode{fn: func(doc *document) bool { return len(doc.filenames) > 0 }}, nil
+		}
+		return nil, fmt.Errorf("%w: has:%s", ErrUnsupported, value)
+
+	case "filename":
+		return &filterNode{fn: func(doc *document) bool {
+			for _, name := range doc.filenames {
+				if name == value || strings.HasSuffix(name, "."+value) || strings.Contains(name, value) {
+					return true
+				}
+			}
+			return false
+		}}, nil
+
+	case "newer_than", "older_than":
+		d, err := parseRelative(value)
+		if err != nil {
+			return nil, err
+		}
+		cutoff := p.now.Add(-d).UnixMilli()
+		if op == "newer_than" {
+			return &filterNode{fn: func(doc *document) bool { return doc.internalDate >= cutoff }}, nil
+		}
+		return &filterNode{fn: func(doc *document) bool { return doc.internalDate < cutoff }}, nil
+
+	case "after", "newer", "before", "older":
+		t, err := parseDate(value)
+		if err != nil {
+			return nil, err
+		}
+		cutoff := t.UnixMilli()
+		if op == "after" || op == "newer" {
+			return &filterNode{fn: func(doc *document) bool { return doc.internalDate >= cutoff }}, nil
+		}
+		return &filterNode{fn: func(doc *document) bool { return doc.internalDate < cutoff }}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupported, op)
+}
+
+// parseRelative parses Gmail's newer_than/older_than values: 2d, 3m, 1y.
+func parseRelative(value string) (time.Duration, error) {
+	if len(value) < 2 {
+		return 0, fmt.Errorf("invalid relative date %q", value)
+	}
+
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid relative date %q", value)
+	}
+
+	day := 24 * time.Hour
+	switch value[len(value)-1] {
+	case 'd':
+		return time.Duration(n) * day, nil
+	case 'm':
+		return time.Duration(n) * 30 * day, nil
+	case 'y':
+		return time.Duration(n) * 365 * day, nil
+	}
+	return 0, fmt.Errorf("invalid relative date %q", value)
+}
+
+// parseDate accepts the formats Gmail does for after:/before:, including
+// Unix timestamps in seconds.
+func parseDate(value string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+
+	for _, layout := range []string{"2006/01/02", "2006/1/2", "2006-01-02", "2006-1-2"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
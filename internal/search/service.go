@@ -0,0 +1,135 @@
+package search
+
+import (
+	"errors"
+	"sync"
+
+	"mcp-gmail-server/internal/gmail"
+	"mcp-gmail-server/internal/mailsync"
+)
+
+// ErrNotSynced is returned when a mailbox has not been mirrored yet.
+var ErrNotSynced = errors.New("mailbox not synced yet")
+
+// indexEntry is a mailbox's index. ready closes once it is loaded, or
+// failed to load with err.
+type indexEntry struct {
+	idx   *Index
+	ready chan struct{}
+	err   error
+}
+
+var (
+	indexesMu sync.Mutex
+	indexes   = make(map[int]*indexEntry)
+)
+
+// Attach keeps the per-mailbox indexes in step with the sync worker. Call
+// it once at startup, before the worker starts.
+func Attach() {
+	mailsync.Subscribe(func(ev mailsync.Event) {
+		indexesMu.Lock()
+		entry, loaded := indexes[ev.AccountID]
+		if ev.Type == mailsync.EventReset {
+			delete(indexes, ev.AccountID)
+		}
+		indexesMu.Unlock()
+
+		// Indexes are built lazily from the mirror; nothing to update until
+		// someone has searched this mailbox. An index still loading holds
+		// its lock, so these wait for the load.
+		if !loaded {
+			return
+		}
+
+		idx := entry.idx
+		switch ev.Type {
+		case mailsync.EventAdded:
+			idx.Add(ev.Email)
+		case mailsync.EventDeleted:
+			idx.Remove(ev.MessageID)
+		case mailsync.EventLabelsChanged:
+			idx.SetLabels(ev.MessageID, ev.Labels)
+		}
+	})
+}
+
+// ForAccount returns the mailbox's index, building it from the mirror on
+// first use. Callers arriving during the build wait for it, and get its
+// error if it fails.
+func ForAccount(accountID int) (*Index, error) {
+	indexesMu.Lock()
+	if entry, ok := indexes[accountID]; ok {
+		indexesMu.Unlock()
+		<-entry.ready
+		if entry.err != nil {
+			return nil, entry.err
+		}
+		return entry.idx, nil
+	}
+
+	st, err := mailsync.GetState(accountID)
+	if err != nil {
+		indexesMu.Unlock()
+		return nil, err
+	}
+	if st.HistoryID == 0 {
+		indexesMu.Unlock()
+		return nil, ErrNotSynced
+	}
+
+	// Register before loading so sync events queue up behind the load
+	// instead of being dropped.
+	entry := &indexEntry{idx: NewIndex(), ready: make(chan struct{})}
+	entry.idx.mu.Lock()
+	indexes[accountID] = entry
+	indexesMu.Unlock()
+
+	loadErr := mailsync.ForEachMessage(accountID, func(e gmail.Email) {
+		entry.idx.addLocked(&e)
+	})
+	entry.idx.mu.Unlock()
+
+	if loadErr != nil {
+		entry.err = loadErr
+		indexesMu.Lock()
+		if indexes[accountID] == entry {
+			delete(indexes, accountID)
+		}
+		indexesMu.Unlock()
+	}
+	close(entry.ready)
+
+	if loadErr != nil {
+		return nil, loadErr
+	}
+	return entry.idx, nil
+}
+
+// Forget drops the mailbox's index, e.g. once the mailbox is disconnected.
+func Forget(accountID int) {
+	indexesMu.Lock()
+	delete(indexes, accountID)
+	indexesMu.Unlock()
+}
+
+// Run executes a Gmail-style query against a mailbox's local index and
+// returns the matching messages from the mirror, best match first.
+func Run(accountID int, query string, limit int) ([]gmail.Email, error) {
+	idx, err := ForAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	hits, err := idx.Search(query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+
+	return mailsync.LoadMessages(accountID, ids)
+}
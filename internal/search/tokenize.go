@@ -0,0 +1,16 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// tokenize lowercases text and splits it on anything that is not a letter
+// or digit. Email addresses therefore become their parts
+// ("alice@example.com" -> alice, example, com), which lets address filters
+// be matched as phrases.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,260 @@
+// Package secrets implements envelope encryption for credentials stored in
+// the database. Each row gets its own random data key (DEK); the DEK is
+// stored next to the row, wrapped (AES-GCM) by a key-encryption key (KEK)
+// that never touches the database. Column values are sealed with the DEK.
+//
+// KEKs come from TOKEN_ENCRYPTION_KEYS (or a file named by
+// TOKEN_ENCRYPTION_KEYS_FILE) as a comma or newline separated list of
+// id:base64key entries. The first entry wraps new data keys; the others
+// only unwrap. To rotate: put a new key first and deploy, run
+// `secrets rotate` to re-wrap every data key, then drop the old key.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sealedPrefix marks an encrypted column value. Values without it are
+// legacy plaintext and are returned as-is.
+const sealedPrefix = "enc:v1:"
+
+var (
+	ErrUnknownKey = errors.New("data key wrapped with an unknown encryption key")
+	ErrNoDataKey  = errors.New("sealed value without a data key")
+)
+
+type kek struct {
+	id  string
+	aes cipher.AEAD
+}
+
+var (
+	current *kek
+	keyring = map[string]*kek{}
+)
+
+// LoadFromEnv loads the KEKs from the environment. Encryption stays
+// disabled when none are configured.
+func LoadFromEnv() error {
+	spec := os.Getenv("TOKEN_ENCRYPTION_KEYS")
+	if path := os.Getenv("TOKEN_ENCRYPTION_KEYS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read encryption keys: %w", err)
+		}
+		spec = string(data)
+	}
+	if strings.TrimSpace(spec) == "" {
+		return nil
+	}
+	return Init(spec)
+}
+
+// Init parses a key list (see package doc) and enables encryption.
+func Init(spec string) error {
+	entries := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+
+	ring := map[string]*kek{}
+	var first *kek
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return fmt.Errorf("encryption key entry must be id:base64key")
+		}
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(raw) != 32 {
+			return fmt.Errorf("encryption key %q must be 32 bytes, base64 encoded", id)
+		}
+
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return err
+		}
+		k := &kek{id: id, aes: aead}
+		ring[id] = k
+		if first == nil {
+			first = k
+		}
+	}
+	if first == nil {
+		return errors.New("no encryption keys given")
+	}
+
+	current, keyring = first, ring
+	return nil
+}
+
+// Enabled reports whether a KEK is configured.
+func Enabled() bool {
+	return current != nil
+}
+
+// CurrentKeyID is the ID of the KEK that wraps new data keys.
+func CurrentKeyID() string {
+	if current == nil {
+		return ""
+	}
+	return current.id
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext, aad []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out := aead.Seal(nonce, nonce, plaintext, aad)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+func open(aead cipher.AEAD, encoded string, aad []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ct := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ct, aad)
+}
+
+// wrap encrypts a DEK with the current KEK as "kekID:ciphertext".
+func wrap(dek []byte) (string, error) {
+	sealed, err := seal(current.aes, dek, []byte(current.id))
+	if err != nil {
+		return "", err
+	}
+	return current.id + ":" + sealed, nil
+}
+
+func unwrap(wrapped string) ([]byte, error) {
+	id, sealed, ok := strings.Cut(wrapped, ":")
+	if !ok {
+		return nil, errors.New("malformed data key")
+	}
+	k, ok := keyring[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	return open(k.aes, sealed, []byte(id))
+}
+
+// Rewrap re-encrypts a wrapped DEK under the current KEK. changed is false
+// when it already uses the current KEK.
+func Rewrap(wrapped string) (rewrapped string, changed bool, err error) {
+	if current == nil {
+		return "", false, errors.New("encryption is not configured")
+	}
+	if strings.HasPrefix(wrapped, current.id+":") {
+		return wrapped, false, nil
+	}
+
+	dek, err := unwrap(wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	rewrapped, err = wrap(dek)
+	return rewrapped, err == nil, err
+}
+
+// Box seals and opens the column values of one row.
+type Box struct {
+	aead cipher.AEAD
+	// Wrapped is the row's DEK as stored in its data_key column; empty when
+	// encryption is disabled and the row has none.
+	Wrapped string
+}
+
+// NewBox creates a fresh data key. With encryption disabled it returns a
+// pass-through box.
+func NewBox() (*Box, error) {
+	if current == nil {
+		return &Box{}, nil
+	}
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	wrapped, err := wrap(dek)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead, Wrapped: wrapped}, nil
+}
+
+// OpenBox unwraps a row's stored data key. An empty key gives a
+// pass-through box that can still read legacy plaintext.
+func OpenBox(wrapped string) (*Box, error) {
+	if wrapped == "" {
+		return &Box{}, nil
+	}
+	dek, err := unwrap(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead, Wrapped: wrapped}, nil
+}
+
+// Seal encrypts a column value; column is bound as associated data so a
+// value can't be moved to another column. Empty values stay empty.
+func (b *Box) Seal(column, plaintext string) (string, error) {
+	if plaintext == "" || b.aead == nil {
+		return plaintext, nil
+	}
+	sealed, err := seal(b.aead, []byte(plaintext), []byte(column))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + sealed, nil
+}
+
+// Open decrypts a column value. Legacy plaintext is returned unchanged.
+func (b *Box) Open(column, value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	if b.aead == nil {
+		return "", ErrNoDataKey
+	}
+	plaintext, err := open(b.aead, strings.TrimPrefix(value, sealedPrefix), []byte(column))
+	if err != nil {
+		return "", fmt.Errorf("decrypt %s: %w", column, err)
+	}
+	return string(plaintext), nil
+}
+
+// IsSealed reports whether a stored value is encrypted.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
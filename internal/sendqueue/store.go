@@ -0,0 +1,311 @@
+// Package sendqueue delivers mail at a scheduled time and retries sends
+// that failed for transient reasons. Queued messages can be edited or
+// cancelled until the worker picks them up.
+package sendqueue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"mcp-gmail-server/internal/db"
+	"mcp-gmail-server/internal/gmail"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusSending   = "sending"
+	StatusSent      = "sent"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Kinds of queued mail. System mail (password resets and the like) is sent
+// from the SYSTEM_EMAIL account and is not shown in users' outboxes.
+const (
+	KindUser   = "user"
+	KindSystem = "system"
+)
+
+// redactSystem is the SET clause that empties the payload of system mail,
+// whose links work as credentials, keeping the recipient and subject.
+const redactSystem = `payload = IF(kind = 'system', JSON_REMOVE(payload, '$.body', '$.html_body', '$.attachments'), payload)`
+
+// ErrNotPending is returned when editing or cancelling a message that has
+// already been picked up.
+var ErrNotPending = errors.New("message is no longer pending")
+
+// Item is a queued message.
+type Item struct {
+	ID            int64                  `json:"id"`
+	UserID        int                    `json:"user_id"`
+	AccountID     int                    `json:"account_id"`
+	Kind          string                 `json:"kind"`
+	Message       *gmail.OutgoingMessage `json:"message"`
+	SendAt        time.Time              `json:"send_at"`
+	Status        string                 `json:"status"`
+	Attempts      int                    `json:"attempts"`
+	LastError     string                 `json:"last_error,omitempty"`
+	SentMessageID string                 `json:"sent_message_id,omitempty"`
+	CreatedAt     time.Time              `json:"created_at"`
+}
+
+const itemColumns = `id, user_id, account_id, kind, payload, send_at, status, attempts,
+	last_error, sent_message_id, created_at`
+
+func scanItem(row interface{ Scan(...interface{}) error }) (*Item, error) {
+	var item Item
+	var payload string
+	var lastError, sentMessageID sql.NullString
+
+	err := row.Scan(&item.ID, &item.UserID, &item.AccountID, &item.Kind, &payload, &item.SendAt,
+		&item.Status, &item.Attempts, &lastError, &sentMessageID, &item.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	item.LastError = lastError.String
+	item.SentMessageID = sentMessageID.String
+	item.Message = &gmail.OutgoingMessage{}
+	if err := json.Unmarshal([]byte(payload), item.Message); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// Enqueue schedules m to be sent from the account at sendAt (now if zero).
+func Enqueue(userID, accountID int, kind string, m *gmail.OutgoingMessage, sendAt time.Time) (*Item, error) {
+	return enqueue(userID, accountID, kind, m, sendAt, time.Time{})
+}
+
+// EnqueueSystem queues system mail to be sent now. If it hasn't gone out by
+// expiresAt (zero for never), it is dropped: a link in it would be dead by
+// then anyway. Either way its body is cleared once the queue is done with
+// it, so the links don't outlive the message in the table.
+func EnqueueSystem(userID, accountID int, m *gmail.OutgoingMessage, expiresAt time.Time) (*Item, error) {
+	return enqueue(userID, accountID, KindSystem, m, time.Time{}, expiresAt)
+}
+
+func enqueue(userID, accountID int, kind string, m *gmail.OutgoingMessage, sendAt, expiresAt time.Time) (*Item, error) {
+	// Catch bad addresses and oversized messages now rather than at send time
+	if _, err := gmail.Compose(m); err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if sendAt.IsZero() {
+		sendAt = time.Now()
+	}
+
+	var expires sql.NullTime
+	if !expiresAt.IsZero() {
+		expires = sql.NullTime{Time: expiresAt.UTC(), Valid: true}
+	}
+
+	res, err := db.DB.Exec(`
+		INSERT INTO send_queue (user_id, account_id, kind, payload, send_at, status, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, userID, accountID, kind, string(payload), sendAt.UTC(), StatusPending, expires)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := res.LastInsertId()
+	if !sendAt.After(time.Now()) {
+		wake()
+	}
+	return Get(userID, id)
+}
+
+// Get returns a queued message owned by userID.
+func Get(userID int, id int64) (*Item, error) {
+	return scanItem(db.DB.QueryRow(`
+		SELECT `+itemColumns+`
+		FROM send_queue
+		WHERE id = ? AND user_id = ?
+	`, id, userID))
+}
+
+// List returns the user's outbox, newest first. Pass an empty status for
+// every state.
+func List(userID int, status string, limit int) ([]*Item, error) {
+	query := `
+		SELECT ` + itemColumns + `
+		FROM send_queue
+		WHERE user_id = ? AND kind = ?`
+	args := []interface{}{userID, KindUser}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY send_at DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*Item{}
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Update replaces the message and/or send time of a pending item.
+func Update(userID int, id int64, m *gmail.OutgoingMessage, sendAt time.Time) (*Item, error) {
+	item, err := Get(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = item.Message
+	}
+	if sendAt.IsZero() {
+		sendAt = item.SendAt
+	}
+
+	if _, err := gmail.Compose(m); err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := db.DB.Exec(`
+		UPDATE send_queue SET payload = ?, send_at = ?
+		WHERE id = ? AND user_id = ? AND status = ?
+	`, string(payload), sendAt.UTC(), id, userID, StatusPending)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 && item.Status != StatusPending {
+		return nil, ErrNotPending
+	}
+
+	if !sendAt.After(time.Now()) {
+		wake()
+	}
+	return Get(userID, id)
+}
+
+// Cancel stops a pending item from being sent.
+func Cancel(userID int, id int64) error {
+	res, err := db.DB.Exec(`
+		UPDATE send_queue SET status = ?
+		WHERE id = ? AND user_id = ? AND status = ?
+	`, StatusCancelled, id, userID, StatusPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := Get(userID, id); err != nil {
+			return err
+		}
+		return ErrNotPending
+	}
+	return nil
+}
+
+// due returns pending items whose send time has passed, oldest first.
+func due(limit int) ([]*Item, error) {
+	now := time.Now().UTC()
+	rows, err := db.DB.Query(`
+		SELECT `+itemColumns+`
+		FROM send_queue
+		WHERE status = ? AND send_at <= ? AND (expires_at IS NULL OR expires_at > ?)
+		ORDER BY send_at
+		LIMIT ?
+	`, StatusPending, now, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*Item
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// claim moves an item from pending to sending. It fails if the item was
+// cancelled or claimed in the meantime.
+func claim(id int64) (bool, error) {
+	res, err := db.DB.Exec(`
+		UPDATE send_queue SET status = ?, attempts = attempts + 1
+		WHERE id = ? AND status = ?
+	`, StatusSending, id, StatusPending)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func markSent(id int64, messageID string) error {
+	_, err := db.DB.Exec(`
+		UPDATE send_queue SET status = ?, sent_message_id = ?, last_error = NULL, sent_at = NOW(),
+			`+redactSystem+`
+		WHERE id = ?
+	`, StatusSent, messageID, id)
+	return err
+}
+
+// markRetry puts the item back in the queue for another attempt at retryAt.
+func markRetry(id int64, retryAt time.Time, sendErr error) error {
+	_, err := db.DB.Exec(`
+		UPDATE send_queue SET status = ?, send_at = ?, last_error = ?
+		WHERE id = ?
+	`, StatusPending, retryAt.UTC(), sendErr.Error(), id)
+	return err
+}
+
+func markFailed(id int64, sendErr error) error {
+	_, err := db.DB.Exec(`
+		UPDATE send_queue SET status = ?, last_error = ?, `+redactSystem+`
+		WHERE id = ?
+	`, StatusFailed, sendErr.Error(), id)
+	return err
+}
+
+// expire fails pending items that weren't sent before their expiry.
+func expire() (int64, error) {
+	res, err := db.DB.Exec(`
+		UPDATE send_queue SET status = ?, last_error = 'expired before it could be sent', `+redactSystem+`
+		WHERE status = ? AND expires_at <= ?
+	`, StatusFailed, StatusPending, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// requeueInterrupted returns items left in "sending" by a crash to the
+// queue. The send may have gone through, so a duplicate is possible.
+func requeueInterrupted() (int64, error) {
+	res, err := db.DB.Exec(`
+		UPDATE send_queue SET status = ?, last_error = 'interrupted during send'
+		WHERE status = ?
+	`, StatusPending, StatusSending)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
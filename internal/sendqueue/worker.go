@@ -0,0 +1,146 @@
+package sendqueue
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"mcp-gmail-server/internal/gmail"
+
+	gmailapi "google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// maxAttempts is how many times a message is tried before it is marked
+// failed.
+const maxAttempts = 5
+
+// batchSize caps how many due items one pass sends.
+const batchSize = 50
+
+// ServiceResolver builds a Gmail client for a mailbox account. It is
+// injected so this package doesn't depend on auth (which enqueues system
+// mail).
+type ServiceResolver func(accountID int) (*gmailapi.Service, error)
+
+// Options configures the queue worker.
+type Options struct {
+	Interval time.Duration
+	Service  ServiceResolver
+}
+
+var wakeCh = make(chan struct{}, 1)
+
+// wake asks the worker to run a pass now, e.g. after an immediate enqueue.
+func wake() {
+	select {
+	case wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the queue worker until ctx is cancelled.
+func Start(ctx context.Context, opts Options) {
+	if n, err := requeueInterrupted(); err != nil {
+		log.Printf("sendqueue: failed to requeue interrupted sends: %v", err)
+	} else if n > 0 {
+		log.Printf("sendqueue: requeued %d sends interrupted by a restart", n)
+	}
+
+	log.Printf("sendqueue: worker started (interval %s)", opts.Interval)
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		processDue(ctx, opts.Service)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wakeCh:
+		}
+	}
+}
+
+func processDue(ctx context.Context, resolve ServiceResolver) {
+	if n, err := expire(); err != nil {
+		log.Printf("sendqueue: failed to expire items: %v", err)
+	} else if n > 0 {
+		log.Printf("sendqueue: dropped %d items that expired unsent", n)
+	}
+
+	items, err := due(batchSize)
+	if err != nil {
+		log.Printf("sendqueue: failed to load due items: %v", err)
+		return
+	}
+
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return
+		}
+
+		ok, err := claim(item.ID)
+		if err != nil {
+			log.Printf("sendqueue: failed to claim item %d: %v", item.ID, err)
+			continue
+		}
+		if !ok {
+			// Cancelled or edited away since we loaded it
+			continue
+		}
+		item.Attempts++
+
+		deliver(item, resolve)
+	}
+}
+
+func deliver(item *Item, resolve ServiceResolver) {
+	service, err := resolve(item.AccountID)
+	if err == nil {
+		var sent *gmailapi.Message
+		sent, err = gmail.Send(service, item.Message)
+		if err == nil {
+			if err := markSent(item.ID, sent.Id); err != nil {
+				log.Printf("sendqueue: item %d sent but not recorded: %v", item.ID, err)
+			}
+			return
+		}
+	}
+
+	if isTransient(err) && item.Attempts < maxAttempts {
+		retryAt := time.Now().Add(backoff(item.Attempts))
+		log.Printf("sendqueue: item %d attempt %d failed, retrying at %s: %v", item.ID, item.Attempts, retryAt.Format(time.RFC3339), err)
+		if err := markRetry(item.ID, retryAt, err); err != nil {
+			log.Printf("sendqueue: failed to reschedule item %d: %v", item.ID, err)
+		}
+		return
+	}
+
+	log.Printf("sendqueue: item %d failed: %v", item.ID, err)
+	if err := markFailed(item.ID, err); err != nil {
+		log.Printf("sendqueue: failed to mark item %d failed: %v", item.ID, err)
+	}
+}
+
+// backoff is 1m, 2m, 4m, 8m... for successive attempts.
+func backoff(attempt int) time.Duration {
+	return time.Minute << (attempt - 1)
+}
+
+// isTransient reports whether a send error is worth retrying: rate limits,
+// Gmail server errors and network failures.
+func isTransient(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code == http.StatusTooManyRequests || gerr.Code >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mcp-gmail-server/internal/auth"
+	"mcp-gmail-server/internal/mailsync"
+	"mcp-gmail-server/internal/search"
+	"mcp-gmail-server/internal/vector"
+
+	"golang.org/x/oauth2"
+)
+
+type accountView struct {
+	*auth.Account
+	SyncStatus   string     `json:"sync_status,omitempty"`
+	LastSyncedAt *time.Time `json:"last_synced_at,omitempty"`
+}
+
+// registerAccountRoutes mounts the mailbox management API:
+//
+//	GET    /accounts       list connected mailboxes
+//	POST   /accounts       start connecting another mailbox (returns auth_url)
+//	PATCH  /accounts/{id}  set label and/or make primary
+//	DELETE /accounts/{id}  disconnect a mailbox
+func registerAccountRoutes(mux *http.ServeMux, oauthConfig *oauth2.Config, flows *oauthFlows) {
+
+	mux.HandleFunc("/accounts", func(w http.ResponseWriter, r *http.Request) {
+		user, err := auth.GetUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !requireScope(w, r, scopeFor(r, auth.ScopeModify)) {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			accounts, err := auth.ListAccounts(user.ID)
+			if err != nil {
+				http.Error(w, "Failed to list accounts", http.StatusInternalServerError)
+				return
+			}
+
+			views := make([]accountView, 0, len(accounts))
+			for _, a := range accounts {
+				v := accountView{Account: a}
+				if st, err := mailsync.GetState(a.ID); err == nil {
+					v.SyncStatus = st.Status
+					if !st.LastSyncedAt.IsZero() {
+						v.LastSyncedAt = &st.LastSyncedAt
+					}
+				}
+				views = append(views, v)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(views)
+
+		case http.MethodPost:
+			if !user.EmailVerified {
+				http.Error(w, "Forbidden: "+auth.ErrEmailUnverified.Error(), http.StatusForbidden)
+				return
+			}
+
+			// Adding a mailbox is the regular consent flow while logged in;
+			// the callback attaches the new mailbox to this user.
+			conf := oauthConfig
+			if user.GoogleClientID != "" && user.GoogleClientSecret != "" {
+				conf = auth.BuildOAuthConfig(user)
+			}
+
+			var body struct {
+				Redirect string `json:"redirect"`
+			}
+			// The body is optional
+			json.NewDecoder(r.Body).Decode(&body)
+
+			authURL, err := flows.start(w, conf, user, body.Redirect)
+			if err != nil {
+				http.Error(w, "Failed to start OAuth flow", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"auth_url": authURL})
+
+		default:
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/accounts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		user, err := auth.GetUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !requireScope(w, r, auth.ScopeModify) {
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeAccountError(w, errInvalidAccount)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPatch:
+			var body struct {
+				Label   *string `json:"label"`
+				Primary bool    `json:"primary"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+
+			if body.Label != nil {
+				if len(*body.Label) > 100 {
+					http.Error(w, "Label too long", http.StatusBadRequest)
+					return
+				}
+				if err := auth.SetAccountLabel(user.ID, id, *body.Label); err != nil {
+					writeAccountError(w, err)
+					return
+				}
+			}
+			if body.Primary {
+				if err := auth.SetPrimaryAccount(user.ID, id); err != nil {
+					writeAccountError(w, err)
+					return
+				}
+			}
+
+			account, err := auth.GetAccount(user.ID, id)
+			if err != nil {
+				writeAccountError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(account)
+
+		case http.MethodDelete:
+			if err := auth.RemoveAccount(user.ID, id); err != nil {
+				writeAccountError(w, err)
+				return
+			}
+			search.Forget(id)
+			vector.Forget(id)
+			log.Printf("User %d disconnected mailbox account %d", user.ID, id)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Account removed"})
+
+		default:
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		}
+	})
+}
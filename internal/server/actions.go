@@ -0,0 +1,302 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"mcp-gmail-server/internal/auth"
+	"mcp-gmail-server/internal/gmail"
+	"mcp-gmail-server/internal/mailsync"
+	"mcp-gmail-server/internal/mcp"
+
+	gmailapi "google.golang.org/api/gmail/v1"
+)
+
+// maxActionQueryMessages caps how many messages a query-based batch action
+// may touch in one request.
+const maxActionQueryMessages = 1000
+
+// accountService resolves a single mailbox (the "account" parameter as in
+// search, but "all" is not allowed) and builds its Gmail client.
+func accountService(userID int, param string) (*auth.Account, *gmailapi.Service, error) {
+	if param == "all" {
+		return nil, nil, errInvalidAccount
+	}
+
+	accounts, err := selectAccounts(userID, param)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	service, err := auth.NewGmailServiceForAccount(accounts[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return accounts[0], service, nil
+}
+
+type actionRequest struct {
+	Account    string   `json:"account"`
+	Action     string   `json:"action"`
+	Labels     []string `json:"labels"`
+	MessageIDs []string `json:"message_ids"`
+	// Query applies the action to every message it matches instead.
+	Query string `json:"query"`
+	Limit int    `json:"limit"`
+}
+
+// runAction applies a message action to explicit IDs or to the results of
+// a Gmail query.
+func runAction(user *auth.User, req actionRequest) (map[string]interface{}, error) {
+	if req.Action == "" {
+		return nil, errors.New("action is required")
+	}
+	if len(req.MessageIDs) == 0 && req.Query == "" {
+		return nil, errors.New("message_ids or query is required")
+	}
+
+	account, service, err := accountService(user.ID, req.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := req.MessageIDs
+	if len(ids) == 0 {
+		limit := req.Limit
+		if limit <= 0 || limit > maxActionQueryMessages {
+			limit = maxActionQueryMessages
+		}
+		ids, err = gmail.ListMessageIDs(service, req.Query, limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := gmail.ApplyAction(service, ids, req.Action, req.Labels); err != nil {
+		return nil, err
+	}
+
+	// Pull the label changes into the local mirror
+	mailsync.Trigger(account.ID)
+
+	return map[string]interface{}{
+		"action":   req.Action,
+		"account":  account.DisplayName(),
+		"affected": len(ids),
+	}, nil
+}
+
+func writeActionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gmail.ErrScopeNotGranted):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, auth.ErrNeedsReconnect):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, gmail.ErrMessageTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, auth.ErrNoAccount), errors.Is(err, errInvalidAccount), errors.Is(err, sql.ErrNoRows):
+		writeAccountError(w, err)
+	default:
+		log.Printf("Mail action failed: %v", err)
+		http.Error(w, fmt.Sprintf("Action failed: %v", err), http.StatusBadRequest)
+	}
+}
+
+// registerActionRoutes mounts the gmail.modify operations:
+//
+//	GET  /labels            list labels (?account=)
+//	POST /labels            create a label
+//	POST /messages/actions  label, archive, trash, star or mark messages
+func registerActionRoutes(mux *http.ServeMux) {
+
+	mux.HandleFunc("/labels", func(w http.ResponseWriter, r *http.Request) {
+		user, err := auth.GetUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !requireScope(w, r, scopeFor(r, auth.ScopeModify)) {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			_, service, err := accountService(user.ID, r.URL.Query().Get("account"))
+			if err != nil {
+				writeAccountError(w, err)
+				return
+			}
+
+			labels, err := gmail.ListLabels(service)
+			if err != nil {
+				writeActionError(w, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(labels)
+
+		case http.MethodPost:
+			var body struct {
+				Account string `json:"account"`
+				Name    string `json:"name"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+				http.Error(w, "name is required", http.StatusBadRequest)
+				return
+			}
+
+			_, service, err := accountService(user.ID, body.Account)
+			if err != nil {
+				writeAccountError(w, err)
+				return
+			}
+
+			label, err := gmail.CreateLabel(service, body.Name)
+			if err != nil {
+				writeActionError(w, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(label)
+
+		default:
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/messages/actions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := auth.GetUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !requireScope(w, r, auth.ScopeModify) {
+			return
+		}
+
+		var req actionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		result, err := runAction(user, req)
+		if err != nil {
+			writeActionError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+var accountProperty = map[string]interface{}{
+	"type":        "string",
+	"description": "Mailbox account ID; defaults to the primary mailbox",
+}
+
+// registerActionTools exposes the same operations as MCP tools.
+func registerActionTools() {
+	mcp.RegisterTool(&mcp.Tool{
+		Name:        "list_labels",
+		Description: "List the Gmail labels of a mailbox.",
+		InputSchema: mcp.ObjectSchema(map[string]interface{}{
+			"account": accountProperty,
+		}),
+		Scope: auth.ScopeRead,
+		Handler: func(call *mcp.Call) (interface{}, error) {
+			var args struct {
+				Account string `json:"account"`
+			}
+			if err := call.Decode(&args); err != nil {
+				return nil, err
+			}
+			_, service, err := accountService(call.User.ID, args.Account)
+			if err != nil {
+				return nil, err
+			}
+			return gmail.ListLabels(service)
+		},
+	})
+
+	mcp.RegisterTool(&mcp.Tool{
+		Name:        "create_label",
+		Description: "Create a Gmail label.",
+		InputSchema: mcp.ObjectSchema(map[string]interface{}{
+			"account": accountProperty,
+			"name":    map[string]interface{}{"type": "string"},
+		}, "name"),
+		Scope: auth.ScopeModify,
+		Handler: func(call *mcp.Call) (interface{}, error) {
+			var args struct {
+				Account string `json:"account"`
+				Name    string `json:"name"`
+			}
+			if err := call.Decode(&args); err != nil {
+				return nil, err
+			}
+			if args.Name == "" {
+				return nil, errors.New("name is required")
+			}
+			_, service, err := accountService(call.User.ID, args.Account)
+			if err != nil {
+				return nil, err
+			}
+			return gmail.CreateLabel(service, args.Name)
+		},
+	})
+
+	mcp.RegisterTool(&mcp.Tool{
+		Name: "modify_messages",
+		Description: "Apply an action to messages, given by ID or by a Gmail search query. " +
+			"Actions: add_labels, remove_labels, archive, unarchive, trash, untrash, star, unstar, mark_read, mark_unread.",
+		InputSchema: mcp.ObjectSchema(map[string]interface{}{
+			"account": accountProperty,
+			"action": map[string]interface{}{
+				"type": "string",
+				"enum": []string{
+					gmail.ActionAddLabels, gmail.ActionRemoveLabels,
+					gmail.ActionArchive, gmail.ActionUnarchive,
+					gmail.ActionTrash, gmail.ActionUntrash,
+					gmail.ActionStar, gmail.ActionUnstar,
+					gmail.ActionMarkRead, gmail.ActionMarkUnread,
+				},
+			},
+			"labels": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Label names or IDs for add_labels/remove_labels",
+			},
+			"message_ids": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string"},
+			},
+			"query": map[string]interface{}{
+				"type":        "string",
+				"description": "Gmail search query; the action applies to every match",
+			},
+			"limit": map[string]interface{}{"type": "integer"},
+		}, "action"),
+		Scope: auth.ScopeModify,
+		Handler: func(call *mcp.Call) (interface{}, error) {
+			var req actionRequest
+			if err := call.Decode(&req); err != nil {
+				return nil, err
+			}
+			return runAction(call.User, req)
+		},
+	})
+}
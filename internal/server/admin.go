@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+
+	"mcp-gmail-server/internal/admin"
+	"mcp-gmail-server/internal/auth"
+)
+
+// registerAdminRoutes mounts the admin API behind auth.AdminOnly:
+//
+//	GET    /admin/users                                 list users (?page, per_page, q, role, active)
+//	GET    /admin/users/{id}                            show a user
+//	PATCH  /admin/users/{id}                            set active, role and/or email_verified
+//	POST   /admin/users/{id}/logout                     end all sessions (optionally revoke API keys)
+//	POST   /admin/users/{id}/unlock                     lift a login lockout
+//	DELETE /admin/users/{id}/accounts                   disconnect all of a user's mailboxes
+//	DELETE /admin/users/{id}/accounts/{account_id}      disconnect one mailbox
+//	GET    /admin/users/{id}/stats                      usage stats
+//	DELETE /admin/users/{id}/mfa                        reset two-factor authentication
+//	GET    /admin/mfa-policy                            roles that must use two-factor authentication
+//	PUT    /admin/mfa-policy                            set {role, required}
+//	POST   /admin/api-keys                              issue a key for a user (?user_id)
+//	/admin/delegation/...                               see registerDelegationRoutes
+func registerAdminRoutes(mux *http.ServeMux) {
+	adminMux := http.NewServeMux()
+
+	adminMux.HandleFunc("/admin/users", admin.ListUsers)
+	adminMux.HandleFunc("/admin/users/{id}", admin.ManageUser)
+	adminMux.HandleFunc("/admin/users/{id}/logout", admin.LogoutUser)
+	adminMux.HandleFunc("/admin/users/{id}/unlock", admin.UnlockUser)
+	adminMux.HandleFunc("/admin/users/{id}/accounts", admin.DisconnectGmail)
+	adminMux.HandleFunc("/admin/users/{id}/accounts/{account_id}", admin.DisconnectGmail)
+	adminMux.HandleFunc("/admin/users/{id}/stats", admin.UserStats)
+	adminMux.HandleFunc("/admin/users/{id}/mfa", admin.ResetMFA)
+	adminMux.HandleFunc("/admin/mfa-policy", admin.MFAPolicy)
+	adminMux.HandleFunc("/admin/api-keys", admin.CreateAPIKey)
+
+	registerDelegationRoutes(adminMux)
+
+	mux.Handle("/admin/", auth.AdminOnly(adminMux))
+}
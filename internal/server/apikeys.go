@@ -0,0 +1,188 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mcp-gmail-server/internal/auth"
+)
+
+// requireScope rejects callers without scope. Apart from unverified users,
+// who can't search, only API keys are ever missing scopes their user's role
+// allows.
+func requireScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	p := auth.GetPrincipal(r)
+	if p == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if scope == auth.ScopeSearch && !p.User.EmailVerified {
+		http.Error(w, "Forbidden: "+auth.ErrEmailUnverified.Error(), http.StatusForbidden)
+		return false
+	}
+	if !p.Can(scope) {
+		http.Error(w, fmt.Sprintf("Forbidden: API key lacks the %q scope", scope), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// scopeFor maps reads (GET) to the read scope and everything else to write.
+func scopeFor(r *http.Request, write string) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return auth.ScopeRead
+	}
+	return write
+}
+
+// keyManager resolves the caller for key management. Keys are managed from
+// a session (or Google access token); an API key may only rotate or revoke
+// itself, so a script can roll its own credential.
+func keyManager(w http.ResponseWriter, r *http.Request, keyID int) (*auth.User, bool) {
+	user, err := auth.GetUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	if own := auth.GetAPIKeyID(r); own != 0 && own != keyID {
+		http.Error(w, "Forbidden: API keys can only manage themselves", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+func writeAPIKeyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("API key operation failed: %v", err)
+	http.Error(w, "API key operation failed", http.StatusInternalServerError)
+}
+
+// registerAPIKeyRoutes mounts self-service API key management:
+//
+//	GET    /api-keys              list your keys (never the secrets)
+//	POST   /api-keys              create a key {name, scopes, expires_at}
+//	POST   /api-keys/{id}/rotate  replace a key's secret
+//	DELETE /api-keys/{id}         revoke a key
+//
+// Create and rotate return the raw key once as "api_key".
+func registerAPIKeyRoutes(mux *http.ServeMux) {
+
+	mux.HandleFunc("/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		user, ok := keyManager(w, r, 0)
+		if !ok {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			keys, err := auth.ListAPIKeys(user.ID)
+			if err != nil {
+				writeAPIKeyError(w, err)
+				return
+			}
+			if keys == nil {
+				keys = []*auth.APIKey{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(keys)
+
+		case http.MethodPost:
+			var body struct {
+				Name      string     `json:"name"`
+				Scopes    []string   `json:"scopes"`
+				ExpiresAt *time.Time `json:"expires_at"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if body.Name == "" || len(body.Name) > 100 {
+				http.Error(w, "Name is required (max 100 characters)", http.StatusBadRequest)
+				return
+			}
+			if body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()) {
+				http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+				return
+			}
+			scopes, err := auth.ValidateScopes(user, body.Scopes)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			raw, key, err := auth.CreateAPIKey(user.ID, body.Name, scopes, body.ExpiresAt)
+			if err != nil {
+				writeAPIKeyError(w, err)
+				return
+			}
+			log.Printf("User %d created API key %d (%s)", user.ID, key.ID, key.Name)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]interface{}{"api_key": raw, "key": key})
+
+		default:
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/api-keys/{id}/rotate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid id", http.StatusBadRequest)
+			return
+		}
+		user, ok := keyManager(w, r, id)
+		if !ok {
+			return
+		}
+
+		raw, key, err := auth.RotateAPIKey(user.ID, id)
+		if err != nil {
+			writeAPIKeyError(w, err)
+			return
+		}
+		log.Printf("User %d rotated API key %d", user.ID, key.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"api_key": raw, "key": key})
+	})
+
+	mux.HandleFunc("/api-keys/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid id", http.StatusBadRequest)
+			return
+		}
+		user, ok := keyManager(w, r, id)
+		if !ok {
+			return
+		}
+
+		if err := auth.RevokeAPIKey(user.ID, id); err != nil {
+			writeAPIKeyError(w, err)
+			return
+		}
+		log.Printf("User %d revoked API key %d", user.ID, id)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
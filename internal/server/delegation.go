@@ -0,0 +1,149 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"mcp-gmail-server/internal/auth"
+)
+
+// requireAdmin resolves the caller and requires the admin role (and, for
+// API keys, the admin scope).
+func requireAdmin(w http.ResponseWriter, r *http.Request) (*auth.User, bool) {
+	user, err := auth.GetUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	if user.Role != "admin" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	if !requireScope(w, r, auth.ScopeAdmin) {
+		return nil, false
+	}
+	return user, true
+}
+
+func writeDelegationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrDelegationDisabled):
+		http.Error(w, "Domain-wide delegation is not configured", http.StatusNotImplemented)
+	case errors.Is(err, auth.ErrMailboxNotAllowed):
+		http.Error(w, "Mailbox is not on the delegation allowlist", http.StatusForbidden)
+	default:
+		log.Printf("Delegated mailbox access failed: %v", err)
+		http.Error(w, "Delegated mailbox access failed", http.StatusInternalServerError)
+	}
+}
+
+// registerDelegationRoutes mounts the admin API for domain-wide delegation:
+//
+//	GET    /admin/delegation/mailboxes       list allowlisted mailboxes
+//	POST   /admin/delegation/mailboxes       allowlist a mailbox
+//	DELETE /admin/delegation/mailboxes/{id}  remove a mailbox
+//	GET    /admin/delegation/audit           recent delegated accesses
+func registerDelegationRoutes(mux *http.ServeMux) {
+
+	mux.HandleFunc("/admin/delegation/mailboxes", func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := requireAdmin(w, r)
+		if !ok {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			mailboxes, err := auth.ListDelegatedMailboxes()
+			if err != nil {
+				http.Error(w, "Failed to list mailboxes", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"enabled":   auth.DelegationEnabled(),
+				"mailboxes": mailboxes,
+			})
+
+		case http.MethodPost:
+			var req struct {
+				EmailAddress string `json:"email_address"`
+				Note         string `json:"note"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.EmailAddress == "" {
+				http.Error(w, "email_address is required", http.StatusBadRequest)
+				return
+			}
+
+			if err := auth.AddDelegatedMailbox(admin.ID, req.EmailAddress, req.Note, auth.ClientIP(r)); err != nil {
+				log.Printf("Failed to add delegated mailbox %s: %v", req.EmailAddress, err)
+				http.Error(w, "Failed to add mailbox", http.StatusInternalServerError)
+				return
+			}
+
+			w.WriteHeader(http.StatusCreated)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/admin/delegation/mailboxes/{id}", func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := requireAdmin(w, r)
+		if !ok {
+			return
+		}
+
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid mailbox id", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := auth.RemoveDelegatedMailbox(admin.ID, id, auth.ClientIP(r)); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Mailbox not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Failed to remove delegated mailbox %d: %v", id, err)
+			http.Error(w, "Failed to remove mailbox", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/admin/delegation/audit", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAdmin(w, r); !ok {
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 || limit > 500 {
+			limit = 100
+		}
+
+		entries, err := auth.ListDelegationAudit(r.URL.Query().Get("mailbox"), limit)
+		if err != nil {
+			http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	})
+}
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"mcp-gmail-server/internal/auth"
+	"mcp-gmail-server/internal/drafts"
+	"mcp-gmail-server/internal/gmail"
+	"mcp-gmail-server/internal/mcp"
+
+	gmailapi "google.golang.org/api/gmail/v1"
+)
+
+type draftView struct {
+	*gmail.Draft
+	Record *drafts.Record `json:"record,omitempty"`
+}
+
+type draftRequest struct {
+	Account string `json:"account"`
+	gmail.OutgoingMessage
+}
+
+func createDraft(user *auth.User, apiKeyID int, req draftRequest) (*draftView, error) {
+	if req.To == "" && req.ThreadID == "" {
+		return nil, errors.New("to or thread_id is required")
+	}
+
+	account, service, err := accountService(user.ID, req.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	return saveDraft(user, apiKeyID, account, service, &req.OutgoingMessage)
+}
+
+// saveDraft creates the draft in Gmail and records who created it.
+func saveDraft(user *auth.User, apiKeyID int, account *auth.Account, service *gmailapi.Service, m *gmail.OutgoingMessage) (*draftView, error) {
+	draft, err := gmail.CreateDraft(service, m)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := drafts.Save(user.ID, account.ID, apiKeyID, draft); err != nil {
+		log.Printf("Failed to record draft %s: %v", draft.ID, err)
+	}
+	return describeDraft(account.ID, draft), nil
+}
+
+func updateDraft(user *auth.User, apiKeyID int, id string, req draftRequest) (*draftView, error) {
+	account, service, err := accountService(user.ID, req.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	draft, err := gmail.UpdateDraft(service, id, &req.OutgoingMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := drafts.Save(user.ID, account.ID, apiKeyID, draft); err != nil {
+		log.Printf("Failed to record draft %s: %v", draft.ID, err)
+	}
+	return describeDraft(account.ID, draft), nil
+}
+
+func listDrafts(user *auth.User, accountParam string, limit int) ([]*draftView, error) {
+	account, service, err := accountService(user.ID, accountParam)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := gmail.ListDrafts(service, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(list))
+	for _, d := range list {
+		ids = append(ids, d.ID)
+	}
+	records, err := drafts.Lookup(account.ID, ids)
+	if err != nil {
+		log.Printf("Failed to load draft records: %v", err)
+	}
+
+	views := make([]*draftView, 0, len(list))
+	for _, d := range list {
+		views = append(views, &draftView{Draft: d, Record: records[d.ID]})
+	}
+	return views, nil
+}
+
+func sendDraft(user *auth.User, accountParam, id string) (map[string]string, error) {
+	account, service, err := accountService(user.ID, accountParam)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := gmail.SendDraft(service, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := drafts.MarkSent(account.ID, id, msg.Id); err != nil {
+		log.Printf("Failed to mark draft %s sent: %v", id, err)
+	}
+	return map[string]string{"message_id": msg.Id, "thread_id": msg.ThreadId}, nil
+}
+
+func deleteDraft(user *auth.User, accountParam, id string) error {
+	account, service, err := accountService(user.ID, accountParam)
+	if err != nil {
+		return err
+	}
+
+	if err := gmail.DeleteDraft(service, id); err != nil {
+		return err
+	}
+	return drafts.Delete(account.ID, id)
+}
+
+func describeDraft(accountID int, d *gmail.Draft) *draftView {
+	view := &draftView{Draft: d}
+	if records, err := drafts.Lookup(accountID, []string{d.ID}); err == nil {
+		view.Record = records[d.ID]
+	}
+	return view
+}
+
+// registerDraftRoutes mounts the draft API:
+//
+//	GET    /drafts            list drafts (?account=&limit=)
+//	POST   /drafts            create a draft, optionally in a thread
+//	GET    /drafts/{id}       fetch a draft
+//	PUT    /drafts/{id}       replace a draft's content
+//	DELETE /drafts/{id}       discard a draft
+//	POST   /drafts/{id}/send  send a draft
+func registerDraftRoutes(mux *http.ServeMux) {
+
+	mux.HandleFunc("/drafts", func(w http.ResponseWriter, r *http.Request) {
+		user, err := auth.GetUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !requireScope(w, r, scopeFor(r, auth.ScopeSend)) {
+			return
+		}
+		keyID := auth.GetAPIKeyID(r)
+
+		switch r.Method {
+		case http.MethodGet:
+			limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+			if err != nil || limit <= 0 || limit > 100 {
+				limit = 20
+			}
+
+			views, err := listDrafts(user, r.URL.Query().Get("account"), limit)
+			if err != nil {
+				writeActionError(w, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(views)
+
+		case http.MethodPost:
+			var req draftRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+
+			view, err := createDraft(user, keyID, req)
+			if err != nil {
+				writeActionError(w, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(view)
+
+		default:
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/drafts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		user, err := auth.GetUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !requireScope(w, r, scopeFor(r, auth.ScopeSend)) {
+			return
+		}
+		keyID := auth.GetAPIKeyID(r)
+		id := r.PathValue("id")
+
+		switch r.Method {
+		case http.MethodGet:
+			account, service, err := accountService(user.ID, r.URL.Query().Get("account"))
+			if err != nil {
+				writeAccountError(w, err)
+				return
+			}
+
+			draft, err := gmail.GetDraft(service, id)
+			if err != nil {
+				writeActionError(w, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(describeDraft(account.ID, draft))
+
+		case http.MethodPut:
+			var req draftRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+
+			view, err := updateDraft(user, keyID, id, req)
+			if err != nil {
+				writeActionError(w, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(view)
+
+		case http.MethodDelete:
+			if err := deleteDraft(user, r.URL.Query().Get("account"), id); err != nil {
+				writeActionError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/drafts/{id}/send", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := auth.GetUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !requireScope(w, r, auth.ScopeSend) {
+			return
+		}
+
+		var body struct {
+			Account string `json:"account"`
+		}
+		// The body is optional; account defaults to the primary mailbox
+		json.NewDecoder(r.Body).Decode(&body)
+
+		result, err := sendDraft(user, body.Account, r.PathValue("id"))
+		if err != nil {
+			writeActionError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+// draftProperties describes the message fields shared by create and update.
+func draftProperties() map[string]interface{} {
+	str := func(desc string) map[string]interface{} {
+		return map[string]interface{}{"type": "string", "description": desc}
+	}
+	return map[string]interface{}{
+		"account":   accountProperty,
+		"to":        str("Comma-separated recipients"),
+		"cc":        str("Comma-separated Cc recipients"),
+		"bcc":       str("Comma-separated Bcc recipients"),
+		"subject":   str("Subject; defaults to Re: <thread subject> when thread_id is set"),
+		"body":      str("Plain text body"),
+		"thread_id": str("Existing thread to attach the draft to"),
+	}
+}
+
+// registerDraftTools exposes the draft operations as MCP tools.
+func registerDraftTools() {
+	mcp.RegisterTool(&mcp.Tool{
+		Name:        "create_draft",
+		Description: "Create a Gmail draft for review instead of sending mail directly.",
+		InputSchema: mcp.ObjectSchema(draftProperties(), "body"),
+		Scope:       auth.ScopeSend,
+		Handler: func(call *mcp.Call) (interface{}, error) {
+			var req draftRequest
+			if err := call.Decode(&req); err != nil {
+				return nil, err
+			}
+			return createDraft(call.User, call.APIKeyID, req)
+		},
+	})
+
+	updateProps := draftProperties()
+	updateProps["draft_id"] = map[string]interface{}{"type": "string"}
+	mcp.RegisterTool(&mcp.Tool{
+		Name:        "update_draft",
+		Description: "Replace the content of an existing draft.",
+		InputSchema: mcp.ObjectSchema(updateProps, "draft_id", "body"),
+		Scope:       auth.ScopeSend,
+		Handler: func(call *mcp.Call) (interface{}, error) {
+			var req struct {
+				DraftID string `json:"draft_id"`
+				draftRequest
+			}
+			if err := call.Decode(&req); err != nil {
+				return nil, err
+			}
+			if req.DraftID == "" {
+				return nil, errors.New("draft_id is required")
+			}
+			return updateDraft(call.User, call.APIKeyID, req.DraftID, req.draftRequest)
+		},
+	})
+
+	mcp.RegisterTool(&mcp.Tool{
+		Name:        "list_drafts",
+		Description: "List drafts in a mailbox.",
+		InputSchema: mcp.ObjectSchema(map[string]interface{}{
+			"account": accountProperty,
+			"limit":   map[string]interface{}{"type": "integer"},
+		}),
+		Scope: auth.ScopeRead,
+		Handler: func(call *mcp.Call) (interface{}, error) {
+			var args struct {
+				Account string `json:"account"`
+				Limit   int    `json:"limit"`
+			}
+			if err := call.Decode(&args); err != nil {
+				return nil, err
+			}
+			if args.Limit <= 0 || args.Limit > 100 {
+				args.Limit = 20
+			}
+			return listDrafts(call.User, args.Account, args.Limit)
+		},
+	})
+
+	idSchema := mcp.ObjectSchema(map[string]interface{}{
+		"account":  accountProperty,
+		"draft_id": map[string]interface{}{"type": "string"},
+	}, "draft_id")
+
+	type draftRef struct {
+		Account string `json:"account"`
+		DraftID string `json:"draft_id"`
+	}
+	decodeRef := func(call *mcp.Call) (draftRef, error) {
+		var ref draftRef
+		if err := call.Decode(&ref); err != nil {
+			return ref, err
+		}
+		if ref.DraftID == "" {
+			return ref, errors.New("draft_id is required")
+		}
+		return ref, nil
+	}
+
+	mcp.RegisterTool(&mcp.Tool{
+		Name:        "delete_draft",
+		Description: "Discard a draft.",
+		InputSchema: idSchema,
+		Scope:       auth.ScopeSend,
+		Handler: func(call *mcp.Call) (interface{}, error) {
+			ref, err := decodeRef(call)
+			if err != nil {
+				return nil, err
+			}
+			if err := deleteDraft(call.User, ref.Account, ref.DraftID); err != nil {
+				return nil, err
+			}
+			return map[string]bool{"deleted": true}, nil
+		},
+	})
+
+	mcp.RegisterTool(&mcp.Tool{
+		Name:        "send_draft",
+		Description: "Send an existing draft.",
+		InputSchema: idSchema,
+		Scope:       auth.ScopeSend,
+		Handler: func(call *mcp.Call) (interface{}, error) {
+			ref, err := decodeRef(call)
+			if err != nil {
+				return nil, err
+			}
+			return sendDraft(call.User, ref.Account, ref.DraftID)
+		},
+	})
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"mcp-gmail-server/internal/auth"
+	"mcp-gmail-server/internal/mcp"
+)
+
+// mcpHandler serves the MCP JSON-RPC endpoint (streamable HTTP transport,
+// JSON responses only).
+func mcpHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := auth.GetPrincipal(r)
+	if p == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req mcp.RPCRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mcp.ParseError())
+		return
+	}
+
+	resp := mcp.Dispatch(p, &req)
+	if req.IsNotification() {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"mcp-gmail-server/internal/auth"
+)
+
+type mfaCodeBody struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func writeMFAError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrMFAInvalidCode):
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrMFAAlreadyEnabled), errors.Is(err, auth.ErrMFANotStarted),
+		errors.Is(err, auth.ErrMFANotEnabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("Two-factor operation failed: %v", err)
+		http.Error(w, "Two-factor operation failed", http.StatusInternalServerError)
+	}
+}
+
+// registerMFARoutes mounts two-factor enrolment. Like session management
+// it needs a signed-in session, not an API key:
+//
+//	GET  /auth/mfa                 enrolment status
+//	POST /auth/mfa/setup           new TOTP secret and otpauth:// URI for the QR code
+//	POST /auth/mfa/enable          confirm with {code}; returns recovery codes
+//	POST /auth/mfa/disable         {code} or {recovery_code}
+//	POST /auth/mfa/recovery-codes  replace recovery codes, {code} or {recovery_code}
+//
+// Recovery codes are shown once.
+func registerMFARoutes(mux *http.ServeMux) {
+
+	mux.HandleFunc("/auth/mfa", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+		p, ok := sessionOwner(w, r)
+		if !ok {
+			return
+		}
+
+		status, err := auth.GetMFAStatus(p.User)
+		if err != nil {
+			writeMFAError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+
+	mux.HandleFunc("/auth/mfa/setup", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+		p, ok := sessionOwner(w, r)
+		if !ok {
+			return
+		}
+
+		secret, uri, err := auth.BeginMFAEnrollment(p.User)
+		if err != nil {
+			writeMFAError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"secret": secret, "otpauth_url": uri})
+	})
+
+	mux.HandleFunc("/auth/mfa/enable", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+		p, ok := sessionOwner(w, r)
+		if !ok {
+			return
+		}
+
+		var body mfaCodeBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Code == "" {
+			http.Error(w, "code is required", http.StatusBadRequest)
+			return
+		}
+
+		codes, err := auth.ConfirmMFAEnrollment(p.User, body.Code)
+		if err != nil {
+			writeMFAError(w, err)
+			return
+		}
+		log.Printf("User %d enabled two-factor authentication", p.User.ID)
+
+		// Other devices signed in with the password alone
+		if _, err := auth.RevokeSessions(p.User.ID, p.SessionID); err != nil {
+			log.Printf("Failed to revoke sessions for user %d: %v", p.User.ID, err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+	})
+
+	mux.HandleFunc("/auth/mfa/disable", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+		p, ok := sessionOwner(w, r)
+		if !ok {
+			return
+		}
+
+		var body mfaCodeBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if auth.MFARequired(p.User.Role) {
+			http.Error(w, "Forbidden: two-factor authentication is required for your role", http.StatusForbidden)
+			return
+		}
+		if err := auth.VerifyMFA(p.User, body.Code, body.RecoveryCode); err != nil {
+			writeMFAError(w, err)
+			return
+		}
+
+		if err := auth.DisableMFA(p.User.ID); err != nil {
+			writeMFAError(w, err)
+			return
+		}
+		log.Printf("User %d disabled two-factor authentication", p.User.ID)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/auth/mfa/recovery-codes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+		p, ok := sessionOwner(w, r)
+		if !ok {
+			return
+		}
+
+		var body mfaCodeBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := auth.VerifyMFA(p.User, body.Code, body.RecoveryCode); err != nil {
+			writeMFAError(w, err)
+			return
+		}
+
+		codes, err := auth.RegenerateRecoveryCodes(p.User)
+		if err != nil {
+			writeMFAError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+	})
+}
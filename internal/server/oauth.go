@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"mcp-gmail-server/internal/auth"
+
+	"golang.org/x/oauth2"
+)
+
+const oauthStateCookie = "oauth_state"
+
+// oauthFlows starts and completes Google consent flows, keeping the signed
+// state in a cookie scoped to the callback.
+type oauthFlows struct {
+	origin   string
+	secure   bool
+	sameSite http.SameSite
+}
+
+// safeRedirect keeps post-login redirects on the frontend: only absolute
+// paths ("/settings"), never another host ("//evil.com").
+func safeRedirect(target string) string {
+	if target == "" || !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.Contains(target, "\\") {
+		return ""
+	}
+	if u, err := url.Parse(target); err != nil || u.Host != "" || u.Scheme != "" {
+		return ""
+	}
+	return target
+}
+
+// start creates a flow for the (optional) logged-in user, sets the state
+// cookie and returns Google's consent URL.
+func (o *oauthFlows) start(w http.ResponseWriter, conf *oauth2.Config, user *auth.User, redirect string) (string, error) {
+	userID := 0
+	if user != nil {
+		userID = user.ID
+	}
+
+	flow, err := auth.NewOAuthFlow(userID, safeRedirect(redirect))
+	if err != nil {
+		return "", err
+	}
+	value, err := flow.Encode()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    value,
+		Path:     "/oauth/callback",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   o.secure,
+		SameSite: o.sameSite,
+	})
+
+	return flow.AuthURL(conf), nil
+}
+
+// finish validates the callback against the state cookie and clears it.
+// The flow must have been started by the same session (or none).
+func (o *oauthFlows) finish(w http.ResponseWriter, r *http.Request, currentUser *auth.User) (*auth.OAuthFlow, error) {
+	var value string
+	if cookie, err := r.Cookie(oauthStateCookie); err == nil {
+		value = cookie.Value
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    "",
+		Path:     "/oauth/callback",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   o.secure,
+		SameSite: o.sameSite,
+	})
+
+	flow, err := auth.ParseOAuthFlow(value, r.URL.Query().Get("state"))
+	if err != nil {
+		return nil, err
+	}
+
+	currentID := 0
+	if currentUser != nil {
+		currentID = currentUser.ID
+	}
+	if flow.UserID != currentID {
+		return nil, errors.New("signed-in user changed during the OAuth flow")
+	}
+	return flow, nil
+}
+
+// fail sends the browser back to the frontend with an error code.
+func (o *oauthFlows) fail(w http.ResponseWriter, r *http.Request, code string, err error) {
+	log.Printf("OAuth callback rejected (%s): %v", code, err)
+	http.Redirect(w, r, o.origin+"?error="+url.QueryEscape(code), http.StatusTemporaryRedirect)
+}
+
+// sessionUser resolves the logged-in user from the auth_token cookie. The
+// OAuth flow runs in the browser, so this is the only credential it sees.
+func sessionUser(r *http.Request) (*auth.User, error) {
+	return auth.SessionUser(r)
+}
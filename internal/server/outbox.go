@@ -0,0 +1,160 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mcp-gmail-server/internal/auth"
+	"mcp-gmail-server/internal/gmail"
+	"mcp-gmail-server/internal/mcp"
+	"mcp-gmail-server/internal/sendqueue"
+)
+
+func writeOutboxError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Queued message not found", http.StatusNotFound)
+	case errors.Is(err, sendqueue.ErrNotPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeActionError(w, err)
+	}
+}
+
+type outboxUpdate struct {
+	Message *gmail.OutgoingMessage `json:"message"`
+	SendAt  *time.Time             `json:"send_at"`
+}
+
+// registerOutboxRoutes mounts the send queue API:
+//
+//	GET    /outbox       list queued and recent sends (?status=)
+//	GET    /outbox/{id}  fetch a queued message
+//	PUT    /outbox/{id}  edit the message and/or send time while pending
+//	DELETE /outbox/{id}  cancel while pending
+func registerOutboxRoutes(mux *http.ServeMux) {
+
+	mux.HandleFunc("/outbox", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := auth.GetUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !requireScope(w, r, auth.ScopeRead) {
+			return
+		}
+
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 || limit > 200 {
+			limit = 50
+		}
+
+		items, err := sendqueue.List(user.ID, r.URL.Query().Get("status"), limit)
+		if err != nil {
+			http.Error(w, "Failed to list outbox", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	})
+
+	mux.HandleFunc("/outbox/{id}", func(w http.ResponseWriter, r *http.Request) {
+		user, err := auth.GetUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !requireScope(w, r, scopeFor(r, auth.ScopeSend)) {
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid id", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			item, err := sendqueue.Get(user.ID, id)
+			if err != nil {
+				writeOutboxError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(item)
+
+		case http.MethodPut:
+			var body outboxUpdate
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+
+			var sendAt time.Time
+			if body.SendAt != nil {
+				sendAt = *body.SendAt
+			}
+			item, err := sendqueue.Update(user.ID, id, body.Message, sendAt)
+			if err != nil {
+				writeOutboxError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(item)
+
+		case http.MethodDelete:
+			if err := sendqueue.Cancel(user.ID, id); err != nil {
+				writeOutboxError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func registerOutboxTools() {
+	mcp.RegisterTool(&mcp.Tool{
+		Name:        "list_scheduled",
+		Description: "List messages waiting in the send queue.",
+		InputSchema: mcp.ObjectSchema(map[string]interface{}{}),
+		Scope:       auth.ScopeRead,
+		Handler: func(call *mcp.Call) (interface{}, error) {
+			return sendqueue.List(call.User.ID, sendqueue.StatusPending, 50)
+		},
+	})
+
+	mcp.RegisterTool(&mcp.Tool{
+		Name:        "cancel_scheduled",
+		Description: "Cancel a queued message before it is sent.",
+		InputSchema: mcp.ObjectSchema(map[string]interface{}{
+			"id": map[string]interface{}{"type": "integer"},
+		}, "id"),
+		Scope: auth.ScopeSend,
+		Handler: func(call *mcp.Call) (interface{}, error) {
+			var args struct {
+				ID int64 `json:"id"`
+			}
+			if err := call.Decode(&args); err != nil {
+				return nil, err
+			}
+			if err := sendqueue.Cancel(call.User.ID, args.ID); err != nil {
+				return nil, err
+			}
+			return map[string]bool{"cancelled": true}, nil
+		},
+	})
+}
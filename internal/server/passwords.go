@@ -0,0 +1,28 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"mcp-gmail-server/internal/auth"
+)
+
+// writePasswordPolicyError answers a rejected password with the broken
+// rules, so the frontend can show each one. It reports false for other
+// errors.
+func writePasswordPolicyError(w http.ResponseWriter, err error) bool {
+	var policyErr *auth.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      "password_policy",
+		"message":    "Password does not meet the requirements",
+		"violations": policyErr.Violations,
+	})
+	return true
+}
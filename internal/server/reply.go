@@ -0,0 +1,266 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"mcp-gmail-server/internal/auth"
+	"mcp-gmail-server/internal/gmail"
+	"mcp-gmail-server/internal/mcp"
+	"mcp-gmail-server/internal/sendqueue"
+
+	gmailapi "google.golang.org/api/gmail/v1"
+)
+
+type replyRequest struct {
+	Account   string `json:"account"`
+	MessageID string `json:"message_id"`
+	Body      string `json:"body"`
+	// ReplyAll copies the other original recipients.
+	ReplyAll bool `json:"reply_all"`
+	// Forward-only fields
+	To                 string `json:"to"`
+	IncludeAttachments bool   `json:"include_attachments"`
+	// Draft saves the message as a draft instead of sending it.
+	Draft bool `json:"draft"`
+	// SendAt schedules the message through the send queue.
+	SendAt *time.Time `json:"send_at"`
+}
+
+// replyOps builds replies and forwards; draftsEnabled reflects whether the
+// gmail.compose scope is requested.
+type replyOps struct {
+	draftsEnabled bool
+}
+
+func (o replyOps) run(user *auth.User, apiKeyID int, forward bool, req replyRequest) (interface{}, error) {
+	if req.MessageID == "" {
+		return nil, errors.New("message_id is required")
+	}
+	if forward && req.To == "" {
+		return nil, errors.New("to is required")
+	}
+	if req.Draft && !o.draftsEnabled {
+		return nil, errors.New("drafts are not enabled on this server")
+	}
+
+	account, service, err := accountService(user.ID, req.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	var m *gmail.OutgoingMessage
+	if forward {
+		m, err = gmail.ComposeForward(service, req.MessageID, req.To, req.Body, req.IncludeAttachments)
+	} else {
+		m, err = gmail.ComposeReply(service, req.MessageID, account.EmailAddress, req.Body, req.ReplyAll)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Draft {
+		return saveDraft(user, apiKeyID, account, service, m)
+	}
+
+	return deliver(user, account, service, m, req.SendAt)
+}
+
+// sendRequest is a new message; see gmail.OutgoingMessage.
+type sendRequest struct {
+	Account string `json:"account"`
+	gmail.OutgoingMessage
+	// SendAt schedules the message through the send queue.
+	SendAt *time.Time `json:"send_at"`
+}
+
+func sendMessage(user *auth.User, req sendRequest) (interface{}, error) {
+	account, service, err := accountService(user.ID, req.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliver(user, account, service, &req.OutgoingMessage, req.SendAt)
+}
+
+// deliver sends m now, or queues it when sendAt is in the future.
+func deliver(user *auth.User, account *auth.Account, service *gmailapi.Service, m *gmail.OutgoingMessage, sendAt *time.Time) (interface{}, error) {
+	if sendAt != nil && sendAt.After(time.Now()) {
+		return sendqueue.Enqueue(user.ID, account.ID, sendqueue.KindUser, m, *sendAt)
+	}
+
+	sent, err := gmail.Send(service, m)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"message_id": sent.Id, "thread_id": sent.ThreadId}, nil
+}
+
+// registerReplyRoutes mounts:
+//
+//	POST /messages/send          send a new message (HTML, attachments)
+//	POST /messages/{id}/reply    reply or reply-all (send or draft)
+//	POST /messages/{id}/forward  forward, optionally with attachments
+func registerReplyRoutes(mux *http.ServeMux, ops replyOps) {
+
+	mux.HandleFunc("/messages/send", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := auth.GetUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !requireScope(w, r, auth.ScopeSend) {
+			return
+		}
+
+		// Leave room for base64 attachments in the JSON body
+		r.Body = http.MaxBytesReader(w, r.Body, 2*gmail.MaxMessageSize)
+
+		var req sendRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		result, err := sendMessage(user, req)
+		if err != nil {
+			writeActionError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+
+	handler := func(forward bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+				return
+			}
+
+			user, err := auth.GetUser(r)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !requireScope(w, r, auth.ScopeSend) {
+				return
+			}
+			keyID := auth.GetAPIKeyID(r)
+
+			var req replyRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			req.MessageID = r.PathValue("id")
+
+			result, err := ops.run(user, keyID, forward, req)
+			if err != nil {
+				writeActionError(w, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(result)
+		}
+	}
+
+	mux.HandleFunc("/messages/{id}/reply", handler(false))
+	mux.HandleFunc("/messages/{id}/forward", handler(true))
+}
+
+var sendAtProperty = map[string]interface{}{
+	"type":        "string",
+	"format":      "date-time",
+	"description": "RFC 3339 time to send at; omit to send now",
+}
+
+func registerReplyTools(ops replyOps) {
+	mcp.RegisterTool(&mcp.Tool{
+		Name:        "send_email",
+		Description: "Send a new email. Supports Cc/Bcc, an HTML body and base64-encoded attachments.",
+		InputSchema: mcp.ObjectSchema(map[string]interface{}{
+			"account":   accountProperty,
+			"to":        map[string]interface{}{"type": "string", "description": "Comma-separated recipients"},
+			"cc":        map[string]interface{}{"type": "string"},
+			"bcc":       map[string]interface{}{"type": "string"},
+			"subject":   map[string]interface{}{"type": "string"},
+			"body":      map[string]interface{}{"type": "string", "description": "Plain text body"},
+			"html_body": map[string]interface{}{"type": "string", "description": "Optional HTML alternative"},
+			"send_at":   sendAtProperty,
+			"attachments": map[string]interface{}{
+				"type": "array",
+				"items": mcp.ObjectSchema(map[string]interface{}{
+					"filename":   map[string]interface{}{"type": "string"},
+					"mime_type":  map[string]interface{}{"type": "string"},
+					"data":       map[string]interface{}{"type": "string", "description": "Base64 file content"},
+					"content_id": map[string]interface{}{"type": "string", "description": "Set for inline images (cid:)"},
+				}, "filename", "data"),
+			},
+		}, "to", "subject"),
+		Scope: auth.ScopeSend,
+		Handler: func(call *mcp.Call) (interface{}, error) {
+			var req sendRequest
+			if err := call.Decode(&req); err != nil {
+				return nil, err
+			}
+			return sendMessage(call.User, req)
+		},
+	})
+
+	common := func(extra map[string]interface{}) map[string]interface{} {
+		props := map[string]interface{}{
+			"account":    accountProperty,
+			"message_id": map[string]interface{}{"type": "string", "description": "Gmail ID of the message to respond to"},
+			"body":       map[string]interface{}{"type": "string"},
+			"draft":      map[string]interface{}{"type": "boolean", "description": "Save as a draft instead of sending"},
+			"send_at":    sendAtProperty,
+		}
+		for k, v := range extra {
+			props[k] = v
+		}
+		return props
+	}
+
+	mcp.RegisterTool(&mcp.Tool{
+		Name:        "reply_to_message",
+		Description: "Reply (or reply-all) to a message in its thread, quoting the original.",
+		InputSchema: mcp.ObjectSchema(common(map[string]interface{}{
+			"reply_all": map[string]interface{}{"type": "boolean"},
+		}), "message_id", "body"),
+		Scope: auth.ScopeSend,
+		Handler: func(call *mcp.Call) (interface{}, error) {
+			var req replyRequest
+			if err := call.Decode(&req); err != nil {
+				return nil, err
+			}
+			return ops.run(call.User, call.APIKeyID, false, req)
+		},
+	})
+
+	mcp.RegisterTool(&mcp.Tool{
+		Name:        "forward_message",
+		Description: "Forward a message, optionally with its attachments.",
+		InputSchema: mcp.ObjectSchema(common(map[string]interface{}{
+			"to":                  map[string]interface{}{"type": "string", "description": "Comma-separated recipients"},
+			"include_attachments": map[string]interface{}{"type": "boolean"},
+		}), "message_id", "to"),
+		Scope: auth.ScopeSend,
+		Handler: func(call *mcp.Call) (interface{}, error) {
+			var req replyRequest
+			if err := call.Decode(&req); err != nil {
+				return nil, err
+			}
+			return ops.run(call.User, call.APIKeyID, true, req)
+		},
+	})
+}
@@ -1,14 +1,13 @@
 package server
 
 import (
-	"bytes"
-	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -17,9 +16,10 @@ import (
 	"mcp-gmail-server/internal/db"
 	"mcp-gmail-server/internal/gmail"
 	"mcp-gmail-server/internal/llm"
+	"mcp-gmail-server/internal/mailsync"
 	"mcp-gmail-server/internal/mcp"
 
-	"golang.org/x/oauth2"
+	gmailapi "google.golang.org/api/gmail/v1"
 )
 
 // var oauthToken *oauth2.Token
@@ -30,7 +30,8 @@ func RegisterRoutes(cfg *config.Config) {
 
 	mux := http.NewServeMux()
 
-	oauthConfig := gmail.GetOAuthConfig(
+	// Optional scopes were added to auth.GmailScopes by main
+	oauthConfig := auth.NewOAuthConfig(
 		cfg.ClientID,
 		cfg.ClientSecret,
 		cfg.RedirectURL,
@@ -44,6 +45,17 @@ func RegisterRoutes(cfg *config.Config) {
 		cookieSameSite = http.SameSiteNoneMode
 	}
 
+	flows := &oauthFlows{
+		origin:   cfg.AllowedOrigin,
+		secure:   useSecureCookie,
+		sameSite: cookieSameSite,
+	}
+
+	idTokens := &auth.IDTokenVerifier{Keys: auth.NewJWKSCache(cfg.GoogleJWKSURL)}
+
+	// Headless clients may present a Google access token issued to our client
+	auth.InitAccessTokens(auth.NewAccessTokenVerifier(cfg.GoogleTokenInfoURL, cfg.ClientID))
+
 	// -------------------------
 	// PUBLIC ROUTES
 	// -------------------------
@@ -56,19 +68,20 @@ func RegisterRoutes(cfg *config.Config) {
 		finalConfig := oauthConfig
 
 		// Check if user is logged in
-		cookie, err := r.Cookie("auth_token")
-		if err == nil {
-			claims, err := auth.ValidateToken(cookie.Value)
-			if err == nil {
-				user, err := auth.GetUserFromDB(claims.Email)
-				if err == nil && user.GoogleClientID != "" && user.GoogleClientSecret != "" {
-					// User has custom keys, use them!
-					finalConfig = auth.BuildOAuthConfig(user)
-				}
-			}
+		user, err := sessionUser(r)
+		if err != nil {
+			user = nil
+		} else if user.GoogleClientID != "" && user.GoogleClientSecret != "" {
+			// User has custom keys, use them!
+			finalConfig = auth.BuildOAuthConfig(user)
 		}
 
-		url := gmail.GetAuthURL(finalConfig)
+		// Bind the flow to this browser (state cookie + PKCE)
+		url, err := flows.start(w, finalConfig, user, r.URL.Query().Get("redirect"))
+		if err != nil {
+			http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+			return
+		}
 		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 	})
 
@@ -93,6 +106,12 @@ func RegisterRoutes(cfg *config.Config) {
 			return
 		}
 
+		// Length, common and breached passwords (see auth.CheckPassword)
+		if err := auth.CheckPassword(body.Password, body.Email); err != nil {
+			writePasswordPolicyError(w, err)
+			return
+		}
+
 		// Hash password
 		hash, err := auth.HashPassword(body.Password)
 		if err != nil {
@@ -110,8 +129,52 @@ func RegisterRoutes(cfg *config.Config) {
 			return
 		}
 
+		// Gmail and search stay locked until the address is confirmed
+		if user, err := auth.GetUserFromDB(body.Email); err != nil {
+			log.Printf("Signup lookup failed: %v", err)
+		} else if err := auth.SendVerificationEmail(user); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+		}
+
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(map[string]string{"message": "User created successfully"})
+		json.NewEncoder(w).Encode(map[string]string{"message": "User created successfully, check your email to verify your address"})
+	})
+
+	mux.HandleFunc("/auth/verify-email", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		user, err := auth.VerifyEmail(body.Token)
+		if err != nil {
+			if !errors.Is(err, auth.ErrVerificationInvalid) {
+				log.Printf("Email verification failed: %v", err)
+			}
+			http.Error(w, auth.ErrVerificationInvalid.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("User %d verified their email address", user.ID)
+
+		json.NewEncoder(w).Encode(map[string]string{"message": "Email address verified"})
+	})
+
+	// Rules for new passwords, for signup and reset forms
+	mux.HandleFunc("/auth/password-policy", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(auth.GetPasswordPolicy())
 	})
 
 	mux.HandleFunc("/auth/login", func(w http.ResponseWriter, r *http.Request) {
@@ -133,23 +196,61 @@ func RegisterRoutes(cfg *config.Config) {
 			return
 		}
 
+		// Slow down password guessing, per IP and per account
+		ip := auth.ClientIP(r)
+		if wait := auth.LoginWait(ip, body.Email); wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+
 		// Get User
 		user, err := auth.GetUserFromDB(body.Email)
 		if err != nil {
-			// Use generic error message for security
+			// Use generic error message for security, and take as long as
+			// a real check
+			auth.SpendPasswordCheck(body.Password)
+			auth.RecordLoginFailure(ip, body.Email)
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
 
 		// Verify Password
 		if !auth.CheckPasswordHash(body.Password, user.PasswordHash) {
+			if user.PasswordHash == "" {
+				auth.SpendPasswordCheck(body.Password)
+			}
+			auth.RecordLoginFailure(ip, body.Email)
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
 
-		// Generate JWT
-		tokenString, err := auth.GenerateToken(user.ID, user.Email)
+		// Deactivated by an admin
+		if !user.Active {
+			http.Error(w, "Account deactivated", http.StatusForbidden)
+			return
+		}
+
+		// Enrolled users finish at /auth/login/mfa with a code
+		if user.MFAEnabled {
+			challenge, err := auth.IssueMFAChallenge(user)
+			if err != nil {
+				log.Printf("Failed to issue MFA challenge for user %d: %v", user.ID, err)
+				http.Error(w, "Token generation failed", 500)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"mfa_required": true,
+				"mfa_token":    challenge,
+			})
+			return
+		}
+
+		auth.RecordLoginSuccess(user.Email)
+
+		// Start a stored session and sign its JWT
+		tokenString, _, err := auth.StartSession(user, r)
 		if err != nil {
+			log.Printf("Failed to start session for user %d: %v", user.ID, err)
 			http.Error(w, "Token generation failed", 500)
 			return
 		}
@@ -169,7 +270,75 @@ func RegisterRoutes(cfg *config.Config) {
 		json.NewEncoder(w).Encode(map[string]string{"message": "Login successful"})
 	})
 
+	// Second login step for users with two-factor authentication
+	mux.HandleFunc("/auth/login/mfa", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			MFAToken     string `json:"mfa_token"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.MFAToken == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		user, err := auth.ParseMFAChallenge(body.MFAToken)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !user.Active {
+			http.Error(w, "Account deactivated", http.StatusForbidden)
+			return
+		}
+
+		// Codes are guessed like passwords and count towards the same limits
+		ip := auth.ClientIP(r)
+		if wait := auth.LoginWait(ip, user.Email); wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+
+		if err := auth.VerifyMFA(user, body.Code, body.RecoveryCode); err != nil {
+			if !errors.Is(err, auth.ErrMFAInvalidCode) {
+				log.Printf("MFA check failed for user %d: %v", user.ID, err)
+			}
+			auth.RecordLoginFailure(ip, user.Email)
+			http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+			return
+		}
+		auth.RecordLoginSuccess(user.Email)
+
+		tokenString, _, err := auth.StartSession(user, r)
+		if err != nil {
+			log.Printf("Failed to start session for user %d: %v", user.ID, err)
+			http.Error(w, "Token generation failed", 500)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     "auth_token",
+			Value:    tokenString,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   useSecureCookie,
+			SameSite: cookieSameSite,
+		})
+
+		json.NewEncoder(w).Encode(map[string]string{"message": "Login successful"})
+	})
+
 	mux.HandleFunc("/auth/logout", func(w http.ResponseWriter, r *http.Request) {
+		// Revoke the stored session so a copied token stops working too
+		if err := auth.EndSession(r); err != nil {
+			log.Printf("Failed to revoke session on logout: %v", err)
+		}
+
 		// Clear Cookie
 		http.SetCookie(w, &http.Cookie{
 			Name:     "auth_token",
@@ -191,6 +360,11 @@ func RegisterRoutes(cfg *config.Config) {
 			return
 		}
 
+		if wait := auth.ResetRequestWait(auth.ClientIP(r)); wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+
 		var body struct {
 			Email string `json:"email"`
 		}
@@ -199,18 +373,19 @@ func RegisterRoutes(cfg *config.Config) {
 			return
 		}
 
-		token, err := auth.GenerateResetToken(body.Email)
-		if err != nil {
-			// Don't reveal if user exists
-			log.Printf("Forgot password error for %s: %v", body.Email, err)
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(map[string]string{"message": "If this email is registered, you will receive a reset link."})
-			return
+		// Don't reveal if user exists: the answer is the same, and the work
+		// happens after responding so it takes no longer either
+		if auth.ResetEmailAllowed(body.Email) {
+			go func(email string) {
+				token, err := auth.GenerateResetToken(email)
+				if err != nil {
+					log.Printf("Forgot password request not sent: %v", err)
+					return
+				}
+				auth.SendResetEmail(email, token)
+			}(body.Email)
 		}
 
-		// Send Email (Mock -> Gmail API)
-		auth.SendResetEmail(body.Email, token)
-
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{"message": "If this email is registered, you will receive a reset link."})
 	})
@@ -230,9 +405,20 @@ func RegisterRoutes(cfg *config.Config) {
 			return
 		}
 
+		// Slow down token guessing
+		ip := auth.ClientIP(r)
+		if wait := auth.ResetRedeemWait(ip); wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+
 		err := auth.ResetPassword(body.Token, body.NewPassword)
+		if writePasswordPolicyError(w, err) {
+			return
+		}
 		if err != nil {
 			log.Printf("Reset password error: %v", err)
+			auth.RecordResetFailure(ip)
 			http.Error(w, "Invalid or expired token", http.StatusBadRequest)
 			return
 		}
@@ -243,6 +429,12 @@ func RegisterRoutes(cfg *config.Config) {
 
 	mux.HandleFunc("/oauth/callback", func(w http.ResponseWriter, r *http.Request) {
 
+		// Google reports a denied consent as ?error=access_denied
+		if errParam := r.URL.Query().Get("error"); errParam != "" {
+			flows.fail(w, r, "oauth_"+errParam, errors.New(errParam))
+			return
+		}
+
 		code := r.URL.Query().Get("code")
 		if code == "" {
 			http.Error(w, "Missing code", 400)
@@ -254,12 +446,15 @@ func RegisterRoutes(cfg *config.Config) {
 		conf := oauthConfig
 		var currentUser *auth.User
 
-		cookie, err := r.Cookie("auth_token")
-		if err == nil {
-			claims, err := auth.ValidateToken(cookie.Value)
-			if err == nil {
-				currentUser, _ = auth.GetUserFromDB(claims.Email) // Ignore err, handled below
-			}
+		if user, err := sessionUser(r); err == nil {
+			currentUser = user
+		}
+
+		// Reject callbacks this browser didn't start (login CSRF)
+		flow, err := flows.finish(w, r, currentUser)
+		if err != nil {
+			flows.fail(w, r, "invalid_state", err)
+			return
 		}
 
 		// If we found a logged-in user with custom keys, use them!
@@ -267,107 +462,90 @@ func RegisterRoutes(cfg *config.Config) {
 			conf = auth.BuildOAuthConfig(currentUser)
 		}
 
-		// 2️⃣ Exchange Code for Token
-		token, err := conf.Exchange(context.Background(), code)
+		// 2️⃣ Exchange Code for Token (with the PKCE verifier)
+		token, err := flow.Exchange(conf, code)
 		if err != nil {
 			log.Printf("Token exchange error: %v", err)
 			http.Redirect(w, r, cfg.AllowedOrigin+"?error=token_exchange_failed", http.StatusTemporaryRedirect)
 			return
 		}
 
-		// 3️⃣ Fetch User Info (Verify Identity)
-		// Use manual request to ensure Authorization header is set correctly
-		req, err := http.NewRequest("GET", "https://www.googleapis.com/oauth2/v3/userinfo", nil)
+		// 3️⃣ Verify Identity from the ID token (signature, audience, issuer,
+		// expiry, email_verified)
+		identity, err := idTokens.VerifyToken(token, conf.ClientID)
 		if err != nil {
-			log.Printf("Failed to create userinfo request: %v", err)
-			http.Error(w, "Failed to create request", 500)
+			log.Printf("ID token verification failed: %v", err)
+			code := "invalid_id_token"
+			if errors.Is(err, auth.ErrEmailNotVerified) {
+				code = "email_not_verified"
+			}
+			http.Redirect(w, r, cfg.AllowedOrigin+"?error="+code, http.StatusTemporaryRedirect)
 			return
 		}
 
-		log.Printf("DEBUG: AccessToken (len=%d): %s...", len(token.AccessToken), token.AccessToken[:10])
-		log.Printf("DEBUG: TokenType: %s", token.TokenType)
-		log.Printf("DEBUG: RefreshToken: %s", token.RefreshToken)
-		log.Printf("DEBUG: Expiry: %v", token.Expiry)
-
-		if token.AccessToken == "" {
-			log.Println("❌ OAuth Error: Access Token is empty after exchange!")
-			http.Redirect(w, r, cfg.AllowedOrigin+"?error=empty_token", http.StatusTemporaryRedirect)
-			return
+		// 4️⃣ Connect the mailbox
+		// Logic:
+		// - If user was logged in (currentUser), add/refresh the mailbox on THEIR account.
+		// - If not logged in, find the owner of this Google account's mailbox,
+		//   then a user with this login email, and only then create a new user.
+
+		targetUser := currentUser
+		if targetUser != nil && !targetUser.EmailVerified {
+			// Signing in to Google with the login address proves it too
+			if !strings.EqualFold(identity.Email, targetUser.Email) {
+				http.Redirect(w, r, cfg.AllowedOrigin+"?error=email_unverified", http.StatusTemporaryRedirect)
+				return
+			}
+			if err := auth.MarkEmailVerified(targetUser); err != nil {
+				log.Printf("Failed to mark user %d verified: %v", targetUser.ID, err)
+				http.Error(w, "DB update failed", 500)
+				return
+			}
 		}
-
-		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
-
-		userInfo, err := http.DefaultClient.Do(req)
-		if err != nil {
-			log.Printf("UserInfo request failed: %v", err)
-			http.Redirect(w, r, cfg.AllowedOrigin+"?error=user_info_req_failed", http.StatusTemporaryRedirect)
-			return
+		if targetUser == nil {
+			targetUser, err = auth.ResolveGoogleLogin(identity)
+			if err != nil {
+				log.Printf("OAuth login lookup failed: %v", err)
+				http.Error(w, "User lookup failed", 500)
+				return
+			}
 		}
-		defer userInfo.Body.Close()
-
-		// Read body for debugging
-		bodyBytes, _ := io.ReadAll(userInfo.Body)
-		log.Printf("Google User Info Response (Status: %d): %s", userInfo.StatusCode, string(bodyBytes))
 
-		// Reset body for decoding
-		userInfo.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
-		var googleUser struct {
-			Email string `json:"email"`
-		}
-		if err := json.NewDecoder(userInfo.Body).Decode(&googleUser); err != nil {
-			log.Printf("Failed to decode google user: %v", err)
-			http.Error(w, "Failed to decode google user", 500)
+		if !targetUser.Active {
+			log.Printf("OAuth login refused for deactivated user %d", targetUser.ID)
+			http.Redirect(w, r, cfg.AllowedOrigin+"?error=account_deactivated", http.StatusTemporaryRedirect)
 			return
 		}
 
-		// 4️⃣ Update the User
-		// Logic:
-		// - If user was logged in (currentUser), update THEIR record.
-		// - If not logged in (public oauth login?), update based on Google Email.
-
-		targetEmail := googleUser.Email
-		if currentUser != nil {
-			targetEmail = currentUser.Email
-		}
-
-		if targetEmail == "" {
-			log.Println("❌ OAuth Error: Could not determine user email (Check scopes/cookies)")
-			http.Redirect(w, r, cfg.AllowedOrigin+"?error=email_missing", http.StatusTemporaryRedirect)
+		// A Google sign-in stands in for the password only: enrolled users
+		// still finish at /auth/login/mfa, as after /auth/login. The
+		// mailbox can be connected again once they are signed in.
+		if currentUser == nil && targetUser.MFAEnabled {
+			challenge, err := auth.IssueMFAChallenge(targetUser)
+			if err != nil {
+				log.Printf("Failed to issue MFA challenge for user %d: %v", targetUser.ID, err)
+				http.Error(w, "Token generation failed", 500)
+				return
+			}
+			query := url.Values{"mfa_token": {challenge}, "next": {flow.Redirect}}
+			http.Redirect(w, r, cfg.AllowedOrigin+"?"+query.Encode(), http.StatusTemporaryRedirect)
 			return
 		}
 
-		_, err = db.DB.Exec(`
-			INSERT INTO users (email, access_token, refresh_token, expiry)
-			VALUES (?, ?, ?, ?)
-			ON DUPLICATE KEY UPDATE 
-				access_token=VALUES(access_token),
-				refresh_token=VALUES(refresh_token),
-				expiry=VALUES(expiry)
-		`,
-			targetEmail,
-			token.AccessToken,
-			token.RefreshToken,
-			token.Expiry,
-		)
-
-		if err != nil {
+		if _, err := auth.UpsertAccount(targetUser.ID, identity, token); err != nil {
+			log.Printf("Failed to save mailbox account: %v", err)
 			http.Error(w, "DB update failed", 500)
 			return
 		}
 
 		// 5️⃣ Refresh Session
-		// Get User ID for the target email
-		var userID int
-		err = db.DB.QueryRow("SELECT id FROM users WHERE email = ?", targetEmail).Scan(&userID)
-		if err != nil {
-			http.Error(w, "User lookup failed", 500)
-			return
+		// Replace the browser's current session with one for the TARGET user
+		if err := auth.EndSession(r); err != nil {
+			log.Printf("Failed to end previous session: %v", err)
 		}
-
-		// Generate JWT for the TARGET user
-		tokenString, err := auth.GenerateToken(userID, targetEmail)
+		tokenString, _, err := auth.StartSession(targetUser, r)
 		if err != nil {
+			log.Printf("Failed to start session for user %d: %v", targetUser.ID, err)
 			http.Error(w, "Token generation failed", 500)
 			return
 		}
@@ -383,7 +561,7 @@ func RegisterRoutes(cfg *config.Config) {
 			// No Expires means Session Cookie
 		})
 
-		http.Redirect(w, r, cfg.AllowedOrigin, http.StatusTemporaryRedirect)
+		http.Redirect(w, r, cfg.AllowedOrigin+flow.Redirect, http.StatusTemporaryRedirect)
 	})
 
 	http.HandleFunc("/privacy", func(w http.ResponseWriter, r *http.Request) {
@@ -394,51 +572,34 @@ func RegisterRoutes(cfg *config.Config) {
 		w.Write([]byte("This app accesses Gmail data only for the authenticated user and does not store or share any data."))
 	})
 
-	// -------------------------
-	// PROTECTED ROUTES
-	// -------------------------
-
-	searchHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		// 1️⃣ Get logged-in user from JWT cookie
-		cookie, err := r.Cookie("auth_token")
-		if err != nil {
-			log.Printf("DEBUG: searchHandler: No auth_token cookie found: %v", err)
-			http.Error(w, "Unauthorized: No cookie", http.StatusUnauthorized)
+	// Public keys for verifying our JWTs (EdDSA/RS256 keys only)
+	http.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
 			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(auth.PublicJWKS())
+	})
 
-		// log.Printf("DEBUG: searchHandler: Cookie found: %s...", cookie.Value[:15])
+	// -------------------------
+	// PROTECTED ROUTES
+	// -------------------------
+	// Registered on api, which is mounted behind auth.Middleware: callers
+	// may use the session cookie, an API key or a Google access token.
 
-		claims, err := auth.ValidateToken(cookie.Value)
-		if err != nil {
-			log.Printf("DEBUG: searchHandler: Token validation failed: %v", err)
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
+	api := http.NewServeMux()
 
-		log.Printf("DEBUG: searchHandler: Valid token for user: %s", claims.Email)
+	searchHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		user, err := auth.GetUserFromDB(claims.Email)
+		// 1️⃣ Caller resolved by auth.Middleware (cookie, API key or access token)
+		user, err := auth.GetUser(r)
 		if err != nil {
-			log.Printf("DEBUG: searchHandler: User not found in DB for email %s: %v", claims.Email, err)
-			http.Error(w, "User not found", http.StatusUnauthorized)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-
-		// 2️⃣ Build OAuth config dynamically
-		oauthConfig := auth.BuildOAuthConfig(user)
-
-		token := &oauth2.Token{
-			AccessToken:  user.AccessToken,
-			RefreshToken: user.RefreshToken,
-			Expiry:       user.Expiry,
-		}
-
-		// 3️⃣ Create Gmail service correctly
-		service, err := gmail.NewGmailService(oauthConfig, token)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Gmail service error: %v", err), 500)
+		if !requireScope(w, r, auth.ScopeSearch) {
 			return
 		}
 
@@ -448,6 +609,30 @@ func RegisterRoutes(cfg *config.Config) {
 			return
 		}
 
+		// 2️⃣ Pick the mailbox(es) to search
+		// account=<id> targets one mailbox, account=all fans out across all of them.
+		// Admins may instead open an allowlisted Workspace mailbox with mailbox=<address>;
+		// an API key needs the admin scope as well as search for that.
+		var accounts []*auth.Account
+		var delegated *gmailapi.Service
+		mailbox := r.URL.Query().Get("mailbox")
+		if mailbox != "" {
+			if _, ok := requireAdmin(w, r); !ok {
+				return
+			}
+			delegated, err = auth.OpenDelegatedMailbox(user, mailbox, "search", intent, auth.ClientIP(r))
+			if err != nil {
+				writeDelegationError(w, err)
+				return
+			}
+		} else {
+			accounts, err = selectAccounts(user.ID, r.URL.Query().Get("account"))
+			if err != nil {
+				writeAccountError(w, err)
+				return
+			}
+		}
+
 		// 4️⃣ Create LLM client
 		llmClient, err := llm.NewLLM()
 		if err != nil {
@@ -462,8 +647,21 @@ func RegisterRoutes(cfg *config.Config) {
 			return
 		}
 
-		// 6️⃣ Fetch emails (Concurrent & Full Body)
-		emails, err := gmail.FetchEmails(service, gmailQuery, limit)
+		// 6️⃣ Fetch emails from every selected mailbox
+		opts := fetchOptions{
+			Intent: intent,
+			Query:  gmailQuery,
+			Limit:  limit,
+			Hybrid: r.URL.Query().Get("mode") == "hybrid",
+			Local:  r.URL.Query().Get("source") == "local",
+		}
+		var emails []gmail.Email
+		if delegated != nil {
+			// Delegated mailboxes aren't mirrored locally, always ask Gmail
+			emails, err = gmail.FetchEmails(delegated, gmailQuery, limit)
+		} else {
+			emails, err = fetchFromAccounts(accounts, opts)
+		}
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Fetch emails error: %v", err), 500)
 			return
@@ -480,10 +678,13 @@ func RegisterRoutes(cfg *config.Config) {
 				content = e.Snippet
 			}
 
-			emailTexts = append(emailTexts,
-				fmt.Sprintf("From: %s\nSubject: %s\nDate: %s\nContent: %s",
-					e.From, e.Subject, e.Date, content),
-			)
+			text := fmt.Sprintf("From: %s\nSubject: %s\nDate: %s\nContent: %s",
+				e.From, e.Subject, e.Date, content)
+			if len(accounts) > 1 {
+				text = fmt.Sprintf("Account: %s\n%s", e.Account, text)
+			}
+
+			emailTexts = append(emailTexts, text)
 		}
 
 		// 7️⃣ Run extraction
@@ -493,61 +694,137 @@ func RegisterRoutes(cfg *config.Config) {
 			return
 		}
 
+		if delegated != nil {
+			result["mailbox"] = mailbox
+		}
+
+		if len(accounts) > 1 {
+			var names []string
+			for _, a := range accounts {
+				names = append(names, a.DisplayName())
+			}
+			result["searched_accounts"] = names
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(result)
 	})
 
-	// Register with Middleware?
-	// The auth logic is now inside the handler via JWT check.
-	// auth.Middleware checked API keys. We can strip it or keep it for other routes.
-	// Here we use searchHandler directly.
-	mux.Handle("/mcp/search", searchHandler)
+	api.Handle("/mcp/search", searchHandler)
 
 	mux.HandleFunc("/auth/status", func(w http.ResponseWriter, r *http.Request) {
 
-		cookie, err := r.Cookie("auth_token")
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(map[string]bool{"logged_in": false})
-			return
-		}
-
-		claims, err := auth.ValidateToken(cookie.Value)
+		// Answers logged_in=false instead of a bare 401, so it isn't behind
+		// the middleware
+		p, err := auth.Authenticate(r)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(map[string]bool{"logged_in": false})
 			return
 		}
+		user := p.User
 
 		var googleClientID sql.NullString
 		err = db.DB.QueryRow(`
 			SELECT google_client_id
 			FROM users
-			WHERE email = ?
-		`, claims.Email).Scan(&googleClientID)
+			WHERE id = ?
+		`, user.ID).Scan(&googleClientID)
 
 		// Note: The google_client_id check might be legacy or for a different flow.
 		// Since we use OAauth via /auth/callback, we know they are connected if they have a token.
 		// But let's keep the query valid.
 
 		// If user exists, they are logged in.
-		// Check if they have access token
-		var hasToken bool
-		err = db.DB.QueryRow("SELECT IF(access_token IS NOT NULL AND access_token != '', TRUE, FALSE) FROM users WHERE email = ?", claims.Email).Scan(&hasToken)
+		// Check if they have connected a mailbox
+		var accountCount int
+		err = db.DB.QueryRow(`
+			SELECT COUNT(*)
+			FROM mailbox_accounts
+			WHERE user_id = ? AND refresh_token IS NOT NULL AND refresh_token != ''
+		`, user.ID).Scan(&accountCount)
 
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
-		json.NewEncoder(w).Encode(map[string]bool{
+		// Mailboxes whose refresh token Google rejected
+		reconnect := []string{}
+		if accounts, err := auth.ListAccounts(user.ID); err == nil {
+			for _, a := range accounts {
+				if a.NeedsReconnect {
+					reconnect = append(reconnect, a.EmailAddress)
+				}
+			}
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
 			"logged_in":       true,
-			"gmail_connected": hasToken,
+			"auth_method":     p.Method,
+			"gmail_connected": accountCount > len(reconnect),
+			"gmail_accounts":  accountCount,
+			"needs_reconnect": reconnect,
 			"has_credentials": googleClientID.Valid && googleClientID.String != "",
+			"email_verified":  user.EmailVerified,
+			"mfa_enabled":     user.MFAEnabled,
+			"mfa_required":    auth.MFARequired(user.Role),
 		})
 	})
 
-	mux.Handle("/connect/google", http.HandlerFunc(auth.SaveGoogleCredentials))
+	api.HandleFunc("/connect/google", auth.SaveGoogleCredentials)
+
+	registerAccountRoutes(api, oauthConfig, flows)
+	registerAPIKeyRoutes(api)
+	registerSessionRoutes(api)
+	registerMFARoutes(api)
+	registerVerificationRoutes(api)
+	registerAdminRoutes(api)
+
+	// MCP JSON-RPC endpoint (tools/list, tools/call)
+	api.HandleFunc("/mcp", mcpHandler)
+
+	// Label/archive/trash operations need the opt-in gmail.modify scope
+	if cfg.ModifyEnabled {
+		registerActionRoutes(api)
+		registerActionTools()
+	}
+
+	// Replies and forwards only need gmail.send, unless saved as drafts
+	replies := replyOps{draftsEnabled: cfg.ComposeEnabled}
+	registerReplyRoutes(api, replies)
+	registerReplyTools(replies)
+	registerOutboxRoutes(api)
+	registerOutboxTools()
+
+	// Drafts need the opt-in gmail.compose scope
+	if cfg.ComposeEnabled {
+		registerDraftRoutes(api)
+		registerDraftTools()
+	}
+
+	// Everything not registered on mux is protected
+	mux.Handle("/", auth.Middleware(api))
+
+	// Gmail push notifications (Pub/Sub push subscription).
+	// Authenticated by the Google-signed token Pub/Sub attaches, not by cookie.
+	// Deliveries only trigger the sync worker, so they need it running.
+	if cfg.PubSubTopic != "" {
+		switch {
+		case !cfg.SyncEnabled:
+			log.Println("Warning: GMAIL_PUBSUB_TOPIC set without MAIL_SYNC_ENABLED; push endpoint disabled")
+		case cfg.PushAudience == "":
+			log.Println("Warning: GMAIL_PUBSUB_TOPIC set without PUBSUB_PUSH_AUDIENCE; push endpoint disabled")
+		case cfg.PushServiceAccount == "":
+			log.Println("Warning: GMAIL_PUBSUB_TOPIC set without PUBSUB_PUSH_SERVICE_ACCOUNT; push endpoint disabled")
+		default:
+			mux.Handle("/gmail/push", &mailsync.PushHandler{
+				Audience:       cfg.PushAudience,
+				ServiceAccount: cfg.PushServiceAccount,
+				Keys:           auth.NewJWKSCache(cfg.PushJWKSURL),
+			})
+		}
+	}
 
 	// Finally register mux globally
 	http.Handle("/", mux)
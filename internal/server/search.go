@@ -0,0 +1,130 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"mcp-gmail-server/internal/auth"
+	"mcp-gmail-server/internal/gmail"
+	"mcp-gmail-server/internal/search"
+)
+
+var errInvalidAccount = errors.New("invalid account")
+
+// selectAccounts resolves the "account" search parameter: empty means the
+// primary mailbox, "all" every connected mailbox, otherwise an account ID.
+func selectAccounts(userID int, param string) ([]*auth.Account, error) {
+	switch param {
+	case "":
+		account, err := auth.PrimaryAccount(userID)
+		if err != nil {
+			return nil, err
+		}
+		return []*auth.Account{account}, nil
+
+	case "all":
+		accounts, err := auth.ListAccounts(userID)
+		if err != nil {
+			return nil, err
+		}
+		if len(accounts) == 0 {
+			return nil, auth.ErrNoAccount
+		}
+		return accounts, nil
+	}
+
+	id, err := strconv.Atoi(param)
+	if err != nil {
+		return nil, errInvalidAccount
+	}
+	account, err := auth.GetAccount(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	return []*auth.Account{account}, nil
+}
+
+func writeAccountError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrNoAccount):
+		http.Error(w, "No Gmail account connected", http.StatusBadRequest)
+	case errors.Is(err, errInvalidAccount):
+		http.Error(w, "Invalid account", http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Account not found", http.StatusNotFound)
+	default:
+		log.Printf("Account lookup failed: %v", err)
+		http.Error(w, "Account lookup failed", http.StatusInternalServerError)
+	}
+}
+
+type fetchOptions struct {
+	Intent string
+	Query  string
+	Limit  int
+	// Hybrid adds semantic matches for Intent to the keyword results.
+	Hybrid bool
+	// Local answers from the synced mirror when the query allows it.
+	Local bool
+}
+
+// fetchFromAccounts runs the search against each mailbox concurrently and
+// concatenates the results, tagging each message with its mailbox.
+func fetchFromAccounts(accounts []*auth.Account, opts fetchOptions) ([]gmail.Email, error) {
+	results := make([][]gmail.Email, len(accounts))
+	errs := make([]error, len(accounts))
+
+	var wg sync.WaitGroup
+	for i, account := range accounts {
+		wg.Add(1)
+		go func(i int, account *auth.Account) {
+			defer wg.Done()
+			results[i], errs[i] = fetchFromAccount(account, opts)
+		}(i, account)
+	}
+	wg.Wait()
+
+	var emails []gmail.Email
+	for i, account := range accounts {
+		if errs[i] != nil {
+			// One broken mailbox shouldn't sink a fan-out search
+			if len(accounts) == 1 {
+				return nil, errs[i]
+			}
+			log.Printf("Search failed for account %d: %v", account.ID, errs[i])
+			continue
+		}
+		for _, e := range results[i] {
+			e.Account = account.DisplayName()
+			emails = append(emails, e)
+		}
+	}
+
+	return emails, nil
+}
+
+func fetchFromAccount(account *auth.Account, opts fetchOptions) ([]gmail.Email, error) {
+	service, err := auth.NewGmailServiceForAccount(account)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Hybrid {
+		return search.Hybrid(service, account.ID, opts.Intent, opts.Query, opts.Limit)
+	}
+
+	if opts.Local {
+		emails, err := search.Run(account.ID, opts.Query, opts.Limit)
+		if err == nil {
+			return emails, nil
+		}
+		log.Printf("Local search unavailable for account %d (%v), using Gmail API", account.ID, err)
+	}
+
+	// Concurrent & Full Body
+	return gmail.FetchEmails(service, opts.Query, opts.Limit)
+}
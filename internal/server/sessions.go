@@ -0,0 +1,91 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"mcp-gmail-server/internal/auth"
+)
+
+// sessionOwner resolves the caller for session management, which API keys
+// may not do: a leaked key shouldn't be able to sign its owner out.
+func sessionOwner(w http.ResponseWriter, r *http.Request) (*auth.Principal, bool) {
+	p := auth.GetPrincipal(r)
+	if p == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	if p.Method == auth.MethodAPIKey {
+		http.Error(w, "Forbidden: sessions can't be managed with an API key", http.StatusForbidden)
+		return nil, false
+	}
+	return p, true
+}
+
+// registerSessionRoutes mounts the signed-in devices API:
+//
+//	GET    /auth/sessions       list your active sessions ("current" marks this one)
+//	DELETE /auth/sessions       sign out everywhere except this session
+//	DELETE /auth/sessions/{id}  revoke one session
+func registerSessionRoutes(mux *http.ServeMux) {
+
+	mux.HandleFunc("/auth/sessions", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := sessionOwner(w, r)
+		if !ok {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			sessions, err := auth.ListSessions(p.User.ID)
+			if err != nil {
+				log.Printf("Failed to list sessions: %v", err)
+				http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+				return
+			}
+			for _, s := range sessions {
+				s.Current = s.ID == p.SessionID
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(sessions)
+
+		case http.MethodDelete:
+			n, err := auth.RevokeSessions(p.User.ID, p.SessionID)
+			if err != nil {
+				log.Printf("Failed to revoke sessions: %v", err)
+				http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]int64{"revoked": n})
+
+		default:
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/auth/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+		p, ok := sessionOwner(w, r)
+		if !ok {
+			return
+		}
+
+		err := auth.RevokeSession(p.User.ID, r.PathValue("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to revoke session: %v", err)
+			http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
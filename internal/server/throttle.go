@@ -0,0 +1,15 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// tooManyAttempts answers a throttled request, with Retry-After in whole
+// seconds.
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many attempts, try again later", http.StatusTooManyRequests)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"mcp-gmail-server/internal/auth"
+)
+
+// registerVerificationRoutes mounts the signed-in half of email
+// verification (the link itself goes to the public /auth/verify-email):
+//
+//	POST /auth/verify-email/resend  send the verification link again
+func registerVerificationRoutes(mux *http.ServeMux) {
+
+	mux.HandleFunc("/auth/verify-email/resend", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+		user, err := auth.GetUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		err = auth.SendVerificationEmail(user)
+		switch {
+		case errors.Is(err, auth.ErrAlreadyVerified):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, auth.ErrVerificationRateLimited):
+			w.Header().Set("Retry-After", "120")
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		case errors.Is(err, auth.ErrNoSystemMailer):
+			log.Printf("Verification email for user %d not sent: %v", user.ID, err)
+			http.Error(w, "Email delivery is not configured", http.StatusServiceUnavailable)
+		case err != nil:
+			log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+			http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		default:
+			json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package throttle
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"mcp-gmail-server/internal/db"
+)
+
+// pruneInterval is how often DBStore deletes idle keys.
+const pruneInterval = 10 * time.Minute
+
+// DBStore keeps counters in the throttle table, shared by every instance.
+type DBStore struct {
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+func NewDBStore() *DBStore {
+	return &DBStore{}
+}
+
+func (s *DBStore) Get(key string, now time.Time) (Entry, error) {
+	var e Entry
+	var windowEnds time.Time
+	var lockedUntil sql.NullTime
+
+	err := db.DB.QueryRow(`
+		SELECT failures, last_failure, locked_until, window_ends
+		FROM throttle WHERE throttle_key = ?
+	`, key).Scan(&e.Failures, &e.LastFailure, &lockedUntil, &windowEnds)
+	if err == sql.ErrNoRows {
+		return Entry{}, nil
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+
+	e.LockedUntil = lockedUntil.Time
+	if !now.Before(windowEnds) {
+		e.Failures = 0
+	}
+	return e, nil
+}
+
+func (s *DBStore) Add(key string, now time.Time, window time.Duration) (Entry, error) {
+	s.prune(now)
+
+	// Assignments run left to right, so window_ends is updated last
+	_, err := db.DB.Exec(`
+		INSERT INTO throttle (throttle_key, failures, last_failure, window_ends)
+		VALUES (?, 1, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			failures = IF(window_ends <= ?, 1, failures + 1),
+			last_failure = ?,
+			window_ends = GREATEST(window_ends, ?)
+	`, key, now, now.Add(window), now, now, now.Add(window))
+	if err != nil {
+		return Entry{}, err
+	}
+	return s.Get(key, now)
+}
+
+func (s *DBStore) Lock(key string, until time.Time) error {
+	_, err := db.DB.Exec(`
+		UPDATE throttle
+		SET failures = 0, locked_until = ?, window_ends = GREATEST(window_ends, ?)
+		WHERE throttle_key = ?
+	`, until, until, key)
+	return err
+}
+
+func (s *DBStore) Reset(key string) error {
+	_, err := db.DB.Exec(`DELETE FROM throttle WHERE throttle_key = ?`, key)
+	return err
+}
+
+// prune deletes keys whose window and lock are both over, at most once per
+// pruneInterval. Errors are ignored, the next prune tries again.
+func (s *DBStore) prune(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastPruned) < pruneInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPruned = now
+	s.mu.Unlock()
+
+	db.DB.Exec(`
+		DELETE FROM throttle
+		WHERE window_ends < ? AND (locked_until IS NULL OR locked_until < ?)
+	`, now, now)
+}
@@ -0,0 +1,134 @@
+package throttle
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// pruneAbove is the entry count past which MemoryStore drops idle keys, at
+// most once per pruneInterval.
+const pruneAbove = 10000
+
+// maxEntries caps MemoryStore however many keys are still live, e.g. when
+// a flood of new keys keeps every window open.
+const maxEntries = 100000
+
+// MemoryStore keeps counters in process memory. Each instance counts on its
+// own, so use DBStore behind a load balancer.
+type MemoryStore struct {
+	mu         sync.Mutex
+	entries    map[string]*memoryEntry
+	lastPruned time.Time
+}
+
+type memoryEntry struct {
+	Entry
+	windowEnds time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]*memoryEntry{}}
+}
+
+// live returns e with its failures cleared once the window has passed.
+func (e *memoryEntry) live(now time.Time) Entry {
+	entry := e.Entry
+	if !now.Before(e.windowEnds) {
+		entry.Failures = 0
+	}
+	return entry
+}
+
+func (s *MemoryStore) Get(key string, now time.Time) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return Entry{}, nil
+	}
+	return e.live(now), nil
+}
+
+func (s *MemoryStore) Add(key string, now time.Time, window time.Duration) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		if len(s.entries) >= pruneAbove && now.Sub(s.lastPruned) >= pruneInterval {
+			s.prune(now)
+		}
+		if len(s.entries) >= maxEntries {
+			s.evict(now)
+		}
+		e = &memoryEntry{}
+		s.entries[key] = e
+	}
+
+	e.Entry = e.live(now)
+	e.Failures++
+	e.LastFailure = now
+	if end := now.Add(window); e.windowEnds.Before(end) {
+		e.windowEnds = end
+	}
+	return e.Entry, nil
+}
+
+func (s *MemoryStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		if len(s.entries) >= maxEntries {
+			s.evict(time.Now())
+		}
+		e = &memoryEntry{}
+		s.entries[key] = e
+	}
+	e.Failures = 0
+	e.LockedUntil = until
+	if e.windowEnds.Before(until) {
+		e.windowEnds = until
+	}
+	return nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// prune drops keys whose window and lock are both over.
+func (s *MemoryStore) prune(now time.Time) {
+	s.lastPruned = now
+	for key, e := range s.entries {
+		if !now.Before(e.windowEnds) && !now.Before(e.LockedUntil) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// evict drops the tenth of the keys that failed longest ago, keys that
+// are locked last, so a flood of new keys can't lift an earned lockout.
+func (s *MemoryStore) evict(now time.Time) {
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := s.entries[keys[i]], s.entries[keys[j]]
+		if aLocked, bLocked := now.Before(a.LockedUntil), now.Before(b.LockedUntil); aLocked != bLocked {
+			return bLocked
+		}
+		return a.LastFailure.Before(b.LastFailure)
+	})
+	for _, key := range keys[:len(keys)/10+1] {
+		delete(s.entries, key)
+	}
+}
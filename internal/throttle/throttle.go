@@ -0,0 +1,104 @@
+// Package throttle slows down and locks out repeated failures, such as
+// wrong passwords. Counters live in a Store: MemoryStore for a single
+// instance, DBStore when several instances share the load.
+package throttle
+
+import "time"
+
+// Entry is the state of one throttled key.
+type Entry struct {
+	// Failures within the current window
+	Failures int
+	// LastFailure is when the latest failure was counted
+	LastFailure time.Time
+	// LockedUntil is set while the key is locked out
+	LockedUntil time.Time
+}
+
+// Store keeps failure counters. A key's window slides: its failures are
+// forgotten once window passes without a new one.
+type Store interface {
+	Get(key string, now time.Time) (Entry, error)
+	// Add counts a failure and returns the updated entry.
+	Add(key string, now time.Time, window time.Duration) (Entry, error)
+	// Lock locks the key out until until and clears its failures, so the
+	// count starts afresh once the lock ends.
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+}
+
+// Policy says how a Limiter reacts to failures.
+type Policy struct {
+	// Window is how long failures are remembered
+	Window time.Duration
+	// Free is how many failures are allowed before delays start
+	Free int
+	// Delay doubles with every failure past Free, up to MaxDelay
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// LockAfter failures lock the key for LockFor (0 never locks)
+	LockAfter int
+	LockFor   time.Duration
+}
+
+// Limiter applies a Policy to keys in a Store. Keys share the store, so
+// give each Limiter its own key prefix.
+type Limiter struct {
+	Store  Store
+	Policy Policy
+	Prefix string
+}
+
+// Wait reports how long key must wait before its next attempt (0 when it
+// may go ahead now).
+func (l *Limiter) Wait(key string) (time.Duration, error) {
+	now := time.Now()
+	e, err := l.Store.Get(l.Prefix+key, now)
+	if err != nil {
+		return 0, err
+	}
+	return l.wait(e, now), nil
+}
+
+func (l *Limiter) wait(e Entry, now time.Time) time.Duration {
+	if now.Before(e.LockedUntil) {
+		return e.LockedUntil.Sub(now)
+	}
+
+	over := e.Failures - l.Policy.Free
+	if over <= 0 || l.Policy.Delay <= 0 {
+		return 0
+	}
+	delay := l.Policy.MaxDelay
+	if over < 31 {
+		if d := l.Policy.Delay << (over - 1); d > 0 && d < delay {
+			delay = d
+		}
+	}
+	if ready := e.LastFailure.Add(delay); now.Before(ready) {
+		return ready.Sub(now)
+	}
+	return 0
+}
+
+// Fail counts a failure for key and reports whether it locked the key.
+func (l *Limiter) Fail(key string) (bool, error) {
+	now := time.Now()
+	e, err := l.Store.Add(l.Prefix+key, now, l.Policy.Window)
+	if err != nil {
+		return false, err
+	}
+
+	if l.Policy.LockAfter > 0 && e.Failures >= l.Policy.LockAfter {
+		if err := l.Store.Lock(l.Prefix+key, now.Add(l.Policy.LockFor)); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Reset forgets key's failures and lifts any lock.
+func (l *Limiter) Reset(key string) error {
+	return l.Store.Reset(l.Prefix + key)
+}
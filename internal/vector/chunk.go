@@ -0,0 +1,40 @@
+package vector
+
+import (
+	"fmt"
+	"strings"
+
+	"mcp-gmail-server/internal/gmail"
+)
+
+const (
+	chunkSize    = 1200
+	chunkOverlap = 200
+	// maxChunks bounds the embedding cost of very long messages.
+	maxChunks = 8
+)
+
+// chunkEmail splits a message into overlapping windows of text. Each chunk
+// is prefixed with the headers so it carries context on its own.
+func chunkEmail(e *gmail.Email) []string {
+	header := fmt.Sprintf("From: %s\nTo: %s\nSubject: %s\n\n", e.From, e.To, e.Subject)
+
+	body := []rune(strings.TrimSpace(e.Body))
+	if len(body) == 0 {
+		body = []rune(e.Snippet)
+	}
+
+	var chunks []string
+	for start := 0; len(chunks) < maxChunks; start += chunkSize - chunkOverlap {
+		end := start + chunkSize
+		if end > len(body) {
+			end = len(body)
+		}
+		chunks = append(chunks, header+string(body[start:end]))
+		if end == len(body) {
+			break
+		}
+	}
+
+	return chunks
+}
@@ -0,0 +1,197 @@
+package vector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"mcp-gmail-server/internal/llm"
+	"mcp-gmail-server/internal/mailsync"
+)
+
+// ErrDisabled is returned when no embedding provider is configured.
+var ErrDisabled = errors.New("semantic search is not configured")
+
+// sweepInterval is how often the worker looks for mirrored messages that
+// still need embedding (after a backfill, a restart or a provider outage).
+const sweepInterval = 10 * time.Minute
+
+var (
+	embedder llm.Embedder
+
+	storesMu sync.Mutex
+	stores   = make(map[int]*Store)
+
+	wake = make(chan struct{}, 1)
+)
+
+// Attach enables semantic search with the given provider and subscribes to
+// the sync stream. Call it once before mailsync.Start.
+func Attach(e llm.Embedder) {
+	embedder = e
+
+	mailsync.Subscribe(func(ev mailsync.Event) {
+		switch ev.Type {
+		case mailsync.EventAdded:
+			// Embedding is slow; let the worker pick it up.
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		case mailsync.EventDeleted:
+			if err := deleteChunks(ev.AccountID, ev.MessageID); err != nil {
+				log.Printf("vector: failed to delete chunks for %s: %v", ev.MessageID, err)
+			}
+			if s := loadedStore(ev.AccountID); s != nil {
+				s.remove(ev.MessageID)
+			}
+		case mailsync.EventReset:
+			// The mirror is rebuilt from scratch, so start over too rather
+			// than keep vectors of messages that may be gone
+			if err := deleteAccountChunks(ev.AccountID); err != nil {
+				log.Printf("vector: failed to delete chunks for account %d: %v", ev.AccountID, err)
+			}
+			Forget(ev.AccountID)
+		}
+	})
+}
+
+// Start runs the embedding worker until ctx is cancelled.
+func Start(ctx context.Context) {
+	if embedder == nil {
+		return
+	}
+
+	log.Printf("vector: embedding worker started (model %s)", embedder.Model())
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		embedPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+func embedPending(ctx context.Context) {
+	model := embedder.Model()
+
+	for ctx.Err() == nil {
+		batch, err := unembedded(model, 50)
+		if err != nil {
+			log.Printf("vector: failed to list pending messages: %v", err)
+			return
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		// Failures are put aside with a backoff, so the next round moves on
+		// to other messages instead of retrying the same batch
+		embedded := 0
+		for _, p := range batch {
+			if err := embedMessage(p); err != nil {
+				log.Printf("vector: failed to embed %s for account %d: %v", p.messageID, p.accountID, err)
+				if err := recordFailure(p, model, err); err != nil {
+					log.Printf("vector: failed to record embedding failure for %s: %v", p.messageID, err)
+				}
+				continue
+			}
+			if err := clearFailure(p, model); err != nil {
+				log.Printf("vector: failed to clear embedding failure for %s: %v", p.messageID, err)
+			}
+			embedded++
+		}
+
+		// Everything failed: the provider is likely down, retry next sweep.
+		if embedded == 0 {
+			return
+		}
+	}
+}
+
+func embedMessage(p pending) error {
+	emails, err := mailsync.LoadMessages(p.accountID, []string{p.messageID})
+	if err != nil {
+		return err
+	}
+	if len(emails) == 0 {
+		return fmt.Errorf("message %s is not in the mirror", p.messageID)
+	}
+
+	contents := chunkEmail(&emails[0])
+	vectors, err := embedder.Embed(contents)
+	if err != nil {
+		return err
+	}
+
+	if err := saveChunks(p, embedder.Model(), contents, vectors); err != nil {
+		return err
+	}
+
+	if s := loadedStore(p.accountID); s != nil {
+		s.remove(p.messageID)
+		for _, v := range vectors {
+			s.add(p.messageID, v)
+		}
+	}
+	return nil
+}
+
+func loadedStore(accountID int) *Store {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	return stores[accountID]
+}
+
+func storeFor(accountID int) (*Store, error) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	if s, ok := stores[accountID]; ok {
+		return s, nil
+	}
+
+	s, err := loadStore(accountID, embedder.Model())
+	if err != nil {
+		return nil, err
+	}
+	stores[accountID] = s
+	return s, nil
+}
+
+// Forget drops the mailbox's vectors from memory, e.g. once the mailbox is
+// disconnected.
+func Forget(accountID int) {
+	storesMu.Lock()
+	delete(stores, accountID)
+	storesMu.Unlock()
+}
+
+// Search embeds text and returns the mailbox's k most similar messages.
+func Search(accountID int, text string, k int) ([]Hit, error) {
+	if embedder == nil {
+		return nil, ErrDisabled
+	}
+
+	vectors, err := embedder.Embed([]string{text})
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := storeFor(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Search(vectors[0], k), nil
+}
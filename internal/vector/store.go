@@ -0,0 +1,261 @@
+package vector
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"mcp-gmail-server/internal/db"
+)
+
+type chunk struct {
+	messageID string
+	vec       []float32
+}
+
+// Store holds one mailbox's chunk vectors in memory. Vectors are normalised
+// on load so cosine similarity is a plain dot product.
+type Store struct {
+	mu     sync.RWMutex
+	chunks []chunk
+}
+
+// Hit is a message ranked by its best-matching chunk.
+type Hit struct {
+	MessageID string  `json:"message_id"`
+	Score     float64 `json:"score"`
+}
+
+func (s *Store) add(messageID string, vec []float32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chunks = append(s.chunks, chunk{messageID: messageID, vec: normalize(vec)})
+}
+
+func (s *Store) remove(messageID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.chunks[:0]
+	for _, c := range s.chunks {
+		if c.messageID != messageID {
+			kept = append(kept, c)
+		}
+	}
+	s.chunks = kept
+}
+
+// Search returns the k messages whose chunks are most similar to query.
+func (s *Store) Search(query []float32, k int) []Hit {
+	q := normalize(query)
+
+	s.mu.RLock()
+	best := make(map[string]float64)
+	for _, c := range s.chunks {
+		if len(c.vec) != len(q) {
+			continue
+		}
+		score := dot(c.vec, q)
+		if prev, ok := best[c.messageID]; !ok || score > prev {
+			best[c.messageID] = score
+		}
+	}
+	s.mu.RUnlock()
+
+	hits := make([]Hit, 0, len(best))
+	for id, score := range best {
+		hits = append(hits, Hit{MessageID: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+
+	if k > 0 && len(hits) > k {
+		hits = hits[:k]
+	}
+	return hits
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+func normalize(v []float32) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return v
+	}
+
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(float64(x) / norm)
+	}
+	return out
+}
+
+// encode/decode store vectors as little-endian float32 blobs.
+func encode(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(x))
+	}
+	return b
+}
+
+func decode(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
+
+func loadStore(accountID int, model string) (*Store, error) {
+	rows, err := db.DB.Query(`
+		SELECT gmail_id, embedding
+		FROM message_chunks
+		WHERE account_id = ? AND model = ?
+	`, accountID, model)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	s := &Store{}
+	for rows.Next() {
+		var id string
+		var blob []byte
+		if err := rows.Scan(&id, &blob); err != nil {
+			return nil, err
+		}
+		s.chunks = append(s.chunks, chunk{messageID: id, vec: normalize(decode(blob))})
+	}
+
+	return s, rows.Err()
+}
+
+func saveChunks(p pending, model string, contents []string, vectors [][]float32) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Replace whatever an older model or an earlier version left behind
+	if _, err := tx.Exec(`DELETE FROM message_chunks WHERE account_id = ? AND gmail_id = ?`, p.accountID, p.messageID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for i := range contents {
+		_, err := tx.Exec(`
+			INSERT INTO message_chunks (account_id, user_id, gmail_id, chunk_index, content, model, embedding)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, p.accountID, p.userID, p.messageID, i, contents[i], model, encode(vectors[i]))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func deleteChunks(accountID int, messageID string) error {
+	_, err := db.DB.Exec(`DELETE FROM message_chunks WHERE account_id = ? AND gmail_id = ?`, accountID, messageID)
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.Exec(`DELETE FROM embedding_failures WHERE account_id = ? AND gmail_id = ?`, accountID, messageID)
+	return err
+}
+
+// deleteAccountChunks drops every chunk and recorded failure of a mailbox.
+func deleteAccountChunks(accountID int) error {
+	if _, err := db.DB.Exec(`DELETE FROM message_chunks WHERE account_id = ?`, accountID); err != nil {
+		return err
+	}
+	_, err := db.DB.Exec(`DELETE FROM embedding_failures WHERE account_id = ?`, accountID)
+	return err
+}
+
+type pending struct {
+	accountID int
+	userID    int
+	messageID string
+}
+
+// unembedded finds mirrored messages that have no chunks for model yet,
+// leaving out those that failed recently.
+func unembedded(model string, limit int) ([]pending, error) {
+	rows, err := db.DB.Query(`
+		SELECT m.account_id, m.user_id, m.gmail_id
+		FROM mail_messages m
+		LEFT JOIN message_chunks c
+			ON c.account_id = m.account_id AND c.gmail_id = m.gmail_id AND c.model = ?
+		LEFT JOIN embedding_failures f
+			ON f.account_id = m.account_id AND f.gmail_id = m.gmail_id AND f.model = ?
+		WHERE c.id IS NULL AND (f.retry_after IS NULL OR f.retry_after <= ?)
+		LIMIT ?
+	`, model, model, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.accountID, &p.userID, &p.messageID); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// recordFailure puts a message that failed to embed aside for a while,
+// longer after every attempt, so it can't hold up the rest of the queue.
+func recordFailure(p pending, model string, embedErr error) error {
+	var attempts int
+	err := db.DB.QueryRow(`
+		SELECT attempts FROM embedding_failures
+		WHERE account_id = ? AND gmail_id = ? AND model = ?
+	`, p.accountID, p.messageID, model).Scan(&attempts)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	attempts++
+
+	_, err = db.DB.Exec(`
+		INSERT INTO embedding_failures (account_id, gmail_id, model, attempts, last_error, retry_after)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE attempts = VALUES(attempts), last_error = VALUES(last_error),
+			retry_after = VALUES(retry_after)
+	`, p.accountID, p.messageID, model, attempts, embedErr.Error(), time.Now().Add(failureBackoff(attempts)).UTC())
+	return err
+}
+
+func clearFailure(p pending, model string) error {
+	_, err := db.DB.Exec(`
+		DELETE FROM embedding_failures WHERE account_id = ? AND gmail_id = ? AND model = ?
+	`, p.accountID, p.messageID, model)
+	return err
+}
+
+// failureBackoff is 10m, 20m, 40m... for successive failures, capped at a
+// day.
+func failureBackoff(attempts int) time.Duration {
+	if attempts > 8 {
+		return 24 * time.Hour
+	}
+	return min(10*time.Minute<<(attempts-1), 24*time.Hour)
+}